@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func testEntries() *ldap.SearchResult {
+	return &ldap.SearchResult{Entries: []*ldap.Entry{
+		ldap.NewEntry("CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com", map[string][]string{
+			"cn":   {"ccaaali"},
+			"mail": {"alice@example.com"},
+		}),
+		ldap.NewEntry(`CN=Smith\, Dave,OU=Users,DC=ad,DC=example,DC=com`, map[string][]string{
+			"memberOf": {"CN=rc-support,OU=Groups,DC=ad,DC=example,DC=com", "CN=physics-users,OU=Groups,DC=ad,DC=example,DC=com"},
+			// Binary values like this one shouldn't mess up the terminal.
+			"objectGUID": {"\x01\x02ab\x1bc"},
+		}),
+	}}
+}
+
+func TestEntryPrinting(t *testing.T) {
+	tests := []struct {
+		name  string
+		print func(*bytes.Buffer, *ldap.SearchResult)
+		want  string
+	}{
+		{"pretty",
+			func(b *bytes.Buffer, s *ldap.SearchResult) { PrettierPrint(b, s, 2) },
+			"  DN: CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com\n" +
+				"    cn: ccaaali\n" +
+				"    mail: alice@example.com\n" +
+				"  DN: CN=Smith\\, Dave,OU=Users,DC=ad,DC=example,DC=com\n" +
+				"    memberOf: CN=rc-support,OU=Groups,DC=ad,DC=example,DC=com\n" +
+				"    memberOf: CN=physics-users,OU=Groups,DC=ad,DC=example,DC=com\n" +
+				"    objectGUID: abc\n"},
+		{"pretty without indent",
+			func(b *bytes.Buffer, s *ldap.SearchResult) { PrettierEPrint(b, s.Entries[0], 0) },
+			"DN: CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com\n" +
+				"  cn: ccaaali\n" +
+				"  mail: alice@example.com\n"},
+		{"bare",
+			func(b *bytes.Buffer, s *ldap.SearchResult) { BarePrint(b, &ldap.SearchResult{Entries: s.Entries[:1]}) },
+			"ccaaali\nalice@example.com\n"},
+		{"nothing",
+			func(b *bytes.Buffer, s *ldap.SearchResult) { PrettierPrint(b, &ldap.SearchResult{}, 2) },
+			""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var output bytes.Buffer
+			tc.print(&output, testEntries())
+			if got := output.String(); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGroupPrinting(t *testing.T) {
+	groupMembers := []adhelper.GroupMember{
+		{Username: "ccaaali", Via: []string{"rc-admins"}},
+		{Username: "ccaabob", Via: []string{"rc-admins", "rc-support"}},
+	}
+	userGroups := []adhelper.UserGroup{
+		{Name: "everyone"},
+		{Name: "rc-support", Direct: true},
+	}
+	since := time.Date(2026, time.October, 1, 9, 30, 0, 0, time.UTC)
+	current := adhelper.GroupSnapshot{Members: []string{"ccaaali", "ccaacar"}}
+
+	tests := []struct {
+		name  string
+		print func(*bytes.Buffer)
+		want  string
+	}{
+		{"members",
+			func(b *bytes.Buffer) { writeGroupMembers(b, groupMembers, false) },
+			"ccaaali: rc-admins\nccaabob: rc-admins > rc-support\n"},
+		{"bare members",
+			func(b *bytes.Buffer) { writeGroupMembers(b, groupMembers, true) },
+			"ccaaali\nccaabob\n"},
+		{"user groups",
+			func(b *bytes.Buffer) { writeUserGroups(b, userGroups, false) },
+			"everyone (nested)\nrc-support\n"},
+		{"bare user groups",
+			func(b *bytes.Buffer) { writeUserGroups(b, userGroups, true) },
+			"everyone\nrc-support\n"},
+		{"first snapshot",
+			func(b *bytes.Buffer) {
+				writeGroupChange(b, adhelper.GroupChange{Group: "rc-admins", Added: current.Members}, current, false)
+			},
+			"No earlier snapshot of rc-admins: recorded 2 members.\n"},
+		{"no changes",
+			func(b *bytes.Buffer) {
+				writeGroupChange(b, adhelper.GroupChange{Group: "rc-admins", Since: since}, current, false)
+			},
+			"No changes to rc-admins since 2026-10-01 09:30:00.\n"},
+		{"changes",
+			func(b *bytes.Buffer) {
+				change := adhelper.GroupChange{Group: "rc-admins", Since: since, Added: []string{"ccaacar"}, Removed: []string{"ccaabob"}}
+				writeGroupChange(b, change, current, false)
+			},
+			"Changes to rc-admins since 2026-10-01 09:30:00:\n+ccaacar\n-ccaabob\n"},
+		{"bare changes",
+			func(b *bytes.Buffer) {
+				change := adhelper.GroupChange{Group: "rc-admins", Since: since, Added: []string{"ccaacar"}, Removed: []string{"ccaabob"}}
+				writeGroupChange(b, change, current, true)
+			},
+			"+ccaacar\n-ccaabob\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var output bytes.Buffer
+			tc.print(&output)
+			if got := output.String(); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTLSCheckPrinting(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "ldap.example.com"},
+		Issuer:    pkix.Name{CommonName: "Example CA"},
+		NotBefore: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:  time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	check := &adhelper.TLSCheck{
+		Version:     tls.VersionTLS12,
+		CipherSuite: tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		Chain:       []*x509.Certificate{cert},
+		VerifyErr:   x509.UnknownAuthorityError{Cert: cert},
+		PinErr:      adhelper.ErrPinMismatch,
+	}
+
+	var output bytes.Buffer
+	writeTLSCheck(&output, check)
+	want := "TLS 1.2, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256\n" +
+		"Certificate chain:\n" +
+		"  0: CN=ldap.example.com\n" +
+		"     Issuer: CN=Example CA\n" +
+		"     Valid: 2026-01-01 to 2027-01-01\n" +
+		"     Pin: sha256//" + adhelper.SPKIPin(cert) + "\n" +
+		"Verification: failed: " + adhelper.ExplainVerifyError(check.VerifyErr) + "\n" +
+		"Pinning: failed: " + adhelper.ExplainVerifyError(check.PinErr) + "\n"
+	if got := output.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -11,8 +13,6 @@ import (
 	"github.com/go-ldap/ldap/v3"
 )
 
-var defaultPasswordFiles = []string{os.Getenv("DINF_ADPWFILE"), os.Getenv("HOME") + "/.adpw", "/shared/ucl/etc/adpw"}
-
 var description = `
 A program to quickly query Active Directory for user and group data.
 `
@@ -20,57 +20,102 @@ A program to quickly query Active Directory for user and group data.
 var (
 	app = kingpin.New("dinf", description)
 
-	insecure    = app.Flag("insecure", "Insecurely ignore server certificate.").Short('i').Short('k').Bool()
+	insecure    = app.Flag("insecure", "Insecurely ignore server certificate. (Default: from config, or no)").Short('i').Short('k').IsSetByUser(&insecureSet).Bool()
 	searchField = app.Flag("search-field", "Field to search on. (e.g. mail, memberOf, sn)").Short('f').Default("CN").String()
 
-	ldapUrl       = app.Flag("server", "URL of the LDAP/AD server to connect to.").Short('s').Default("ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/").String()
-	bindUser      = app.Flag("user", "User to authenticate to the server with. (\"Bind\" user.)").Short('u').Default(`AD\sa-ritsldap01`).String()
+	profile       = app.Flag("profile", "Profile from the LDAP config files to use. (Default: from $AD_PROFILE or the config files)").PlaceHolder("name").String()
+	ldapUrl       = app.Flag("server", "URL of the LDAP/AD server to connect to. (Default: from config, or ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/)").Short('s').PlaceHolder("url").String()
+	bindUser      = app.Flag("user", "User to authenticate to the server with. (\"Bind\" user.) (Default: from config, or AD\\sa-ritsldap01)").Short('u').PlaceHolder("user").String()
 	bindPassword  = app.Flag("password", "Password to authenticate to the server with. (\"Bind\" password.) If empty or not provided, files will be used.").Short('p').Default("").String()
-	bindCredsFile = app.Flag("creds-file", "File to get bind credentials from. (Default: first of $DINF_ADPWFILE ~/.adpw /shared/ucl/etc/adpw)").Short('c').PlaceHolder("file").String()
-	certFile      = app.Flag("cert", "Certificate to use with LDAPS. (Default: DINF_CERT)").PlaceHolder("file").Default("@").String() // @ is unset marker
-	searchBase    = app.Flag("base", "Search base in the LDAP tree.").Short('b').Default("DC=ad,DC=ucl,DC=ac,DC=uk").String()
+	bindCredsFile = app.Flag("creds-file", "File to get bind credentials from. (Default: from config, or first of $DINF_ADPWFILE ~/.adpw /shared/ucl/etc/adpw)").Short('c').PlaceHolder("file").String()
+	certFile      = app.Flag("cert", "Certificate to use with LDAPS. (Default: from config, or $DINF_CERTFILE)").PlaceHolder("file").String()
+	bindMethod    = app.Flag("bind-method", "How to authenticate: simple (user and password), gssapi (Kerberos ticket, from kinit or --keytab) or external (--client-cert). (Default: from config, or simple)").Enum("simple", "gssapi", "external")
+	keytab        = app.Flag("keytab", "Keytab to get a Kerberos ticket with, for gssapi binds. (Default: from config, or the credentials cache)").PlaceHolder("file").String()
+	clientCert    = app.Flag("client-cert", "TLS client certificate, for external binds.").PlaceHolder("file").String()
+	clientKey     = app.Flag("client-key", "Key for the client certificate. (Default: in the certificate file)").PlaceHolder("file").String()
+	caFiles       = app.Flag("ca", "CA certificate file, or directory of them, to trust as well as --cert. Can be given more than once. (Default: from config)").PlaceHolder("path").Strings()
+	systemCAs     = app.Flag("add-system-cas", "Trust the system's CAs as well as --cert and --ca, rather than only those. (Default: from config, or no)").IsSetByUser(&systemCAsSet).Bool()
+	startTLS      = app.Flag("start-tls", "Upgrade ldap:// connections to TLS with StartTLS. (Default: from config, or no)").IsSetByUser(&startTLSSet).Bool()
+	minTLS        = app.Flag("min-tls", "Lowest TLS version to accept. (Default: from config, or 1.2)").Enum("1.0", "1.1", "1.2", "1.3")
+	pinnedKeys    = app.Flag("pin", "Base64 SHA-256 hash of a public key the server's verified certificate chain (or with --insecure, its own certificate) has to include, as shown by --check-tls. Can be given more than once.").PlaceHolder("hash").Strings()
+	searchBase    = app.Flag("base", "Search base in the LDAP tree. (Default: from config, or DC=ad,DC=ucl,DC=ac,DC=uk)").Short('b').PlaceHolder("dn").String()
 	returnFields  = app.Flag("output", "Command-separated fields to show in output. (Default: all)").Short('o').PlaceHolder("field[,field...]").String()
 
+	pageSize   = app.Flag("page-size", "Entries to fetch from the server at a time. (Default: 500)").PlaceHolder("n").Int()
+	sizeLimit  = app.Flag("size-limit", "Most entries to show. (Default: no limit)").Short('n').PlaceHolder("n").Int()
+	timeLimit  = app.Flag("time-limit", "Seconds the server can spend on each page of the search. (Default: no limit)").PlaceHolder("secs").Int()
+	scope      = app.Flag("scope", "How far below the search base to search. (Default: sub)").Enum("base", "one", "sub")
+	derefAlias = app.Flag("deref", "When to follow aliases. (Default: never)").Enum("never", "search", "find", "always")
+
 	bareVals  = app.Flag("bare", "Just print the values, without field names, no DN object labels.").Default("false").Bool()
 	rawQuery  = app.Flag("raw", "Mandatory argument is a raw query, don't build a query yourself.").Default("false").Bool()
 	quietMode = app.Flag("quiet", "Don't print output, just return 0 if there are results or 1 if not.").Default("false").Bool()
+	members   = app.Flag("members", "Search term is a group name: list the users in it, including through nested groups, with the groups they're in it through.").Default("false").Bool()
+	groups    = app.Flag("groups", "Search term is a username: list the groups they're in, including through nested groups.").Default("false").Bool()
+	snapshot  = app.Flag("snapshot", "With --members, record the group's members in this file, and show how they've changed since the last time.").PlaceHolder("file").String()
+	checkTLS  = app.Flag("check-tls", "Instead of searching, show the server's certificates and whether they'd be trusted, and why not.").Default("false").Bool()
+
+	searchTerm = app.Arg("search_term", "Search term (required, except with --check-tls).").Strings()
 
-	searchTerm = app.Arg("search_term", "Search term (required).").Required().Strings()
+	// Whether the flags that can turn config file settings off, with e.g.
+	//  --no-insecure, were given.
+	insecureSet, systemCAsSet, startTLSSet bool
 )
 
 func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
+	if len(*searchTerm) == 0 && !*checkTLS {
+		app.FatalUsage("required argument 'search_term' not provided\n")
+	}
 
-	var realPassword string
-
-	if *bindPassword == "" {
-		if *bindCredsFile != "" {
-			realPassword = readPassword([]string{*bindCredsFile})
-		} else {
-			realPassword = readPassword(defaultPasswordFiles)
-		}
-	} else {
-		realPassword = *bindPassword
+	// The old environment variable still works, overriding the config files
+	//  just like --cert.
+	if *certFile == "" {
+		*certFile = os.Getenv("DINF_CERTFILE")
 	}
 
-	// Override env var with cli arg, or set to nil if neither
-	certFileEnv, certFileEnvIsSet := os.LookupEnv("DINF_CERTFILE")
-	if *certFile == "@" {
-		// @ here means it was not set by cli arg
-		if certFileEnvIsSet {
-			*certFile = certFileEnv
-		} else {
-			*certFile = ""
-		}
+	ldapOpts, err := adhelper.LoadConfig(adhelper.ConfigOptions{
+		Profile: *profile,
+		Flags: adhelper.LdapOpts{
+			ServerUrl:    *ldapUrl,
+			Username:     *bindUser,
+			Password:     *bindPassword,
+			PasswordFile: *bindCredsFile,
+			BaseDN:       *searchBase,
+			Insecure:     setFlag(insecure, insecureSet),
+			CertFile:     *certFile,
+			TLS: adhelper.TLSOpts{
+				CAFiles:        *caFiles,
+				AddToSystemCAs: setFlag(systemCAs, systemCAsSet),
+				StartTLS:       setFlag(startTLS, startTLSSet),
+				MinVersion:     *minTLS,
+				PinnedKeys:     *pinnedKeys,
+			},
+			BindMethod: *bindMethod,
+			Kerberos: adhelper.KerberosOpts{
+				Keytab: *keytab,
+			},
+			ClientCertFile: *clientCert,
+			ClientKeyFile:  *clientKey,
+			Search: adhelper.SearchOptions{
+				PageSize:  *pageSize,
+				SizeLimit: *sizeLimit,
+				TimeLimit: *timeLimit,
+				Scope:     *scope,
+				Deref:     *derefAlias,
+			},
+		},
+		PasswordFiles: append([]string{os.Getenv("DINF_ADPWFILE")}, adhelper.DefaultPasswordFiles...),
+		// Checking TLS doesn't bind, so it works without any credentials.
+		NoPassword: *checkTLS,
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	ldapOpts := adhelper.LdapOpts{
-		ServerUrl: *ldapUrl,
-		Username:  *bindUser,
-		Password:  realPassword,
-		BaseDN:    *searchBase,
-		Insecure:  *insecure,
-		CertFile:  *certFile,
+	if *checkTLS {
+		printTLSCheck(ldapOpts)
+		return
 	}
 
 	splitReturnFields := strings.Split(*returnFields, ",")
@@ -78,69 +123,249 @@ func main() {
 		splitReturnFields = []string{}
 	}
 
-	var searchExpression string
+	// Wildcards in search terms are deliberate, but nothing else in them should
+	//  be taken as part of the filter.
+	var filter adhelper.Filter
 	if *rawQuery {
-		searchExpression = (*searchTerm)[0]
+		filter = adhelper.Raw((*searchTerm)[0])
 	} else {
-		searchExpression = fmt.Sprintf("(%s=%s)", *searchField, (*searchTerm)[0])
+		filter = adhelper.Wildcard(*searchField, (*searchTerm)[0])
 	}
 
-	result, err := adhelper.RunADSearch(&ldapOpts, searchExpression, splitReturnFields)
-	if err != nil {
+	client := adhelper.NewClient(ldapOpts)
+	defer client.Close()
+
+	if *snapshot != "" && !*members {
+		log.Fatalln("Error: --snapshot only works with --members.")
+	}
+	if *members && *groups {
+		log.Fatalln("Error: --members and --groups can't be used together.")
+	}
+	if *members && *snapshot != "" {
+		updateSnapshot(client, *snapshot, (*searchTerm)[0])
+		return
+	}
+	if *members {
+		printGroupMembers(client, (*searchTerm)[0])
+		return
+	}
+	if *groups {
+		printUserGroups(client, (*searchTerm)[0])
+		return
+	}
+
+	// Entries are printed as each page arrives, rather than all at the end,
+	//  so big searches show something straight away.
+	it := client.SearchIter(filter, splitReturnFields, adhelper.SearchOptions{})
+	found := 0
+	for it.Next() {
+		found++
+		if *quietMode {
+			break
+		}
+		if *bareVals {
+			BareEPrint(os.Stdout, it.Entry())
+		} else {
+			PrettierEPrint(os.Stdout, it.Entry(), 2)
+		}
+	}
+	it.Close()
+	err = it.Err()
+	if adhelper.IsLimitExceeded(err) {
+		if !*quietMode {
+			log.Printf("Warning: %s, so not all results are shown.", err)
+		}
+	} else if err != nil {
 		log.Fatal(err)
 	}
 
 	if *quietMode {
-		if len(result.Entries) == 0 {
+		if found == 0 {
 			os.Exit(1)
 		} else {
 			os.Exit(0)
 		}
 	}
 
-	if len(result.Entries) == 0 {
+	if found == 0 {
 		log.Fatalln("search result has no entries")
 	}
+}
 
-	if *bareVals {
-		BarePrint(result)
-	} else {
-		PrettierPrint(result, 2)
+// Returns a flag's value if it was given, or nil so that the config's is used.
+func setFlag(value *bool, set bool) *bool {
+	if !set {
+		return nil
+	}
+	return value
+}
+
+func printGroupMembers(client *adhelper.Client, group string) {
+	groupMembers, err := client.GetADGroupMembersNested(group)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *quietMode {
+		if len(groupMembers) == 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	writeGroupMembers(os.Stdout, groupMembers, *bareVals)
+}
+
+func writeGroupMembers(w io.Writer, groupMembers []adhelper.GroupMember, bare bool) {
+	for _, m := range groupMembers {
+		if bare {
+			fmt.Fprintln(w, m.Username)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", m.Username, strings.Join(m.Via, " > "))
+		}
+	}
+}
+
+func printUserGroups(client *adhelper.Client, username string) {
+	userGroups, err := client.GetUserGroups(username, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *quietMode {
+		if len(userGroups) == 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	writeUserGroups(os.Stdout, userGroups, *bareVals)
+}
+
+func writeUserGroups(w io.Writer, userGroups []adhelper.UserGroup, bare bool) {
+	for _, g := range userGroups {
+		if bare || g.Direct {
+			fmt.Fprintln(w, g.Name)
+		} else {
+			fmt.Fprintf(w, "%s (nested)\n", g.Name)
+		}
+	}
+}
+
+// Takes a new snapshot of a group's members, and shows what's changed since the last.
+func updateSnapshot(client *adhelper.Client, filename string, group string) {
+	snapshots, err := adhelper.LoadSnapshots(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	current, err := client.SnapshotGroup(group)
+	if err != nil {
+		log.Fatal(err)
+	}
+	change := snapshots.Update(group, current)
+	err = snapshots.Save(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *quietMode {
+		if change.Changed() {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	writeGroupChange(os.Stdout, change, current, *bareVals)
+}
+
+func writeGroupChange(w io.Writer, change adhelper.GroupChange, current adhelper.GroupSnapshot, bare bool) {
+	if change.Since.IsZero() {
+		fmt.Fprintf(w, "No earlier snapshot of %s: recorded %d members.\n", change.Group, len(current.Members))
+		return
+	}
+	if !change.Changed() {
+		fmt.Fprintf(w, "No changes to %s since %s.\n", change.Group, change.Since.Format("2006-01-02 15:04:05"))
+		return
+	}
+	if !bare {
+		fmt.Fprintf(w, "Changes to %s since %s:\n", change.Group, change.Since.Format("2006-01-02 15:04:05"))
+	}
+	for _, m := range change.Added {
+		fmt.Fprintf(w, "+%s\n", m)
+	}
+	for _, m := range change.Removed {
+		fmt.Fprintf(w, "-%s\n", m)
+	}
+}
+
+func printTLSCheck(ldapOpts *adhelper.LdapOpts) {
+	check, err := adhelper.CheckTLS(ldapOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*quietMode {
+		writeTLSCheck(os.Stdout, check)
+	}
+	if !check.OK() {
+		os.Exit(1)
+	}
+}
+
+func writeTLSCheck(w io.Writer, check *adhelper.TLSCheck) {
+	fmt.Fprintf(w, "TLS %s, %s\n", adhelper.TLSVersionName(check.Version), tls.CipherSuiteName(check.CipherSuite))
+	fmt.Fprintln(w, "Certificate chain:")
+	for i, cert := range check.Chain {
+		fmt.Fprintf(w, "  %d: %s\n", i, cert.Subject)
+		fmt.Fprintf(w, "     Issuer: %s\n", cert.Issuer)
+		fmt.Fprintf(w, "     Valid: %s to %s\n", cert.NotBefore.Format("2006-01-02"), cert.NotAfter.Format("2006-01-02"))
+		fmt.Fprintf(w, "     Pin: sha256//%s\n", adhelper.SPKIPin(cert))
+	}
+
+	switch {
+	case check.VerifyErr == nil:
+		fmt.Fprintln(w, "Verification: OK")
+	case check.Insecure:
+		fmt.Fprintf(w, "Verification: failed, but ignored with --insecure: %s\n", adhelper.ExplainVerifyError(check.VerifyErr))
+	default:
+		fmt.Fprintf(w, "Verification: failed: %s\n", adhelper.ExplainVerifyError(check.VerifyErr))
+	}
+	if check.PinErr != nil {
+		fmt.Fprintf(w, "Pinning: failed: %s\n", adhelper.ExplainVerifyError(check.PinErr))
 	}
 }
 
 // I abstracted the pretty-printing functions from the ldap package so I could alter the format.
 
 // PrettierPrint outputs a human-readable description with indenting
-func PrettierPrint(s *ldap.SearchResult, indent int) {
+func PrettierPrint(w io.Writer, s *ldap.SearchResult, indent int) {
 	for _, entry := range s.Entries {
-		PrettierEPrint(entry, indent)
+		PrettierEPrint(w, entry, indent)
 	}
 }
 
 // PrettierPrint outputs a human-readable description with indenting
-func PrettierEAPrint(e *ldap.EntryAttribute, indent int) {
+func PrettierEAPrint(w io.Writer, e *ldap.EntryAttribute, indent int) {
 	for _, v := range e.Values {
-		fmt.Printf("%s%s: %s\n", strings.Repeat(" ", indent), e.Name, filterPrintable(v))
+		fmt.Fprintf(w, "%s%s: %s\n", strings.Repeat(" ", indent), e.Name, filterPrintable(v))
 	}
 	//fmt.Printf("%s%s: %#v\n", strings.Repeat(" ", indent), e.Name, e.Values)
 }
 
 // PrettierPrint outputs a human-readable description indenting
-func PrettierEPrint(e *ldap.Entry, indent int) {
-	fmt.Printf("%sDN: %s\n", strings.Repeat(" ", indent), e.DN)
+func PrettierEPrint(w io.Writer, e *ldap.Entry, indent int) {
+	fmt.Fprintf(w, "%sDN: %s\n", strings.Repeat(" ", indent), e.DN)
 	for _, attr := range e.Attributes {
-		PrettierEAPrint(attr, indent+2)
+		PrettierEAPrint(w, attr, indent+2)
 	}
 }
 
 // Bare value output, for scripting
-func BarePrint(s *ldap.SearchResult) {
+func BarePrint(w io.Writer, s *ldap.SearchResult) {
 	for _, entry := range s.Entries {
-		for _, attr := range entry.Attributes {
-			for _, v := range attr.Values {
-				fmt.Printf("%s\n", string(v))
-			}
+		BareEPrint(w, entry)
+	}
+}
+
+// Bare value output for one entry
+func BareEPrint(w io.Writer, e *ldap.Entry) {
+	for _, attr := range e.Attributes {
+		for _, v := range attr.Values {
+			fmt.Fprintf(w, "%s\n", string(v))
 		}
 	}
 }
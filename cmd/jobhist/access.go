@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper"
+	"gopkg.in/yaml.v3"
+)
+
+// Who can see whose jobs is set in a site file, like:
+//
+//	admin_group: rc-admins
+//	admin_users: [ccspapp]
+//	shared_groups: [rc-group-a, rc-group-b]
+//	audit_log: syslog
+//	ldap:
+//	  server_url: ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/
+//	  bind_username: AD\sa-ritsldap01
+//	  base_dn: DC=ad,DC=ucl,DC=ac,DC=uk
+//	ldap_password_file: /shared/ucl/etc/adpw
+//
+// The LDAP settings are taken only from here, not through adhelper.LoadConfig,
+// for the same reason as below: a user's own config could point group lookups
+// at a server that says they're an admin.
+//
+// Without the file, everyone but root only gets to see their own jobs.
+//
+// Bear in mind that the DB credentials are built into jobhist, so this
+//  keeps honest people honest rather than being a real security boundary.
+
+// This is deliberately not settable from the environment or flags, or users
+// could just point it at their own file.
+const accessFile = "/shared/ucl/etc/jobhist-access.yaml"
+
+type accessConfig struct {
+	AdminGroup       string            `yaml:"admin_group"`   // Members can see everyone's jobs.
+	AdminUsers       []string          `yaml:"admin_users"`   // These users can too.
+	SharedGroups     []string          `yaml:"shared_groups"` // Members can see each other's jobs.
+	AuditLog         string            `yaml:"audit_log"`     // "syslog", or a file to append to.
+	LDAP             adhelper.LdapOpts `yaml:"ldap"`
+	LDAPPasswordFile string            `yaml:"ldap_password_file"`
+}
+
+var ErrAdminOnly = errors.New("only admins can do that")
+
+// Where group members are looked up: an *adhelper.Client, or a fake in the
+// tests.
+type groupLookup interface {
+	GetADGroupUsers(group string) ([]string, error)
+}
+
+// An access is what the user running jobhist is allowed to see.
+type access struct {
+	config accessConfig
+	user   string
+	ldap   groupLookup // Made when first needed, then shared by all the group lookups.
+
+	// These need LDAP lookups, so they're only worked out if needed.
+	adminChecked bool
+	admin        bool
+	visible      []string
+}
+
+func loadAccess() (*access, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("could not find out who you are: %w", err)
+	}
+
+	config := accessConfig{
+		AuditLog: "syslog",
+		LDAP: adhelper.LdapOpts{
+			ServerUrl: "ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/",
+			Username:  `AD\sa-ritsldap01`,
+			BaseDN:    "DC=ad,DC=ucl,DC=ac,DC=uk",
+		},
+		LDAPPasswordFile: "/shared/ucl/etc/adpw",
+	}
+	contents, err := os.ReadFile(accessFile)
+	if err == nil {
+		err = yaml.Unmarshal(contents, &config)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse access file %s: %w", accessFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read access file: %w", err)
+	}
+
+	a := &access{config: config, user: currentUser.Username}
+	if currentUser.Uid == "0" {
+		a.admin = true
+		a.adminChecked = true
+	}
+	return a, nil
+}
+
+// Gets the users in an AD group, including through nested groups, using the
+// bind credentials from the access file.
+func (a *access) groupMembers(group string) ([]string, error) {
+	if a.ldap == nil {
+		if a.config.LDAP.Password == "" {
+			passwordFile := a.config.LDAPPasswordFile
+			if a.config.LDAP.PasswordFile != "" {
+				passwordFile = a.config.LDAP.PasswordFile
+			}
+			password, err := adhelper.ReadPasswordFile([]string{passwordFile})
+			if err != nil {
+				return nil, err
+			}
+			a.config.LDAP.Password = password
+		}
+		a.ldap = adhelper.NewClient(&a.config.LDAP)
+	}
+	return a.ldap.GetADGroupUsers(group)
+}
+
+func (a *access) isAdmin() bool {
+	if a.adminChecked {
+		return a.admin
+	}
+	a.adminChecked = true
+
+	for _, u := range a.config.AdminUsers {
+		if u == a.user {
+			a.admin = true
+			return true
+		}
+	}
+	if a.config.AdminGroup == "" {
+		return false
+	}
+	members, err := a.groupMembers(a.config.AdminGroup)
+	if err != nil {
+		// Not being able to check just means no admin powers this time.
+		log.Printf("Warning: could not check admin group membership: %s", err)
+		return false
+	}
+	for _, m := range members {
+		if strings.EqualFold(m, a.user) {
+			a.admin = true
+			break
+		}
+	}
+	return a.admin
+}
+
+// Returns the users whose jobs a non-admin can see: themselves, and anyone
+// in a shared group with them.
+func (a *access) visibleUsers() []string {
+	if a.visible != nil {
+		return a.visible
+	}
+	a.visible = []string{a.user}
+	for _, group := range a.config.SharedGroups {
+		members, err := a.groupMembers(group)
+		if err != nil {
+			log.Printf("Warning: could not get members of group %s: %s", group, err)
+			continue
+		}
+		inGroup := false
+		for _, m := range members {
+			if strings.EqualFold(m, a.user) {
+				inGroup = true
+				break
+			}
+		}
+		if !inGroup {
+			continue
+		}
+		for _, m := range members {
+			m = strings.ToLower(m)
+			if accounting.IsValidUsername(m) && !stringInList(m, a.visible) {
+				a.visible = append(a.visible, m)
+			}
+		}
+	}
+	return a.visible
+}
+
+func stringInList(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches a username against a user search pattern, which can have wildcards.
+func userPatternMatches(pattern string, username string) bool {
+	pattern = strings.NewReplacer("%", "*", "_", "?").Replace(pattern)
+	matched, err := path.Match(pattern, username)
+	return err == nil && matched
+}
+
+// Limits a query to the jobs the user is allowed to see, or returns an error
+// if they asked for something they're not allowed. Anything that might show
+// someone else's jobs is written to the audit log.
+func (a *access) restrict(command string, q *accounting.Query) error {
+	// If no user is given it means yourself, unless it's a search for a
+	//  job number, where it means anyone.
+	pattern := q.User
+	if pattern == "" {
+		pattern = a.user
+		if q.JobNumber > 0 {
+			pattern = "*"
+		}
+	}
+
+	justSelf := (pattern == a.user) && (q.Where == "")
+	for _, u := range q.Users {
+		if u != a.user {
+			justSelf = false
+		}
+	}
+	if justSelf {
+		// Set explicitly, because the query would otherwise go by $USER, which
+		//  is easily changed.
+		q.User = a.user
+		return nil
+	}
+
+	if a.isAdmin() {
+		return a.audit(command, *q)
+	}
+
+	if q.Where != "" {
+		return fmt.Errorf("only admins can use --query")
+	}
+
+	visible := a.visibleUsers()
+	var allowed []string
+	if len(q.Users) > 0 {
+		for _, u := range q.Users {
+			if stringInList(u, visible) {
+				allowed = append(allowed, u)
+			}
+		}
+	} else {
+		if !strings.ContainsAny(pattern, "*?%_") && !stringInList(pattern, visible) {
+			return fmt.Errorf("you don't have permission to see %s's jobs", pattern)
+		}
+		for _, u := range visible {
+			if userPatternMatches(pattern, u) {
+				allowed = append(allowed, u)
+			}
+		}
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("you don't have permission to see any of the users' jobs searched for")
+	}
+	if q.User != "" {
+		if len(visible) == 1 {
+			log.Printf("Note: only showing your own jobs.")
+		} else {
+			log.Printf("Note: only showing jobs from you and your groups.")
+		}
+	}
+
+	q.User = ""
+	q.Users = allowed
+	if (len(allowed) == 1) && (allowed[0] == a.user) {
+		return nil
+	}
+	return a.audit(command, *q)
+}
+
+// Checks the user is an admin, for things that need to see everyone's jobs.
+func (a *access) requireAdmin(command string) error {
+	if !a.isAdmin() {
+		return ErrAdminOnly
+	}
+	q := accounting.NewQuery()
+	q.User = "*"
+	return a.audit(command, q)
+}
+
+func (a *access) audit(command string, q accounting.Query) error {
+	users := q.User
+	if len(q.Users) > 0 {
+		users = strings.Join(q.Users, ",")
+	}
+	message := fmt.Sprintf("user=%s admin=%t command=%s cluster=%s users=%q job=%d where=%q args=%q",
+		a.user, a.admin, command, q.Cluster, users, q.JobNumber, q.Where, os.Args[1:])
+
+	// If we can't record the search, it doesn't happen.
+	if a.config.AuditLog == "syslog" {
+		logger, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, "jobhist")
+		if err != nil {
+			return fmt.Errorf("could not write to audit log: %w", err)
+		}
+		defer logger.Close()
+		err = logger.Notice(message)
+		if err != nil {
+			return fmt.Errorf("could not write to audit log: %w", err)
+		}
+		return nil
+	}
+
+	file, err := os.OpenFile(a.config.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write to audit log: %w", err)
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "%s %s\n", time.Now().Format(time.RFC3339), message)
+	if err != nil {
+		return fmt.Errorf("could not write to audit log: %w", err)
+	}
+	return nil
+}
+
+// Loads the access rules, exiting if they can't be.
+func mustLoadAccess() *access {
+	a, err := loadAccess()
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+	return a
+}
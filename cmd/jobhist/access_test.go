@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+)
+
+// Group members by group name. Groups not in it can't be looked up.
+type fakeGroups map[string][]string
+
+func (g fakeGroups) GetADGroupUsers(group string) ([]string, error) {
+	members, ok := g[group]
+	if !ok {
+		return nil, errors.New("no such group")
+	}
+	return members, nil
+}
+
+var testGroups = fakeGroups{
+	"rc-admins": {"ccspapp", "CCAAADM"},
+	"project-a": {"ccaaali", "ccaabob", "NOT A USER", "CCAACAR"},
+	"project-b": {"ccaadan", "ccaaeve"},
+}
+
+// Makes the access for a user, with shared groups and a file audit log.
+func testAccess(t *testing.T, user string) (*access, string) {
+	t.Helper()
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	return &access{
+		config: accessConfig{
+			AdminGroup:   "rc-admins",
+			AdminUsers:   []string{"ccaaroot"},
+			SharedGroups: []string{"project-a", "project-b", "missing"},
+			AuditLog:     auditLog,
+		},
+		user: user,
+		ldap: testGroups,
+	}, auditLog
+}
+
+// Returns the lines written to an audit log, or none if it wasn't written.
+func auditLines(t *testing.T, auditLog string) []string {
+	t.Helper()
+	contents, err := os.ReadFile(auditLog)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(contents)), "\n")
+}
+
+func TestIsAdmin(t *testing.T) {
+	tests := []struct {
+		user string
+		want bool
+	}{
+		{"ccaaroot", true}, // In admin_users.
+		{"ccspapp", true},  // In the admin group.
+		{"ccaaadm", true},  // In the admin group, in different case.
+		{"ccaaali", false},
+	}
+	for _, tc := range tests {
+		a, _ := testAccess(t, tc.user)
+		if got := a.isAdmin(); got != tc.want {
+			t.Errorf("%s: got %t, want %t", tc.user, got, tc.want)
+		}
+	}
+
+	// If the group can't be looked up, no one gets in through it.
+	a, _ := testAccess(t, "ccspapp")
+	a.config.AdminGroup = "missing"
+	if a.isAdmin() {
+		t.Error("got admin when the admin group couldn't be looked up")
+	}
+}
+
+func TestVisibleUsers(t *testing.T) {
+	tests := []struct {
+		user string
+		want []string
+	}{
+		// Members are lowercased, and names that can't be usernames dropped.
+		{"ccaaali", []string{"ccaaali", "ccaabob", "ccaacar"}},
+		{"ccaadan", []string{"ccaadan", "ccaaeve"}},
+		{"ccaafay", []string{"ccaafay"}},
+	}
+	for _, tc := range tests {
+		a, _ := testAccess(t, tc.user)
+		if got := a.visibleUsers(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.user, got, tc.want)
+		}
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      string
+		query     accounting.Query
+		wantErr   bool
+		wantUser  string
+		wantUsers []string
+		wantAudit bool
+	}{
+		{"own jobs", "ccaaali",
+			accounting.Query{}, false, "ccaaali", nil, false},
+		{"own jobs by name", "ccaaali",
+			accounting.Query{User: "ccaaali"}, false, "ccaaali", nil, false},
+		{"shared group member", "ccaaali",
+			accounting.Query{User: "ccaabob"}, false, "", []string{"ccaabob"}, true},
+		{"someone else", "ccaaali",
+			accounting.Query{User: "ccaadan"}, true, "", nil, false},
+		{"wildcard", "ccaaali",
+			accounting.Query{User: "ccaa?o*"}, false, "", []string{"ccaabob"}, true},
+		{"SQL wildcard", "ccaaali",
+			accounting.Query{User: "ccaa_a%"}, false, "", []string{"ccaacar"}, true},
+		{"wildcard matching only yourself", "ccaaali",
+			accounting.Query{User: "*ali"}, false, "", []string{"ccaaali"}, false},
+		{"wildcard matching no one visible", "ccaaali",
+			accounting.Query{User: "ccaad*"}, true, "", nil, false},
+		{"everyone", "ccaaali",
+			accounting.Query{User: "*"}, false, "", []string{"ccaaali", "ccaabob", "ccaacar"}, true},
+		{"job number", "ccaaali",
+			accounting.Query{JobNumber: 1001}, false, "", []string{"ccaaali", "ccaabob", "ccaacar"}, true},
+		{"job number without shared groups", "ccaafay",
+			accounting.Query{JobNumber: 1001}, false, "", []string{"ccaafay"}, false},
+		{"users list", "ccaaali",
+			accounting.Query{Users: []string{"ccaaali", "ccaadan", "ccaabob"}}, false, "", []string{"ccaaali", "ccaabob"}, true},
+		{"users list of just yourself", "ccaaali",
+			accounting.Query{Users: []string{"ccaaali"}}, false, "ccaaali", []string{"ccaaali"}, false},
+		{"users list of strangers", "ccaaali",
+			accounting.Query{Users: []string{"ccaadan", "ccaaeve"}}, true, "", nil, false},
+		{"raw query", "ccaaali",
+			accounting.Query{Where: "1=1"}, true, "", nil, false},
+		{"admin", "ccspapp",
+			accounting.Query{User: "*"}, false, "*", nil, true},
+		{"admin's job number search", "ccspapp",
+			accounting.Query{JobNumber: 1001}, false, "", nil, true},
+		{"admin's raw query", "ccspapp",
+			accounting.Query{Where: "1=1"}, false, "", nil, true},
+		{"admin's own jobs", "ccspapp",
+			accounting.Query{}, false, "ccspapp", nil, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a, auditLog := testAccess(t, tc.user)
+			q := tc.query
+			err := a.restrict("search", &q)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got query for %q %v, want an error", q.User, q.Users)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if q.User != tc.wantUser || !reflect.DeepEqual(q.Users, tc.wantUsers) {
+					t.Errorf("got query for %q %v, want %q %v", q.User, q.Users, tc.wantUser, tc.wantUsers)
+				}
+			}
+
+			lines := auditLines(t, auditLog)
+			if tc.wantAudit && (len(lines) != 1 || !strings.Contains(lines[0], "user="+tc.user+" ")) {
+				t.Errorf("got audit log %q, want one line for %s", lines, tc.user)
+			}
+			if !tc.wantAudit && len(lines) > 0 {
+				t.Errorf("got audit log %q, want nothing", lines)
+			}
+		})
+	}
+}
+
+func TestAuditFailsClosed(t *testing.T) {
+	a, _ := testAccess(t, "ccspapp")
+	a.config.AuditLog = filepath.Join(t.TempDir(), "missing", "audit.log")
+
+	q := accounting.Query{User: "*"}
+	if err := a.restrict("search", &q); err == nil {
+		t.Error("admin search went ahead without being audited")
+	}
+	if err := a.requireAdmin("nodes"); err == nil {
+		t.Error("admin command went ahead without being audited")
+	}
+
+	// A non-admin looking at a shared group member's jobs is audited too.
+	a, _ = testAccess(t, "ccaaali")
+	a.config.AuditLog = filepath.Join(t.TempDir(), "missing", "audit.log")
+	q = accounting.Query{User: "ccaabob"}
+	if err := a.restrict("search", &q); err == nil {
+		t.Error("search for someone else's jobs went ahead without being audited")
+	}
+
+	// Your own jobs don't need auditing, so they still work.
+	q = accounting.Query{}
+	if err := a.restrict("search", &q); err != nil {
+		t.Errorf("search for own jobs failed without an audit log: %s", err)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	a, auditLog := testAccess(t, "ccaaali")
+	if err := a.requireAdmin("nodes"); !errors.Is(err, ErrAdminOnly) {
+		t.Errorf("got %v for a non-admin, want ErrAdminOnly", err)
+	}
+	if lines := auditLines(t, auditLog); len(lines) > 0 {
+		t.Errorf("got audit log %q for a refused command", lines)
+	}
+
+	a, auditLog = testAccess(t, "ccspapp")
+	if err := a.requireAdmin("nodes"); err != nil {
+		t.Fatal(err)
+	}
+	lines := auditLines(t, auditLog)
+	if len(lines) != 1 || !strings.Contains(lines[0], "command=nodes") || !strings.Contains(lines[0], "admin=true") {
+		t.Errorf("got audit log %q, want a line for the nodes command", lines)
+	}
+}
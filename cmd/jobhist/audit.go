@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/olekukonko/tablewriter"
+)
+
+var (
+	auditCmd = kingpin.Command("audit", "Check a cluster's accounting DB for duplicate rows and impossible or missing values.")
+
+	auditDays      = auditCmd.Flag("days", "Number of days of jobs to check. (0 for all, which can take a long time on a big accounting table.)").Default("30").Int()
+	auditExamples  = auditCmd.Flag("examples", "Number of example rows to show for each problem found.").Default("5").Int()
+	auditRepairSQL = auditCmd.Flag("repair-sql", "Print SQL that would fix the problems that can be fixed, instead of the report. (Nothing is run.)").Bool()
+)
+
+func runAudit() {
+	if *auditDays < 0 {
+		log.Fatal("Error: --days can't be negative.")
+	}
+	if *auditExamples < 0 {
+		log.Fatal("Error: --examples can't be negative.")
+	}
+
+	// The examples are other users' jobs.
+	err := mustLoadAccess().requireAdmin("audit")
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	resolveCluster()
+
+	query := accounting.NewQuery()
+	query.Cluster = *searchCluster
+	query.User = "*"
+	if *auditDays == 0 {
+		query.NoLimits = true
+	} else {
+		query.EndedAfter = time.Now().AddDate(0, 0, -*auditDays)
+	}
+	if *searchMHost != "(none)" {
+		query.MasterHost = *searchMHost
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	examples := *auditExamples
+	if *auditRepairSQL {
+		examples = 0
+	}
+	findings, err := client.Audit(context.Background(), query, accounting.AuditChecks, examples)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	if *auditRepairSQL {
+		err = printRepairSQL(os.Stdout, findings, query)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+		return
+	}
+	printAuditReport(os.Stdout, findings)
+}
+
+func auditPeriod() string {
+	if *auditDays == 0 {
+		return "all jobs"
+	}
+	return fmt.Sprintf("last %d days", *auditDays)
+}
+
+func printAuditReport(w io.Writer, findings []accounting.Finding) {
+	problems := 0
+	for _, f := range findings {
+		problems += f.Count
+	}
+	fmt.Fprintf(w, "Checked %s on %s: %d problem row(s).\n\n", auditPeriod(), *searchCluster, problems)
+
+	table := tablewriter.NewWriter(w)
+	if *hideHeader == false {
+		table.SetHeader([]string{"check", "rows", "repair", "description"})
+	}
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, f := range findings {
+		repair := ""
+		if f.Check.Repair != "" {
+			repair = "yes"
+		}
+		table.Append([]string{f.Check.Name, strconv.Itoa(f.Count), repair, f.Check.Description})
+	}
+	table.Render()
+
+	for _, f := range findings {
+		if len(f.Examples) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s: %d row(s)", f.Check.Name, f.Count)
+		if f.Count > len(f.Examples) {
+			fmt.Fprintf(w, ", showing the most recent %d", len(f.Examples))
+		}
+		fmt.Fprintln(w, ":")
+		printJobData(w, f.Examples, f.Check.Elements, -1)
+	}
+
+	if problems > 0 {
+		fmt.Fprintln(w, "\nUse --repair-sql to get SQL for fixing the problems that have a repair.")
+	}
+}
+
+// Prints the repair statements as a script that can be checked and then fed to
+// mysql, with everything else as comments.
+func printRepairSQL(w io.Writer, findings []accounting.Finding, query accounting.Query) error {
+	fmt.Fprintf(w, "-- Repairs for %s on %s, generated %s.\n", auditPeriod(), *searchCluster, time.Now().Format(time.RFC3339))
+	fmt.Fprintln(w, "-- Check these before running them: they change the accounting DB.")
+	for _, f := range findings {
+		if f.Count == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n-- %s: %d row(s), %s\n", f.Check.Name, f.Count, f.Check.Description)
+		if f.Check.Repair == "" {
+			fmt.Fprintln(w, "-- No automatic repair: these need looking at by hand.")
+			continue
+		}
+		if f.Check.RepairNote != "" {
+			fmt.Fprintf(w, "-- Note: %s\n", f.Check.RepairNote)
+		}
+		statement, err := f.Check.RepairSQL(query)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s;\n", statement)
+	}
+	return nil
+}
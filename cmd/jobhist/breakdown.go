@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/olekukonko/tablewriter"
+)
+
+// The elements shown for each host row in a job breakdown.
+var breakdownElements = []string{"hostname", "pe_taskid", "slots", "ewalltime", "cpu", "mem", "io", "iow", "maxvmem"}
+
+// Prints each host row for a job with its own usage figures, plus totals and an imbalance figure.
+func printJobBreakdown(w io.Writer, jobs []accounting.Job) {
+	// If accounting_summary is turned off in the scheduler, a parallel job gets one
+	//  master row plus one row per slave task started through qrsh -inherit, each with
+	//  its own pe_taskid and usage figures. The master row has pe_taskid "NONE".
+	// If accounting_summary is on, there's only ever one row per job (or task), so
+	//  the breakdown doesn't tell you much beyond the usual output.
+	if len(jobs) == 0 {
+		fmt.Fprintf(w, "No entries found.\n")
+		return
+	}
+
+	// Array jobs have a set of rows per task, and it doesn't make sense to compare
+	//  hosts across tasks, so we do a separate breakdown for each.
+	taskRows := make(map[int][]*accounting.Job)
+	var taskNumbers []int
+	for i := range jobs {
+		row := &jobs[i]
+		if _, seen := taskRows[row.TaskNumber]; !seen {
+			taskNumbers = append(taskNumbers, row.TaskNumber)
+		}
+		taskRows[row.TaskNumber] = append(taskRows[row.TaskNumber], row)
+	}
+	sort.Ints(taskNumbers)
+
+	for i, taskNumber := range taskNumbers {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printTaskBreakdown(w, taskRows[taskNumber])
+	}
+}
+
+func printTaskBreakdown(w io.Writer, rows []*accounting.Job) {
+	first := rows[0]
+	if first.TaskNumber != 0 {
+		fmt.Fprintf(w, "Job %d, task %d (%s, owner %s): %d host row(s)\n", first.JobNumber, first.TaskNumber, first.JobName, first.Owner, len(rows))
+	} else {
+		fmt.Fprintf(w, "Job %d (%s, owner %s): %d host row(s)\n", first.JobNumber, first.JobName, first.Owner, len(rows))
+	}
+
+	table := tablewriter.NewWriter(w)
+	if *hideHeader == false {
+		table.SetHeader(breakdownElements)
+	}
+	table.SetBorder(false)
+
+	var totalCPU, totalMem, totalIO, totalIOW, maxCPU, maxVMem float64
+	for _, row := range rows {
+		rowBuffer := make([]string, len(breakdownElements))
+		for i, elementName := range breakdownElements {
+			rowBuffer[i] = accounting.FormatElement(row, elementName)
+		}
+		table.Append(rowBuffer)
+
+		totalCPU += row.Cpu
+		totalMem += row.Mem
+		totalIO += row.Io
+		totalIOW += row.Iow
+		if row.Cpu > maxCPU {
+			maxCPU = row.Cpu
+		}
+		if row.Maxvmem > maxVMem {
+			maxVMem = row.Maxvmem
+		}
+	}
+
+	// Everything in the footer is a sum, except maxvmem, which is the largest seen.
+	if *hideHeader == false {
+		table.SetFooter([]string{
+			"total", "", "", "",
+			strconv.FormatFloat(totalCPU, 'G', 9, 32),
+			strconv.FormatFloat(totalMem, 'G', 9, 32),
+			strconv.FormatFloat(totalIO, 'G', 9, 32),
+			strconv.FormatFloat(totalIOW, 'G', 9, 32),
+			strconv.FormatFloat(maxVMem, 'G', 9, 32),
+		})
+	}
+	table.Render()
+
+	if len(rows) == 1 {
+		fmt.Fprintln(w, "Only one row was recorded for this job, so there is no per-host breakdown available.")
+		return
+	}
+
+	// Imbalance here is just the busiest host's CPU time over the mean: 1.0 means
+	//  the work was spread perfectly evenly, 2.0 means one host did twice its share.
+	meanCPU := totalCPU / float64(len(rows))
+	if meanCPU > 0 {
+		fmt.Fprintf(w, "CPU imbalance (max/mean): %.2f\n", maxCPU/meanCPU)
+	} else {
+		fmt.Fprintln(w, "CPU imbalance (max/mean): (no CPU time recorded)")
+	}
+}
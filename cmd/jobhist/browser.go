@@ -0,0 +1,518 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/gdamore/tcell/v2"
+)
+
+// The interactive browser for search results, for -I.
+
+const (
+	modeBrowse = iota
+	modeFilter
+	modeColumns
+)
+
+// Columns get no wider than this, so one long job name doesn't push everything else off screen.
+const maxColumnWidth = 40
+
+const browserHelp = "q quit  ↑↓ move  ←→ column  s sort  / filter  c columns  enter details"
+
+type browser struct {
+	screen tcell.Screen
+
+	allJobs []accounting.Job // As found.
+	jobs    []accounting.Job // Filtered and sorted, as shown.
+	columns []string
+	widths  []int
+
+	cursor     int // Selected row of jobs.
+	top        int // First row of jobs shown.
+	column     int // Selected column.
+	left       int // First column shown.
+	sortedBy   string
+	sortDesc   bool
+	filter     string
+	mode       int
+	showDetail bool
+	detailTop  int // First element shown in the detail pane.
+	picker     int // Selected row of the column picker.
+	pickable   []accounting.ElementDesc
+}
+
+func runBrowser(jobs []accounting.Job, columns []string) error {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return fmt.Errorf("could not start terminal UI: %w", err)
+	}
+	err = screen.Init()
+	if err != nil {
+		return fmt.Errorf("could not start terminal UI: %w", err)
+	}
+	defer screen.Fini()
+
+	b := &browser{
+		screen:  screen,
+		allJobs: jobs,
+		columns: append([]string(nil), columns...),
+	}
+	// Element sets can't be columns themselves, and nor can placeholders like
+	//  cat_l_<resource>.
+	for _, desc := range accounting.ElementDescriptions {
+		if _, isSet := accounting.ElementSets[desc.Label]; !isSet && !strings.Contains(desc.Label, "<") {
+			b.pickable = append(b.pickable, desc)
+		}
+	}
+	b.applyView()
+
+	for {
+		b.draw()
+		switch ev := screen.PollEvent().(type) {
+		case *tcell.EventResize:
+			screen.Sync()
+		case *tcell.EventKey:
+			if b.handleKey(ev) {
+				return nil
+			}
+		}
+	}
+}
+
+// Re-filters and re-sorts the jobs, e.g. after the filter or columns change.
+func (b *browser) applyView() {
+	filter := strings.ToLower(b.filter)
+	b.jobs = b.jobs[:0]
+	for i := range b.allJobs {
+		if filter == "" || b.rowMatches(&b.allJobs[i], filter) {
+			b.jobs = append(b.jobs, b.allJobs[i])
+		}
+	}
+	if b.sortedBy != "" {
+		accounting.SortJobs(b.jobs, b.sortedBy, b.sortDesc)
+	}
+
+	if b.cursor >= len(b.jobs) {
+		b.cursor = len(b.jobs) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	if b.column >= len(b.columns) {
+		b.column = len(b.columns) - 1
+	}
+	if b.column < 0 {
+		b.column = 0
+	}
+
+	b.widths = make([]int, len(b.columns))
+	for i, column := range b.columns {
+		// Leaves room for the sort arrow.
+		b.widths[i] = len(column) + 2
+		for j := range b.jobs {
+			if width := len(accounting.FormatElement(&b.jobs[j], column)); width > b.widths[i] {
+				b.widths[i] = width
+			}
+		}
+		if b.widths[i] > maxColumnWidth {
+			b.widths[i] = maxColumnWidth
+		}
+	}
+}
+
+// Filters match any shown column, ignoring case.
+func (b *browser) rowMatches(job *accounting.Job, lowerFilter string) bool {
+	for _, column := range b.columns {
+		if strings.Contains(strings.ToLower(accounting.FormatElement(job, column)), lowerFilter) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true when it's time to quit.
+func (b *browser) handleKey(ev *tcell.EventKey) bool {
+	if ev.Key() == tcell.KeyCtrlC {
+		return true
+	}
+
+	switch b.mode {
+	case modeFilter:
+		switch ev.Key() {
+		case tcell.KeyEnter:
+			b.mode = modeBrowse
+		case tcell.KeyEscape:
+			b.filter = ""
+			b.mode = modeBrowse
+			b.applyView()
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if b.filter != "" {
+				filter := []rune(b.filter)
+				b.filter = string(filter[:len(filter)-1])
+				b.applyView()
+			}
+		case tcell.KeyRune:
+			b.filter += string(ev.Rune())
+			b.applyView()
+		}
+		return false
+
+	case modeColumns:
+		switch ev.Key() {
+		case tcell.KeyUp:
+			b.picker = clamp(b.picker-1, 0, len(b.pickable)-1)
+		case tcell.KeyDown:
+			b.picker = clamp(b.picker+1, 0, len(b.pickable)-1)
+		case tcell.KeyEnter:
+			b.toggleColumn(b.pickable[b.picker].Label)
+		case tcell.KeyEscape:
+			b.mode = modeBrowse
+		case tcell.KeyRune:
+			switch ev.Rune() {
+			case 'k':
+				b.picker = clamp(b.picker-1, 0, len(b.pickable)-1)
+			case 'j':
+				b.picker = clamp(b.picker+1, 0, len(b.pickable)-1)
+			case ' ':
+				b.toggleColumn(b.pickable[b.picker].Label)
+			case 'c', 'q':
+				b.mode = modeBrowse
+			}
+		}
+		return false
+	}
+
+	page := b.tableHeight() - 1
+	switch ev.Key() {
+	case tcell.KeyUp:
+		b.moveCursor(-1)
+	case tcell.KeyDown:
+		b.moveCursor(1)
+	case tcell.KeyPgUp:
+		b.moveCursor(-page)
+	case tcell.KeyPgDn:
+		b.moveCursor(page)
+	case tcell.KeyHome:
+		b.moveCursor(-len(b.jobs))
+	case tcell.KeyEnd:
+		b.moveCursor(len(b.jobs))
+	case tcell.KeyLeft:
+		b.column = clamp(b.column-1, 0, len(b.columns)-1)
+	case tcell.KeyRight:
+		b.column = clamp(b.column+1, 0, len(b.columns)-1)
+	case tcell.KeyEnter:
+		b.showDetail = !b.showDetail
+	case tcell.KeyEscape:
+		if b.filter != "" {
+			b.filter = ""
+			b.applyView()
+		}
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'q':
+			return true
+		case 'k':
+			b.moveCursor(-1)
+		case 'j':
+			b.moveCursor(1)
+		case 'g':
+			b.moveCursor(-len(b.jobs))
+		case 'G':
+			b.moveCursor(len(b.jobs))
+		case 'h':
+			b.column = clamp(b.column-1, 0, len(b.columns)-1)
+		case 'l':
+			b.column = clamp(b.column+1, 0, len(b.columns)-1)
+		case 'd':
+			b.showDetail = !b.showDetail
+		case '[':
+			b.detailTop = clamp(b.detailTop-b.detailHeight(), 0, len(accounting.AllElements)-1)
+		case ']':
+			b.detailTop = clamp(b.detailTop+b.detailHeight(), 0, len(accounting.AllElements)-1)
+		case 's':
+			if len(b.columns) > 0 {
+				column := b.columns[b.column]
+				if b.sortedBy == column {
+					b.sortDesc = !b.sortDesc
+				} else {
+					b.sortedBy = column
+					b.sortDesc = false
+				}
+				b.applyView()
+			}
+		case '/':
+			b.mode = modeFilter
+		case 'c':
+			b.mode = modeColumns
+		}
+	}
+	return false
+}
+
+func (b *browser) moveCursor(by int) {
+	b.cursor = clamp(b.cursor+by, 0, len(b.jobs)-1)
+}
+
+// Adds a column at the end if it isn't shown, or removes it if it is.
+func (b *browser) toggleColumn(element string) {
+	for i, column := range b.columns {
+		if column == element {
+			b.columns = append(b.columns[:i], b.columns[i+1:]...)
+			if b.sortedBy == element {
+				b.sortedBy = ""
+			}
+			b.applyView()
+			return
+		}
+	}
+	b.columns = append(b.columns, element)
+	b.applyView()
+}
+
+func clamp(n int, min int, max int) int {
+	if n > max {
+		n = max
+	}
+	if n < min {
+		n = min
+	}
+	return n
+}
+
+// Rows of the screen used for the table, including its header.
+func (b *browser) tableHeight() int {
+	_, height := b.screen.Size()
+	// The last line is the status bar.
+	height -= 1
+	if b.showDetail {
+		// There are a lot of elements, so the details get most of the room.
+		height = height / 3
+	}
+	if height < 2 {
+		height = 2
+	}
+	return height
+}
+
+// Rows of the screen used for the detail pane's elements.
+func (b *browser) detailHeight() int {
+	_, height := b.screen.Size()
+	// Minus the status bar and the line between the table and details.
+	height = height - b.tableHeight() - 2
+	if height < 1 {
+		height = 1
+	}
+	return height
+}
+
+// Draws text at a position, cut off at a width. Returns how much width was used.
+func drawText(screen tcell.Screen, x int, y int, width int, style tcell.Style, text string) int {
+	runes := []rune(text)
+	if len(runes) > width {
+		if width <= 1 {
+			runes = runes[:width]
+		} else {
+			runes = append(runes[:width-1], '…')
+		}
+	}
+	for i, r := range runes {
+		screen.SetContent(x+i, y, r, nil, style)
+	}
+	return len(runes)
+}
+
+func (b *browser) draw() {
+	b.screen.Clear()
+	switch b.mode {
+	case modeColumns:
+		b.drawColumnPicker()
+	default:
+		b.drawTable()
+		if b.showDetail {
+			b.drawDetail()
+		}
+	}
+	b.drawStatus()
+	b.screen.Show()
+}
+
+func (b *browser) drawTable() {
+	screenWidth, _ := b.screen.Size()
+	height := b.tableHeight()
+	rows := height - 1
+
+	// Keep the selected row and column in view.
+	if b.cursor < b.top {
+		b.top = b.cursor
+	}
+	if b.cursor >= b.top+rows {
+		b.top = b.cursor - rows + 1
+	}
+	if b.column < b.left {
+		b.left = b.column
+	}
+	for b.column > b.left && b.columnsEnd(b.left, b.column) > screenWidth {
+		b.left += 1
+	}
+
+	header := tcell.StyleDefault.Bold(true).Underline(true)
+	x := 0
+	for i := b.left; i < len(b.columns) && x < screenWidth; i++ {
+		style := header
+		if i == b.column {
+			style = style.Reverse(true)
+		}
+		label := b.columns[i]
+		if b.sortedBy == b.columns[i] {
+			if b.sortDesc {
+				label += " ▼"
+			} else {
+				label += " ▲"
+			}
+		}
+		drawText(b.screen, x, 0, clamp(b.widths[i], 0, screenWidth-x), style, label)
+		x += b.widths[i] + 2
+	}
+
+	for row := 0; row < rows && b.top+row < len(b.jobs); row++ {
+		job := &b.jobs[b.top+row]
+		style := tcell.StyleDefault
+		if b.top+row == b.cursor {
+			style = style.Reverse(true)
+		}
+		x := 0
+		for i := b.left; i < len(b.columns) && x < screenWidth; i++ {
+			width := clamp(b.widths[i]+2, 0, screenWidth-x)
+			// Fill the gap between columns too, so the selected row is one solid bar.
+			for gap := 0; gap < width; gap++ {
+				b.screen.SetContent(x+gap, row+1, ' ', nil, style)
+			}
+			drawText(b.screen, x, row+1, clamp(b.widths[i], 0, screenWidth-x), style, accounting.FormatElement(job, b.columns[i]))
+			x += b.widths[i] + 2
+		}
+	}
+
+	if len(b.jobs) == 0 {
+		drawText(b.screen, 0, 1, screenWidth, tcell.StyleDefault, "No entries found.")
+	}
+}
+
+// Returns the screen column just after the end of column last, if drawing starts at column first.
+func (b *browser) columnsEnd(first int, last int) int {
+	end := 0
+	for i := first; i <= last; i++ {
+		end += b.widths[i] + 2
+	}
+	return end
+}
+
+// Shows every element of the selected job, laid out in as many columns as fit.
+// If they don't all fit, [ and ] scroll through them.
+func (b *browser) drawDetail() {
+	screenWidth, _ := b.screen.Size()
+	top := b.tableHeight() + 1
+	height := b.detailHeight()
+	if len(b.jobs) == 0 {
+		return
+	}
+
+	drawText(b.screen, 0, top-1, screenWidth, tcell.StyleDefault, strings.Repeat("─", screenWidth))
+
+	job := &b.jobs[b.cursor]
+	elements := accounting.AllElements[b.detailTop:]
+	x := 0
+	for len(elements) > 0 {
+		chunk := elements
+		if len(chunk) > height {
+			chunk = chunk[:height]
+		}
+
+		nameWidth, valueWidth := 0, 0
+		values := make([]string, len(chunk))
+		for i, element := range chunk {
+			values[i] = accounting.FormatElement(job, element)
+			if len(element) > nameWidth {
+				nameWidth = len(element)
+			}
+			if len(values[i]) > valueWidth {
+				valueWidth = len(values[i])
+			}
+		}
+		if valueWidth > maxColumnWidth {
+			valueWidth = maxColumnWidth
+		}
+		width := nameWidth + 2 + valueWidth
+		// The first column always goes in, even if it has to be cut off.
+		if (x > 0) && (x+width > screenWidth) {
+			break
+		}
+
+		for i, element := range chunk {
+			drawText(b.screen, x, top+i, nameWidth, tcell.StyleDefault.Bold(true), element)
+			drawText(b.screen, x+nameWidth+2, top+i, clamp(valueWidth, 0, screenWidth-x-nameWidth-2), tcell.StyleDefault, values[i])
+		}
+		x += width + 3
+		elements = elements[len(chunk):]
+	}
+
+	if (len(elements) > 0) || (b.detailTop > 0) {
+		more := fmt.Sprintf(" %d-%d of %d elements, [ and ] to scroll ",
+			b.detailTop+1, len(accounting.AllElements)-len(elements), len(accounting.AllElements))
+		drawText(b.screen, clamp(screenWidth-len(more), 0, screenWidth), top-1, screenWidth, tcell.StyleDefault, more)
+	}
+}
+
+func (b *browser) drawColumnPicker() {
+	screenWidth, screenHeight := b.screen.Size()
+	rows := screenHeight - 2
+
+	drawText(b.screen, 0, 0, screenWidth, tcell.StyleDefault.Bold(true), "Columns (space or enter toggles, esc closes)")
+
+	first := 0
+	if b.picker >= rows {
+		first = b.picker - rows + 1
+	}
+	for row := 0; row < rows && first+row < len(b.pickable); row++ {
+		desc := b.pickable[first+row]
+		mark := "[ ]"
+		for _, column := range b.columns {
+			if column == desc.Label {
+				mark = "[x]"
+				break
+			}
+		}
+		style := tcell.StyleDefault
+		if first+row == b.picker {
+			style = style.Reverse(true)
+		}
+		drawText(b.screen, 0, row+1, screenWidth, style, fmt.Sprintf("%s %-15s  %s", mark, desc.Label, desc.Description))
+	}
+}
+
+func (b *browser) drawStatus() {
+	screenWidth, screenHeight := b.screen.Size()
+	style := tcell.StyleDefault.Reverse(true)
+	for x := 0; x < screenWidth; x++ {
+		b.screen.SetContent(x, screenHeight-1, ' ', nil, style)
+	}
+
+	var status string
+	switch b.mode {
+	case modeFilter:
+		status = "/" + b.filter + "_"
+	case modeColumns:
+		status = fmt.Sprintf("%d columns shown", len(b.columns))
+	default:
+		status = fmt.Sprintf("%d/%d of %d jobs", b.cursor+1, len(b.jobs), len(b.allJobs))
+		if len(b.jobs) == 0 {
+			status = fmt.Sprintf("0 of %d jobs", len(b.allJobs))
+		}
+		if b.filter != "" {
+			status += fmt.Sprintf("  filter: %s", b.filter)
+		}
+		status += "  |  " + browserHelp
+	}
+	drawText(b.screen, 0, screenHeight-1, screenWidth, style, status)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/olekukonko/tablewriter"
+)
+
+func parseWarnThresholds(s string) []float64 {
+	var thresholds []float64
+	for _, field := range strings.Split(s, ",") {
+		if field == "" {
+			continue
+		}
+		t, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			log.Fatalf("Error: invalid warning threshold: %s", field)
+		}
+		thresholds = append(thresholds, t)
+	}
+	sort.Float64s(thresholds)
+	return thresholds
+}
+
+// Returns the highest threshold that the percentage has reached, or -1 if none.
+func highestThresholdReached(percentUsed float64, thresholds []float64) float64 {
+	reached := -1.0
+	for _, t := range thresholds {
+		if percentUsed >= t {
+			reached = t
+		}
+	}
+	return reached
+}
+
+func formatHours(h float64) string {
+	return strconv.FormatFloat(h, 'f', 1, 64)
+}
+
+func printUsageSummary(w io.Writer, jobs []accounting.Job, groupBy string, budgetFile string, warnAt string) {
+	if len(jobs) == 0 {
+		fmt.Fprintf(w, "No entries found.\n")
+		return
+	}
+
+	totals, err := accounting.SumUsage(jobs, groupBy)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	var allocations map[string]accounting.Allocation
+	if budgetFile != "" {
+		allocations, err = accounting.ReadBudgetFile(budgetFile)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+	}
+	thresholds := parseWarnThresholds(warnAt)
+
+	header := []string{groupBy, "jobs", "core_hours", "gpu_hours"}
+	if allocations != nil {
+		header = append(header, "core_alloc", "core_used%", "core_left", "gpu_alloc", "gpu_used%", "gpu_left")
+	}
+
+	table := tablewriter.NewWriter(w)
+	if *hideHeader == false {
+		table.SetHeader(header)
+	}
+	table.SetBorder(false)
+
+	var warnings []string
+	for _, t := range totals {
+		name := t.Name
+		if name == "" {
+			name = "(none)"
+		}
+		line := []string{name, strconv.Itoa(t.Jobs), formatHours(t.CoreHours), formatHours(t.GPUHours)}
+
+		if allocations != nil {
+			a, hasAllocation := allocations[t.Name]
+			if !hasAllocation {
+				line = append(line, "-", "-", "-", "-", "-", "-")
+				table.Append(line)
+				continue
+			}
+
+			corePercent := accounting.PercentOf(t.CoreHours, a.CoreHours)
+			line = append(line, formatHours(a.CoreHours), formatPercent(corePercent), formatHours(a.CoreHours-t.CoreHours))
+			if reached := highestThresholdReached(corePercent, thresholds); reached >= 0 {
+				warnings = append(warnings, fmt.Sprintf("%s has used %s of its core-hour allocation (warning threshold: %g%%)", name, formatPercent(corePercent), reached))
+			}
+
+			if a.HasGPU {
+				gpuPercent := accounting.PercentOf(t.GPUHours, a.GPUHours)
+				line = append(line, formatHours(a.GPUHours), formatPercent(gpuPercent), formatHours(a.GPUHours-t.GPUHours))
+				if reached := highestThresholdReached(gpuPercent, thresholds); reached >= 0 {
+					warnings = append(warnings, fmt.Sprintf("%s has used %s of its GPU-hour allocation (warning threshold: %g%%)", name, formatPercent(gpuPercent), reached))
+				}
+			} else {
+				line = append(line, "-", "-", "-")
+			}
+		}
+		table.Append(line)
+	}
+	table.Render()
+
+	for _, w := range warnings {
+		log.Printf("Warning: %s.", w)
+	}
+}
+
+func formatPercent(p float64) string {
+	return strconv.FormatFloat(p, 'f', 1, 64) + "%"
+}
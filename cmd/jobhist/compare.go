@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/olekukonko/tablewriter"
+)
+
+var compareHelp = "Set of jobs, as a comma-separated list of <key>=<value> with keys: " +
+	"period (year-month), user (wildcards okay), group (AD group), host (wildcards okay). " +
+	"Anything not given comes from the usual search flags."
+
+var (
+	compareCmd = kingpin.Command("compare", "Compare usage between two sets of jobs, e.g. two months or two groups.")
+
+	compareA = compareCmd.Arg("a", compareHelp).Required().String()
+	compareB = compareCmd.Arg("b", compareHelp).Required().String()
+
+	compareProfile       = compareCmd.Flag("ldap-profile", "Profile from the LDAP config files to use. (Default: from $AD_PROFILE or the config files)").PlaceHolder("name").String()
+	compareLdapUrl       = compareCmd.Flag("ldap-server", "URL of the LDAP/AD server to look up groups on. (Default: from config, or ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/)").PlaceHolder("url").String()
+	compareBindUser      = compareCmd.Flag("bind-user", "User to look up groups with. (\"Bind\" user.) (Default: from config, or AD\\sa-ritsldap01)").PlaceHolder("user").String()
+	compareBindCredsFile = compareCmd.Flag("creds-file", "File to get bind credentials from. (Default: from config, or first of $JOBHIST_ADPWFILE ~/.adpw /shared/ucl/etc/adpw)").PlaceHolder("file").Default("").String()
+	compareLdapBase      = compareCmd.Flag("ldap-base", "Search base in the LDAP tree. (Default: from config, or DC=ad,DC=ucl,DC=ac,DC=uk)").PlaceHolder("dn").String()
+)
+
+// Applies a comparison filter spec like "period=2026-08,user=*" to a query.
+func applyCompareSpec(query *accounting.Query, spec string) error {
+	for _, item := range strings.Split(spec, ",") {
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("expected <key>=<value> in %q", item)
+		}
+		switch key {
+		case "period":
+			query.EndPeriod = value
+			query.BackHours = -1
+		case "user":
+			query.User = value
+		case "group":
+			members, err := lookUpGroupUsers(value)
+			if err != nil {
+				return err
+			}
+			query.Users = members
+		case "host":
+			query.MasterHost = value
+		default:
+			return fmt.Errorf("unknown key %q in %q", key, spec)
+		}
+	}
+	return nil
+}
+
+// Returns the members of an AD group that could have run jobs.
+func lookUpGroupUsers(group string) ([]string, error) {
+	ldapOpts, err := adhelper.LoadConfig(adhelper.ConfigOptions{
+		Profile: *compareProfile,
+		Flags: adhelper.LdapOpts{
+			ServerUrl:    *compareLdapUrl,
+			Username:     *compareBindUser,
+			PasswordFile: *compareBindCredsFile,
+			BaseDN:       *compareLdapBase,
+		},
+		PasswordFiles: append([]string{os.Getenv("JOBHIST_ADPWFILE")}, adhelper.DefaultPasswordFiles...),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := adhelper.GetADGroupUsers(ldapOpts, group)
+	if err != nil {
+		return nil, fmt.Errorf("could not get members of group %s: %w", group, err)
+	}
+
+	// Groups can have members that aren't people with cluster accounts, e.g.
+	//  service accounts, and those would just make the query invalid.
+	var users []string
+	for _, member := range members {
+		member = strings.ToLower(member)
+		if accounting.IsValidUsername(member) {
+			users = append(users, member)
+		} else if *debug {
+			log.Printf("Skipping group member %s, which can't be a cluster user", member)
+		}
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("group %s has no members that could be cluster users", group)
+	}
+	return users, nil
+}
+
+func runCompare() {
+	resolveCluster()
+
+	access := mustLoadAccess()
+	client := getClient()
+	defer client.Close()
+
+	var summaries [2]accounting.Summary
+	var userCounts [2]int
+	for i, spec := range []string{*compareA, *compareB} {
+		query := queryFromFlags()
+		err := applyCompareSpec(&query, spec)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+		err = access.restrict("compare", &query)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+
+		jobs, err := client.Find(context.Background(), query)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+		summaries[i] = accounting.Summarise(jobs, 0)
+
+		byOwner, err := accounting.SummariseBy(jobs, "owner", 0)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+		userCounts[i] = len(byOwner)
+	}
+
+	printComparison(os.Stdout, *compareA, *compareB, summaries, userCounts)
+}
+
+// A row in a comparison: the two values, and how to show them.
+type comparedMetric struct {
+	name   string
+	a, b   float64
+	format string
+}
+
+func printComparison(w io.Writer, labelA string, labelB string, s [2]accounting.Summary, users [2]int) {
+	metrics := []comparedMetric{
+		{"jobs", float64(s[0].Jobs), float64(s[1].Jobs), "%.0f"},
+		{"users", float64(users[0]), float64(users[1]), "%.0f"},
+		{"failed jobs", float64(s[0].Failed), float64(s[1].Failed), "%.0f"},
+		{"success rate (%)", s[0].SuccessRate(), s[1].SuccessRate(), "%.1f"},
+		{"core-hours", s[0].CoreHours, s[1].CoreHours, "%.1f"},
+		{"GPU-hours", s[0].GPUHours, s[1].GPUHours, "%.1f"},
+		{"mean wait (hours)", s[0].MeanWait.Hours(), s[1].MeanWait.Hours(), "%.2f"},
+		{"mean CPU efficiency (%)", s[0].MeanEfficiencyPercent(), s[1].MeanEfficiencyPercent(), "%.1f"},
+	}
+
+	table := tablewriter.NewWriter(w)
+	if *hideHeader == false {
+		table.SetHeader([]string{"metric", labelA, labelB, "change", "change (%)"})
+	}
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT})
+	for _, m := range metrics {
+		percentChange := "-"
+		if m.a != 0 {
+			percentChange = fmt.Sprintf("%+.1f", 100*(m.b-m.a)/m.a)
+		}
+		table.Append([]string{
+			m.name,
+			fmt.Sprintf(m.format, m.a),
+			fmt.Sprintf(m.format, m.b),
+			fmt.Sprintf("%+"+m.format[1:], m.b-m.a),
+			percentChange,
+		})
+	}
+	table.Render()
+}
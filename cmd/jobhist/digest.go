@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var (
+	digestCmd = kingpin.Command("digest", "Summarise each user's jobs over the last week or month, compared to the one before.")
+
+	digestPeriod     = digestCmd.Flag("period", "Period to summarise (weekly|monthly).").Default("weekly").Enum("weekly", "monthly")
+	digestEnding     = digestCmd.Flag("ending", "Summarise the week before this date, or the month before the one it's in. (Default: today)").PlaceHolder("<year-month-day>").Default("").String()
+	digestFormat     = digestCmd.Flag("format", "Format to write digests in (text|markdown|html).").Default("text").Enum("text", "markdown", "html")
+	digestTemplate   = digestCmd.Flag("template", "Template file to use instead of the built-in one for the format.").PlaceHolder("<file>").Default("").String()
+	digestTopFailing = digestCmd.Flag("top-failing", "Number of most-often-failing job names to list.").Default("5").Int()
+	digestMail       = digestCmd.Flag("mail", "Email each digest to its user through the local MTA instead of printing it.").Bool()
+	digestMailDomain = digestCmd.Flag("mail-domain", "Domain to add to usernames to get email addresses.").Default("ucl.ac.uk").String()
+	digestMailFrom   = digestCmd.Flag("mail-from", "Address to send digests from.").Default("rc-support@ucl.ac.uk").String()
+	digestSendmail   = digestCmd.Flag("sendmail", "Path to sendmail.").Default("/usr/sbin/sendmail").String()
+	digestHomeFile   = digestCmd.Flag("home-file", "Write each digest to a file with this name in its user's home directory instead of printing it.").PlaceHolder("<filename>").Default("").String()
+)
+
+//go:embed templates/digest.*
+var digestTemplateFiles embed.FS
+
+// What digest templates are given to fill in.
+type digestData struct {
+	User     string
+	Cluster  string
+	Period   string // "week" or "month"
+	Start    time.Time
+	End      time.Time
+	Current  accounting.Summary
+	Previous accounting.Summary
+}
+
+var digestFuncs = map[string]interface{}{
+	"hours": func(h float64) string {
+		return strconv.FormatFloat(h, 'f', 1, 64)
+	},
+	"percent": func(p float64) string {
+		return strconv.FormatFloat(p, 'f', 0, 64) + "%"
+	},
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+	"wait": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+	// Describes the change from a previous figure, e.g. "+12%".
+	"change": func(currentValue, previousValue interface{}) string {
+		current, previous := toFloat(currentValue), toFloat(previousValue)
+		if previous == 0 {
+			if current == 0 {
+				return "no change"
+			}
+			return "none last time"
+		}
+		return fmt.Sprintf("%+.0f%%", 100*(current-previous)/previous)
+	},
+	// Returns the day before, for showing inclusive end dates.
+	"dayBefore": func(t time.Time) time.Time {
+		return t.AddDate(0, 0, -1)
+	},
+}
+
+func toFloat(n interface{}) float64 {
+	switch v := n.(type) {
+	case int:
+		return float64(v)
+	case time.Duration:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// Anything that can render a digest, so text and HTML templates can be used the same way.
+type digestRenderer interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+func loadDigestTemplate() digestRenderer {
+	var contents []byte
+	var err error
+	if *digestTemplate != "" {
+		contents, err = os.ReadFile(*digestTemplate)
+	} else {
+		extension := map[string]string{"text": "txt", "markdown": "md", "html": "html"}[*digestFormat]
+		contents, err = digestTemplateFiles.ReadFile("templates/digest." + extension)
+	}
+	if err != nil {
+		log.Fatalf("Error: could not read digest template: %s.", err)
+	}
+
+	// HTML gets html/template so that job names can't mess with the markup.
+	if *digestFormat == "html" {
+		t, err := htmltemplate.New("digest").Funcs(digestFuncs).Parse(string(contents))
+		if err != nil {
+			log.Fatalf("Error: could not parse digest template: %s.", err)
+		}
+		return t
+	}
+	t, err := template.New("digest").Funcs(digestFuncs).Parse(string(contents))
+	if err != nil {
+		log.Fatalf("Error: could not parse digest template: %s.", err)
+	}
+	return t
+}
+
+// Returns the start and end of the period to summarise, and of the one before it.
+func digestPeriods(ending string, period string) (start, end, prevStart time.Time, err error) {
+	now := time.Now()
+	end = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if ending != "" {
+		end, err = time.ParseInLocation("2006-01-02", ending, time.Local)
+		if err != nil {
+			return start, end, prevStart, fmt.Errorf("invalid date for --ending, please use year-month-day, e.g. 2022-11-28")
+		}
+	}
+
+	if period == "monthly" {
+		end = time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, time.Local)
+		start = end.AddDate(0, -1, 0)
+		prevStart = start.AddDate(0, -1, 0)
+	} else {
+		start = end.AddDate(0, 0, -7)
+		prevStart = start.AddDate(0, 0, -7)
+	}
+	return start, end, prevStart, nil
+}
+
+func runDigest() {
+	if *digestMail && (*digestHomeFile != "") {
+		log.Fatal("Error: only one of --mail and --home-file can be used.")
+	}
+	if strings.ContainsRune(*digestHomeFile, '/') {
+		log.Fatal("Error: --home-file should be a file name, not a path.")
+	}
+
+	resolveCluster()
+
+	start, end, prevStart, err := digestPeriods(*digestEnding, *digestPeriod)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+	renderer := loadDigestTemplate()
+
+	query := accounting.NewQuery()
+	query.Cluster = *searchCluster
+	query.User = *searchUser
+	query.EndedAfter = prevStart
+	query.EndedBefore = end
+	err = mustLoadAccess().restrict("digest", &query)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	jobs, err := client.Find(context.Background(), query)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	// One query covers both periods, so split them up afterwards.
+	var currentJobs, previousJobs []accounting.Job
+	for _, job := range jobs {
+		jobTime := time.Unix(int64(job.EndTime), 0)
+		if job.EndTime == 0 {
+			jobTime = time.Unix(int64(job.SubmissionTime), 0)
+		}
+		if jobTime.Before(start) {
+			previousJobs = append(previousJobs, job)
+		} else {
+			currentJobs = append(currentJobs, job)
+		}
+	}
+
+	current, err := accounting.SummariseBy(currentJobs, "owner", *digestTopFailing)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+	previous, err := accounting.SummariseBy(previousJobs, "owner", *digestTopFailing)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	// Users who didn't run anything this time don't get a digest.
+	users := make([]string, 0, len(current))
+	for owner := range current {
+		users = append(users, owner)
+	}
+	sort.Strings(users)
+
+	periodName := "week"
+	if *digestPeriod == "monthly" {
+		periodName = "month"
+	}
+
+	failures := 0
+	for i, username := range users {
+		data := digestData{
+			User:     username,
+			Cluster:  *searchCluster,
+			Period:   periodName,
+			Start:    start,
+			End:      end,
+			Current:  current[username],
+			Previous: previous[username],
+		}
+
+		var buf bytes.Buffer
+		err := renderer.Execute(&buf, data)
+		if err != nil {
+			log.Fatalf("Error: could not fill in digest template: %s.", err)
+		}
+
+		switch {
+		case *digestMail:
+			err = mailDigest(username, data, buf.Bytes())
+		case *digestHomeFile != "":
+			err = writeHomeDigest(username, buf.Bytes())
+		default:
+			if i > 0 {
+				fmt.Println()
+			}
+			_, err = os.Stdout.Write(buf.Bytes())
+		}
+		if err != nil {
+			// Carry on with everyone else, because one user's missing home
+			//  directory shouldn't stop the rest getting theirs.
+			log.Printf("Error: could not deliver digest for %s: %s.", username, err)
+			failures += 1
+		}
+	}
+
+	if failures > 0 {
+		log.Fatalf("Error: %d of %d digests could not be delivered.", failures, len(users))
+	}
+}
+
+func mailDigest(username string, data digestData, body []byte) error {
+	contentType := "text/plain; charset=utf-8"
+	if *digestFormat == "html" {
+		contentType = "text/html; charset=utf-8"
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\n", *digestMailFrom)
+	fmt.Fprintf(&message, "To: %s@%s\n", username, *digestMailDomain)
+	fmt.Fprintf(&message, "Subject: Your jobs on %s, %s to %s\n", data.Cluster, data.Start.Format("2006-01-02"), data.End.AddDate(0, 0, -1).Format("2006-01-02"))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\n")
+	fmt.Fprintf(&message, "Content-Type: %s\n", contentType)
+	fmt.Fprintf(&message, "\n")
+	message.Write(body)
+
+	// -t takes the recipients from the headers, -oi stops a line with just a dot ending the message.
+	cmd := exec.Command(*digestSendmail, "-t", "-oi")
+	cmd.Stdin = &message
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sendmail failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func writeHomeDigest(username string, body []byte) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(u.HomeDir, *digestHomeFile)
+
+	// Users can put whatever they like in their home directories, so a previous
+	//  digest is removed rather than written over, and links aren't followed: otherwise
+	//  a link there could get us to write over some other file.
+	err = os.Remove(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// If we're root (which we'd have to be to write to everyone's home), the file
+	//  should belong to the user so they can read it and tidy it up.
+	if os.Geteuid() == 0 {
+		uid, _ := strconv.Atoi(u.Uid)
+		gid, _ := strconv.Atoi(u.Gid)
+		return os.Lchown(filename, uid, gid)
+	}
+	return nil
+}
@@ -1,58 +1,38 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"strings"
-	"time"
-	"unicode/utf8"
 
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
 	"github.com/UCL-RITS/go-clustertools/internal/clusters"
 	"github.com/alecthomas/kingpin/v2"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/olekukonko/tablewriter"
 )
 
-var dbConnString = "ccspapp:U4Ah+fSt@tcp(db.rc.ucl.ac.uk:3306)/"
-
-func getDBConn() (*sql.DB, error) {
-	// Might need allowNativePasswords=True in future - need to look into it more
-	//con, err := sql.Open("mysql", "ccspapp:U4Ah+fSt@tcp(mysql.rc.ucl.ac.uk:3306)/?allowNativePasswords=True")
-	return sql.Open("mysql", dbConnString)
-}
-
-func getJobData(query string) []*accountingRow {
-	con, err := getDBConn()
-	defer con.Close()
-
+func getClient() *accounting.Client {
+	client, err := accounting.NewClient(accounting.DefaultDSN)
 	if err != nil {
-		fmt.Println(err)
-	}
-
-	rows, err := con.Query(query)
-
-	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Error: %s.", err)
 	}
-
-	accountingRows := accountingRowsAssign(rows)
-
-	return accountingRows
+	client.Debug = *debug
+	return client
 }
 
-func printJobData(rows []*accountingRow, elements []string) {
-	if len(rows) == 0 {
-		if *searchBackHours > -1 {
-			fmt.Printf("No entries found. (Last %d hours searched.)\n", *searchBackHours)
+func printJobData(w io.Writer, jobs []accounting.Job, elements []string, backHours int) {
+	if len(jobs) == 0 {
+		if backHours > -1 {
+			fmt.Fprintf(w, "No entries found. (Last %d hours searched.)\n", backHours)
 		} else {
-			fmt.Printf("No entries found.\n")
+			fmt.Fprintf(w, "No entries found.\n")
 		}
 		return
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := tablewriter.NewWriter(w)
 	if *hideHeader == false {
 		table.SetHeader(elements)
 	}
@@ -61,9 +41,9 @@ func printJobData(rows []*accountingRow, elements []string) {
 	var rowBuffer []string
 	rowBuffer = make([]string, len(elements))
 
-	for _, row := range rows {
-		for i, elementName := range elements {
-			rowBuffer[i] = getNamedElement(row, elementName)
+	for i := range jobs {
+		for j, elementName := range elements {
+			rowBuffer[j] = accounting.FormatElement(&jobs[i], elementName)
 		}
 		table.Append(rowBuffer)
 	}
@@ -71,44 +51,16 @@ func printJobData(rows []*accountingRow, elements []string) {
 	table.Render()
 }
 
-func dropUnsafeChars(r rune) rune {
-	if (r >= 'a') && (r <= 'z') {
-		return r
-	}
-
-	if (r >= '0') && (r <= '9') {
-		return r
-	}
-
-	if r == '-' {
-		return r
-	}
-
-	// Some specials to allow SQL pattern matching
-	if (r == '*') || (r == '%') {
-		return '%'
-	}
-
-	if (r == '?') || (r == '_') {
-		return '_'
-	}
-
-	if *debug {
-		log.Printf("unsafe character dropped: %v", r)
-	}
-	return -1
-}
-
-func stringInSlice(a string, list []string) bool {
-	for _, b := range list {
-		if b == a {
-			return true
-		}
+func showInfoElements() {
+	fmt.Println(`Possible info elements:`)
+	for _, v := range accounting.ElementDescriptions {
+		fmt.Printf("  %15s     %s\n", v.Label, v.Description)
 	}
-	return false
 }
 
 var (
+	searchCmd = kingpin.Command("search", "Search for jobs and show them. (Default.)").Default()
+
 	debug           = kingpin.Flag("debug", "Enable debug mode.").Bool()
 	hideHeader      = kingpin.Flag("no-header", "Don't print the column headings.").Short('q').Default("false").Bool()
 	searchBackHours = kingpin.Flag("hours", "Number of hours back in time to search. (Default: 48)").Short('h').PlaceHolder("<hours>").Default("-1").Int()
@@ -121,8 +73,16 @@ var (
 	searchEndPeriod = kingpin.Flag("end-period", "Limits search to jobs ending in a particular year-month. (Removes other time limit.)").PlaceHolder("<year-month>").Default("").String()
 	searchArbQuery  = kingpin.Flag("query", "Arbitrary query WHERE clause to include.").Short('Q').PlaceHolder("<query>").Hidden().Default("").String()
 	showInfoEls     = kingpin.Flag("list-elements", "Show list of elements that can be displayed.").Short('l').Bool()
-	infoEls         = kingpin.Flag("info", "Show selected info (CSV list).").Short('i').Default("fstime,fetime,hostname,owner,job_number,task_number,exit_status,job_name").String()
-	omitFails       = kingpin.Flat("omit-fails", "Omit jobs with a non-zero SGE failure code.").Short('f').Bool()
+	showPresets     = kingpin.Flag("list-presets", "Show the element sets and saved searches available from preset files.").Bool()
+	infoEls         = kingpin.Flag("info", "Show selected info (CSV list, element sets okay, see --list-presets).").Short('i').Default("fstime,fetime,hostname,owner,job_number,task_number,exit_status,job_name").String()
+	searchFilters   = kingpin.Flag("filter", "Only show jobs where an element matches, e.g. cat_pe=mpi or waittime>3600. (Ops: = != < <= > >=, wildcards okay with = and !=.) (Repeatable, applied after --last.)").PlaceHolder("<element><op><value>").Strings()
+	omitFails       = kingpin.Flag("omit-fails", "Omit jobs with a non-zero SGE failure code.").Short('f').Bool()
+	exportFormat    = kingpin.Flag("export", "Export the selected jobs as OGF Usage Records instead of a table (ur-xml|ur-json).").Short('x').PlaceHolder("<format>").Default("").Enum("", "ur-xml", "ur-json")
+	usageBy         = kingpin.Flag("usage-by", "Summarise core-hours and GPU-hours per account, project or owner instead of listing jobs (account|project|owner).").PlaceHolder("<field>").Default("").Enum("", "account", "project", "owner")
+	budgetFile      = kingpin.Flag("budget-file", "File of allocations to compare usage against, one '<name> <core-hours> [<gpu-hours>]' per line. (Requires --usage-by.)").PlaceHolder("<file>").Default("").String()
+	budgetWarnAt    = kingpin.Flag("warn-at", "Warn when usage reaches these percentages of an allocation (CSV list).").PlaceHolder("<percent>[,<percent>...]").Default("80,100").String()
+	interactive     = kingpin.Flag("interactive", "Browse the jobs found in an interactive terminal UI instead of printing a table.").Short('I').Bool()
+	showBreakdown   = kingpin.Flag("breakdown", "Show every host row for a single job, with per-host usage, totals and imbalance. (Requires --job.)").Short('b').Bool()
 	// TODO: implement timeout
 	//timeoutSeconds  = kingpin.Flag("timeout", "Seconds to wait for database response.").Short('t').Default("3").Int()
 	commitLabel string
@@ -130,197 +90,157 @@ var (
 )
 
 func main() {
-
 	kingpin.Version(fmt.Sprintf("jobhist commit %s built on %s", commitLabel, buildDate))
-	kingpin.Parse()
 
+	err := loadPresets(presetFiles)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+	args, err := expandSavedSearches(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+	command := kingpin.MustParse(kingpin.CommandLine.Parse(args))
+
+	if *showPresets {
+		listPresets(os.Stdout)
+		os.Exit(0)
+	}
+
+	switch command {
+	case digestCmd.FullCommand():
+		runDigest()
+	case predictCmd.FullCommand():
+		runPredictWait()
+	case nodesCmd.FullCommand():
+		runNodes()
+	case compareCmd.FullCommand():
+		runCompare()
+	case auditCmd.FullCommand():
+		runAudit()
+	default:
+		runSearch()
+	}
+}
+
+func runSearch() {
 	if *showInfoEls != false {
 		showInfoElements()
 		os.Exit(0)
 	}
 
-	// This snippet could be made more abstract, but we only want one shortcut right now.
-	splitInfoEls := strings.Split(*infoEls, ",")
-	var displayInfoEls []string
-	standardSet := []string{"fstime", "fetime", "hostname", "owner", "job_number", "task_number", "exit_status", "job_name"}
+	if *showBreakdown && (*searchJob < 0) {
+		log.Fatal("Error: --breakdown requires a job number to be given with --job.")
+	}
 
-	for _, el := range splitInfoEls {
-		if el != "stdset" {
-			displayInfoEls = append(displayInfoEls, el)
-		} else {
-			displayInfoEls = append(displayInfoEls, standardSet...)
-		}
+	if (*budgetFile != "") && (*usageBy == "") {
+		log.Fatal("Error: --budget-file requires --usage-by.")
 	}
 
-	// Build SQL Query
+	if *interactive && ((*exportFormat != "") || (*usageBy != "") || *showBreakdown) {
+		log.Fatal("Error: --interactive can't be used with --export, --usage-by or --breakdown.")
+	}
 
-	// First the FROM:
-	if *searchCluster == "auto" {
-		var err error
-		*searchCluster, err = clusters.GetLocalClusterName()
-		if err != nil {
-			log.Fatal(err)
-		}
+	resolveCluster()
+
+	query := queryFromFlags()
+	err := mustLoadAccess().restrict("search", &query)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
 	}
-	searchDB, err := clusters.GetClusterAccountingDBName(*searchCluster)
+	searchDB, err := query.AccountingDB()
 	if err != nil {
 		log.Fatalf("Error: %s.", err)
 	}
 
-	warnAboutDBTime(searchDB)
-
-	queryFrom := searchDB
+	client := getClient()
+	defer client.Close()
 
-	// Next the SELECT:
-	// There's a bunch of extra derived fields we want to add here.
+	warnAboutDBTime(client, searchDB)
 
-	querySelect := "*, " +
-		"DATE_FORMAT(FROM_UNIXTIME(submission_time), \"%Y-%m-%d %T\") AS fsubtime," +
-		"DATE_FORMAT(FROM_UNIXTIME(start_time), \"%Y-%m-%d %T\") AS fstime, " +
-		"DATE_FORMAT(FROM_UNIXTIME(end_time), \"%Y-%m-%d %T\") AS fetime, " +
-		// This line is really defensive because: start_time and end_time can both be zero for a failed job, and stupid unsigned arithmetic won't let the numbers be negative
-		"(greatest((`accounting`.`end_time` - least(`accounting`.`submission_time`,`accounting`.`start_time`)),1) / greatest((`accounting`.`end_time` - `accounting`.`start_time`),1)) AS slowdown, " +
-		"end_time - start_time AS ewalltime, " +
-		"CAST(start_time AS SIGNED INTEGER) - CAST(submission_time AS SIGNED INTEGER) as waittime, " +
-		"(ru_utime + ru_stime) / (GREATEST(slots,1) * (0.9+CAST(end_time AS SIGNED INTEGER) - CAST(start_time AS SIGNED INTEGER))) AS eff, " +
-		// avoid div/0 errors by adding 0.9 -- works out that jobs taking less than a second take 0.9 seconds
-		// also avoid div/0 by using greatest(slots,1): if the shepherd fails, the job has slots = 0
-
-		"`C::l::h_rt` AS `req_time` "
-		// ^-- warning: this field only started being generated in 2019 and is "null" (text -_-) for earlier rows
+	// TODO: add timeout on DB connection
+	// Find takes a context, so this should just need a context.WithTimeout
 
-	// These elements are expensive to retrieve, so we want to avoid calculating them if we don't need them
-	if stringInSlice("req_time_calc", displayInfoEls) {
-		querySelect += ", substr(`accounting`.`category`,(locate('h_rt=',`accounting`.`category`) + 5),(locate(',',substr(`accounting`.`category`,(locate('h_rt=',`accounting`.`category`) + 5))) - 1)) AS `req_time_calc`"
-	} else {
-		querySelect += ", 0 as `req_time_calc`"
+	// The browser can show any element, so it needs them all from the query,
+	//  but starts off showing just the ones asked for.
+	columns := query.Elements
+	if *interactive {
+		query.Elements = accounting.AllElements
 	}
-	if stringInSlice("req_slowdown", displayInfoEls) {
-		querySelect += ", "
-		querySelect += "CASE "
-		querySelect += " WHEN `end_time` = 0 OR `start_time` = 0 OR `C::l::h_rt` = \"null\" THEN NULL "
-		querySelect += " ELSE (((`start_time` - `submission_time`) + (CAST(`C::l::h_rt` AS INTEGER))) / GREATEST(CAST(`C::l::h_rt` AS INTEGER), 1)) "
-		querySelect += "END "
-		querySelect += " AS `req_slowdown`"
-	} else {
-		querySelect += ", 0 as `req_slowdown`"
-	}
-
-	// Finally the WHERE:
-	var conditions []string
 
-	// Searching for a specific job is fast enough and specific enough that we should
-	//  ignore the time bounds unless explicitly specified
-	// We also disable the default time limit if a specific number of jobs is searched for
-	// Or if a specific period is being searched for
-	if (*searchJob < 0) && (*searchBackHours == -1) && (*searchLast < 0) && (*searchEndPeriod == "") {
-		*searchBackHours = 48
-	}
-	if (*searchBackHours > -1) && (!*searchNoLimits) {
-		time_condition := " (" +
-			"        (end_time > (UNIX_TIMESTAMP(SUBDATE(NOW(), INTERVAL %d HOUR)))) OR " +
-			"      (start_time > (UNIX_TIMESTAMP(SUBDATE(NOW(), INTERVAL %d HOUR)))) OR " +
-			" (submission_time > (UNIX_TIMESTAMP(SUBDATE(NOW(), INTERVAL %d HOUR))))" +
-			") "
-		time_condition_composed := fmt.Sprintf(time_condition,
-			(uint64)(*searchBackHours),
-			(uint64)(*searchBackHours),
-			(uint64)(*searchBackHours))
-		conditions = append(conditions, time_condition_composed)
+	jobs, err := client.Find(context.Background(), query)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
 	}
 
-	// If no explicit user-to-search-for has been specified, and we're searching for a specific job ID,
-	//  assume any user is fine.
-	// (Otherwise we default to searching for the current user, below.)
-	if (*searchJob > 0) && (*searchUser == "") {
-		*searchUser = "*"
+	if *interactive {
+		err = runBrowser(jobs, columns)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+		return
 	}
 
-	if *searchUser != "*" {
-		// Default to current user
-		if *searchUser == "" {
-			*searchUser = os.Getenv("USER")
-		}
+	writeJobData(os.Stdout, jobs, query)
+}
 
-		// Check for username validity
-		safeUser := strings.Map(dropUnsafeChars, *searchUser)
-		if (utf8.RuneCountInString(*searchUser) > 7) ||
-			(len(safeUser) < len(*searchUser)) {
-			log.Fatal("Error: Invalid username.")
-		}
-		// Note: _ is the single-character wildcard in SQL
-		if strings.ContainsAny(safeUser, "%_") {
-			conditions = append(conditions, fmt.Sprintf("owner LIKE \"%s\" ", safeUser))
-		} else {
-			conditions = append(conditions, fmt.Sprintf("owner = \"%s\" ", safeUser))
+// Replaces a cluster of "auto" with the one we're running on.
+func resolveCluster() {
+	if *searchCluster == "auto" {
+		var err error
+		*searchCluster, err = clusters.GetLocalClusterName()
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
+}
 
+// Builds an accounting query from the search flags.
+func queryFromFlags() accounting.Query {
+	query := accounting.NewQuery()
+	query.Cluster = *searchCluster
+	query.User = *searchUser
 	if *searchJob > 0 {
-		conditions = append(conditions, fmt.Sprintf("job_number = %d ", *searchJob))
+		query.JobNumber = *searchJob
 	}
-
 	if *searchMHost != "(none)" {
-		safeHost := strings.Map(dropUnsafeChars, *searchMHost)
-		if len(safeHost) < len(*searchMHost) {
-			log.Fatal("Error: Invalid hostname.")
-		}
-		// Note: _ is the single-character wildcard in SQL
-		if strings.ContainsAny(safeHost, "%_") {
-			conditions = append(conditions, fmt.Sprintf("hostname LIKE \"%s\" ", safeHost))
-		} else {
-			conditions = append(conditions, fmt.Sprintf("hostname = \"%s\" ", safeHost))
-		}
-	}
-
-	if *searchEndPeriod != "" {
-		endPeriodTime, err := time.Parse("2006-01", *searchEndPeriod)
+		query.MasterHost = *searchMHost
+	}
+	query.BackHours = *searchBackHours
+	query.Last = *searchLast
+	query.NoLimits = *searchNoLimits
+	query.EndPeriod = *searchEndPeriod
+	query.OmitFails = *omitFails
+	query.Where = *searchArbQuery
+	query.Elements = accounting.ExpandElements(*infoEls)
+	for _, s := range *searchFilters {
+		filter, err := accounting.ParseFilter(s)
 		if err != nil {
-			log.Fatal("Error: Invalid period provided. Please use year-month, e.g. 2022-11")
+			log.Fatalf("Error: %s: %s.", s, err)
 		}
-		endPeriodTimePlusMonth := endPeriodTime.AddDate(0, 1, 0)
-
-		endPeriodUnixTime := endPeriodTime.Unix()
-		endPeriodPMUnixTime := endPeriodTimePlusMonth.Unix()
-
-		conditions = append(conditions, fmt.Sprintf("end_time >= %d AND end_time < %d", endPeriodUnixTime, endPeriodPMUnixTime))
-	}
-
-	if *omitFails {
-		conditions = append(conditions, "failed != 0")
-	}
-
-	if *searchArbQuery != "" {
-		// Danger
-		conditions = append(conditions, fmt.Sprintf(" %s ", *searchArbQuery))
-	}
-
-	// We don't need a where clause if there are no conditions
-	queryWhere := ""
-	if len(conditions) > 0 {
-		queryWhere = " WHERE " + strings.Join(conditions, " AND ")
+		query.Filters = append(query.Filters, filter)
 	}
+	return query
+}
 
-	query := fmt.Sprintf("SELECT %s FROM %s.accounting %s ORDER BY end_time", querySelect, queryFrom, queryWhere)
-	if (*searchLast >= 0) && (!*searchNoLimits) {
-		// We need to flip the order to get only the last rows by end_time,
-		//   but then we want the order to be flipped *back* for display
-		query = fmt.Sprintf("SELECT * FROM (%s DESC LIMIT %d) AS t1 ORDER BY end_time", query, *searchLast)
-	}
+// Writes job data out in whichever form the flags asked for.
+func writeJobData(w io.Writer, jobs []accounting.Job, query accounting.Query) {
+	query = query.WithDefaults()
 
-	if *debug {
-		log.Printf("Making query: %s", query)
+	if *exportFormat != "" {
+		err := accounting.WriteUsageRecords(w, jobs, *searchCluster, *exportFormat)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+	} else if *usageBy != "" {
+		printUsageSummary(w, jobs, *usageBy, *budgetFile, *budgetWarnAt)
+	} else if *showBreakdown {
+		printJobBreakdown(w, jobs)
+	} else {
+		backHours := query.BackHours
+		if query.NoLimits {
+			backHours = -1
+		}
+		printJobData(w, jobs, query.Elements, backHours)
 	}
-
-	// TODO: add timeout on DB connection
-	// https://blog.golang.org/go-concurrency-patterns-timing-out-and
-	//timeout := make(chan bool, 1)
-	//go func() {
-	//	time.Sleep(*timeoutSeconds * time.Second)
-	//	timeout <- true
-	//}()
-
-	jobData := getJobData(query)
-
-	printJobData(jobData, displayInfoEls)
 }
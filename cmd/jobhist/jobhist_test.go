@@ -0,0 +1,475 @@
+package main
+
+// These tests drive the whole jobhist query pipeline -- flag parsing, query
+//  building, the accounting library's query and row scanning, and output -- against a real
+//  MySQL-compatible server seeded with a small set of fixture jobs.
+// jobhist uses some MariaDB-only SQL (e.g. CAST(... AS INTEGER)), so the
+//  server should be MariaDB rather than MySQL.
+//
+// They start their own server if MariaDB is installed (see testdb_test.go).
+//  To use an existing server instead, give it as a go-sql-driver DSN without
+//  a database name, e.g.:
+//
+//   JOBHIST_TEST_DSN='root@tcp(127.0.0.1:3306)/' go test ./cmd/jobhist/
+//
+// If there's neither, the tests fail rather than quietly checking nothing.
+//  In-process stand-ins like go-mysql-server speak MySQL's dialect, not
+//  MariaDB's, so they can't run jobhist's SQL as it is. To skip the tests on
+//  purpose, set JOBHIST_SKIP_DB_TESTS=1.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-sql-driver/mysql"
+)
+
+const testDBName = "jobhist_test_sgelogs"
+
+type fixtureJob struct {
+	owner      string
+	account    string
+	jobName    string
+	jobNumber  int
+	taskNumber int
+	hostname   string
+	peTaskID   string
+	submitTime int64
+	startTime  int64
+	endTime    int64
+	failed     int
+	exitStatus int
+	slots      int
+	cost       sql.NullInt64
+	cpu        float64
+	maxvmem    float64
+	category   string
+	gpus       int
+	reqTime    string
+}
+
+// Times are relative to when the fixtures are loaded, so that they fall in (or
+// out of) the default 48-hour search window.
+func fixtureJobs(now int64) []fixtureJob {
+	const hour = 3600
+	cost := func(n int64) sql.NullInt64 { return sql.NullInt64{Int64: n, Valid: true} }
+	carolEnd := time.Date(2018, time.May, 15, 12, 0, 0, 0, time.UTC).Unix()
+
+	return []fixtureJob{
+		// A normal serial job.
+		{owner: "alice", account: "alicegrant", jobName: "serial", jobNumber: 1001, hostname: "node-a01", peTaskID: "NONE",
+			submitTime: now - hour - 1800 - 60, startTime: now - hour - 1800, endTime: now - hour,
+			slots: 1, cost: cost(1), cpu: 1700, maxvmem: 1e9, category: "-U users -l h_rt=3600,memory=1G -pe smp 1", reqTime: "3600"},
+		// A job that failed to start, so has no start or end time.
+		{owner: "alice", account: "alicegrant", jobName: "broken", jobNumber: 1002, hostname: "node-a01", peTaskID: "NONE",
+			submitTime: now - 2*hour, failed: 1, category: "-U users -l h_rt=1800,memory=1G", reqTime: "1800"},
+		// An MPI job with accounting_summary off: a master row plus two slave rows.
+		{owner: "bob", account: "bobgrant", jobName: "mpi", jobNumber: 1003, hostname: "node-b01", peTaskID: "NONE",
+			submitTime: now - 4*hour - 600, startTime: now - 4*hour, endTime: now - 3*hour,
+			slots: 16, cost: cost(16), cpu: 100, maxvmem: 2e9, category: "-U users -l h_rt=7200,memory=4G -pe mpi 16", reqTime: "7200"},
+		{owner: "bob", account: "bobgrant", jobName: "mpi", jobNumber: 1003, hostname: "node-b01", peTaskID: "1.node-b01",
+			submitTime: now - 4*hour - 600, startTime: now - 4*hour, endTime: now - 3*hour,
+			cpu: 28000, maxvmem: 8e9, category: "-U users -l h_rt=7200,memory=4G -pe mpi 16", reqTime: "7200"},
+		{owner: "bob", account: "bobgrant", jobName: "mpi", jobNumber: 1003, hostname: "node-b02", peTaskID: "1.node-b02",
+			submitTime: now - 4*hour - 600, startTime: now - 4*hour, endTime: now - 3*hour,
+			cpu: 14000, maxvmem: 4e9, category: "-U users -l h_rt=7200,memory=4G -pe mpi 16", reqTime: "7200"},
+		// A two-task array job using GPUs.
+		{owner: "alice", account: "alicegrant", jobName: "array", jobNumber: 1004, taskNumber: 1, hostname: "node-a02", peTaskID: "NONE",
+			submitTime: now - 1800 - 600 - 120, startTime: now - 1800 - 600, endTime: now - 1800,
+			slots: 1, cost: cost(1), cpu: 590, gpus: 1, category: "-U users -l h_rt=900,gpu=1", reqTime: "900"},
+		{owner: "alice", account: "alicegrant", jobName: "array", jobNumber: 1004, taskNumber: 2, hostname: "node-a02", peTaskID: "NONE",
+			submitTime: now - 1740 - 600 - 120, startTime: now - 1740 - 600, endTime: now - 1740,
+			slots: 1, cost: cost(1), cpu: 590, gpus: 1, category: "-U users -l h_rt=900,gpu=1", reqTime: "900"},
+		// An old job, outside the default window.
+		{owner: "alice", account: "alicegrant", jobName: "old", jobNumber: 900, hostname: "node-a01", peTaskID: "NONE",
+			submitTime: now - 240*hour - 660, startTime: now - 240*hour - 600, endTime: now - 240*hour,
+			slots: 1, cost: cost(1), cpu: 500, category: "-U users -l h_rt=900", reqTime: "900"},
+		// A job from before h_rt was stored, so req_time is the text "null".
+		{owner: "carol", account: "carolgrant", jobName: "ancient", jobNumber: 800, hostname: "node-c01", peTaskID: "NONE",
+			submitTime: carolEnd - 3660, startTime: carolEnd - 3600, endTime: carolEnd,
+			slots: 1, category: "-U users -l memory=1G", reqTime: "null"},
+	}
+}
+
+var (
+	testDBOnce  sync.Once
+	testDBError error
+	testLocalDB *localDB
+	testClient  *accounting.Client
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testClient != nil {
+		testClient.Close()
+	}
+	if testLocalDB != nil {
+		testLocalDB.stop()
+	}
+	os.Exit(code)
+}
+
+// Creates the test accounting DB and loads the fixtures, once per test run.
+func needTestDB(t *testing.T) {
+	t.Helper()
+
+	if os.Getenv("JOBHIST_SKIP_DB_TESTS") != "" {
+		t.Skip("JOBHIST_SKIP_DB_TESTS is set")
+	}
+	testDBOnce.Do(func() {
+		dsn := os.Getenv("JOBHIST_TEST_DSN")
+		if dsn == "" {
+			testLocalDB, dsn, testDBError = startLocalDB()
+			if testDBError != nil {
+				return
+			}
+		}
+		testDBError = seedTestDB(dsn)
+	})
+	if errors.Is(testDBError, errNoMariaDB) {
+		t.Fatalf("%s, and JOBHIST_TEST_DSN is not set: install MariaDB, give a DSN, or set JOBHIST_SKIP_DB_TESTS=1 to skip these tests", testDBError)
+	}
+	if testDBError != nil {
+		t.Fatalf("could not set up test DB: %s", testDBError)
+	}
+}
+
+func seedTestDB(dsn string) error {
+	schema, err := os.ReadFile(filepath.Join("testdata", "accounting.sql"))
+	if err != nil {
+		return err
+	}
+
+	config, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return err
+	}
+	serverConn, err := sql.Open("mysql", config.FormatDSN())
+	if err != nil {
+		return err
+	}
+	defer serverConn.Close()
+
+	for _, statement := range []string{
+		"DROP DATABASE IF EXISTS " + testDBName,
+		"CREATE DATABASE " + testDBName,
+	} {
+		if _, err := serverConn.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	config.DBName = testDBName
+	con, err := sql.Open("mysql", config.FormatDSN())
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	// The driver runs one statement at a time, so lose the trailing semicolon.
+	if _, err := con.Exec(strings.TrimSuffix(strings.TrimSpace(string(schema)), ";")); err != nil {
+		return err
+	}
+
+	insert := "INSERT INTO accounting " +
+		"(owner, account, job_name, job_number, task_number, hostname, pe_taskid, " +
+		"submission_time, start_time, end_time, failed, exit_status, slots, cost, cpu, maxvmem, category, `C::l::gpu`, `C::l::h_rt`) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	for _, j := range fixtureJobs(time.Now().Unix()) {
+		_, err := con.Exec(insert,
+			j.owner, j.account, j.jobName, j.jobNumber, j.taskNumber, j.hostname, j.peTaskID,
+			j.submitTime, j.startTime, j.endTime, j.failed, j.exitStatus, j.slots, j.cost, j.cpu, j.maxvmem, j.category, j.gpus, j.reqTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	// jobhist names the DB in every query, so it connects without one.
+	config.DBName = ""
+	testClient, err = accounting.NewClient(config.FormatDSN())
+	return err
+}
+
+// Runs jobhist's pipeline with the given command-line arguments, returning the
+// rows found and what would have been printed.
+func runJobhist(t *testing.T, args ...string) ([]accounting.Job, string) {
+	t.Helper()
+
+	// Bool flags without a default keep their value between parses, so
+	//  reset them by hand.
+	*debug = false
+	*searchNoLimits = false
+	*showInfoEls = false
+	*showPresets = false
+	*omitFails = false
+	*showBreakdown = false
+	*interactive = false
+	*searchFilters = nil
+
+	if _, err := kingpin.CommandLine.Parse(args); err != nil {
+		t.Fatalf("could not parse args %v: %s", args, err)
+	}
+
+	query := queryFromFlags()
+	query.DBName = testDBName
+	jobs, err := testClient.Find(context.Background(), query)
+	if err != nil {
+		t.Fatalf("could not find jobs for args %v: %s", args, err)
+	}
+
+	var output bytes.Buffer
+	writeJobData(&output, jobs, query)
+	return jobs, output.String()
+}
+
+func jobNumbers(jobs []accounting.Job) []int {
+	numbers := []int{}
+	for _, job := range jobs {
+		numbers = append(numbers, job.JobNumber)
+	}
+	return numbers
+}
+
+// Splits table output into the values on each line.
+func tableFields(output string) [][]string {
+	var lines [][]string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(strings.ReplaceAll(line, "|", " "))
+		if len(fields) > 0 {
+			lines = append(lines, fields)
+		}
+	}
+	return lines
+}
+
+func TestSearchFilters(t *testing.T) {
+	needTestDB(t)
+
+	tests := []struct {
+		name string
+		args []string
+		want []int
+	}{
+		{"default window", []string{"-u", "alice"}, []int{1002, 1001, 1004, 1004}},
+		{"longer window", []string{"-u", "alice", "-h", "300"}, []int{1002, 900, 1001, 1004, 1004}},
+		{"no limits", []string{"-u", "alice", "--all"}, []int{1002, 900, 1001, 1004, 1004}},
+		{"last jobs", []string{"-u", "alice", "--last", "2"}, []int{1004, 1004}},
+		{"user wildcard", []string{"-u", "al*"}, []int{1002, 1001, 1004, 1004}},
+		{"all users", []string{"-u", "*"}, []int{1002, 1003, 1003, 1003, 1001, 1004, 1004}},
+		{"job number", []string{"-j", "1003"}, []int{1003, 1003, 1003}},
+		{"job number outside window", []string{"-j", "900"}, []int{900}},
+		{"master host", []string{"-u", "bob", "-n", "node-b01"}, []int{1003, 1003}},
+		{"master host wildcard", []string{"-u", "bob", "-n", "node-b0?"}, []int{1003, 1003, 1003}},
+		{"omit fails", []string{"-u", "alice", "-f"}, []int{1001, 1004, 1004}},
+		{"end period", []string{"-u", "carol", "--end-period", "2018-05"}, []int{800}},
+		{"nothing found", []string{"-u", "carol"}, []int{}},
+		{"category filter", []string{"-u", "*", "--filter", "cat_pe=mpi"}, []int{1003, 1003, 1003}},
+		{"category resource filter", []string{"-u", "*", "--filter", "cat_l_gpu>0"}, []int{1004, 1004}},
+		{"filter wildcard", []string{"-u", "alice", "--filter", "job_name!=ser*"}, []int{1002, 1004, 1004}},
+		{"two filters", []string{"-u", "alice", "--filter", "cat_l_memory=1G", "--filter", "failed=0"}, []int{1001}},
+		// req_slowdown is only calculated when it's needed, which filtering on it is.
+		{"calculated element filter", []string{"-u", "alice", "--filter", "req_slowdown>1"}, []int{1004, 1004}},
+		{"calculated element filter, exactly", []string{"-u", "alice", "--filter", "req_slowdown=1.0"}, []int{1001}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, _ := runJobhist(t, tc.args...)
+			if got := jobNumbers(rows); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got jobs %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCalculatedElements(t *testing.T) {
+	needTestDB(t)
+
+	tests := []struct {
+		name string
+		args []string
+		want map[string]string
+	}{
+		{"serial job",
+			[]string{"-j", "1001", "-i", "req_time,req_time_calc,req_slowdown,waittime,ewalltime,cost"},
+			map[string]string{"req_time": "3600", "req_time_calc": "3600", "req_slowdown": "1.0", "waittime": "60", "ewalltime": "1800", "cost": "1"}},
+		{"failed job",
+			[]string{"-j", "1002", "-i", "req_slowdown,ewalltime,cost,failed"},
+			map[string]string{"req_slowdown": "(null)", "ewalltime": "0", "cost": "(null)", "failed": "1"}},
+		{"category elements",
+			[]string{"-j", "1003", "--filter", "pe_taskid=NONE", "-i", "cat_pe,cat_pe_slots,cat_l_h_rt,cat_l_memory,cat_access_lists,cat_l_gpu"},
+			map[string]string{"cat_pe": "mpi", "cat_pe_slots": "16", "cat_l_h_rt": "7200", "cat_l_memory": "4G", "cat_access_lists": "users", "cat_l_gpu": ""}},
+		{"h_rt last in the category",
+			[]string{"-j", "900", "-i", "req_time_calc"},
+			map[string]string{"req_time_calc": "900"}},
+		{"job from before req_time was stored",
+			[]string{"-u", "carol", "--end-period", "2018-05", "-i", "req_time,req_slowdown"},
+			map[string]string{"req_time": "null", "req_slowdown": "(null)"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, _ := runJobhist(t, tc.args...)
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+			for element, want := range tc.want {
+				if got := accounting.FormatElement(&rows[0], element); got != want {
+					t.Errorf("%s: got %q, want %q", element, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTableOutput(t *testing.T) {
+	needTestDB(t)
+
+	_, output := runJobhist(t, "-u", "alice", "-q", "-i", "job_number,task_number,owner,hostname")
+	want := [][]string{
+		{"1002", "0", "alice", "node-a01"},
+		{"1001", "0", "alice", "node-a01"},
+		{"1004", "1", "alice", "node-a02"},
+		{"1004", "2", "alice", "node-a02"},
+	}
+	if got := tableFields(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("got table %v, want %v", got, want)
+	}
+
+	_, output = runJobhist(t, "-u", "carol")
+	if !strings.Contains(output, "No entries found. (Last 48 hours searched.)") {
+		t.Errorf("unexpected output for empty search: %q", output)
+	}
+}
+
+func TestBreakdownOutput(t *testing.T) {
+	needTestDB(t)
+
+	_, output := runJobhist(t, "-j", "1003", "-b")
+	for _, want := range []string{
+		"Job 1003 (mpi, owner bob): 3 host row(s)",
+		"node-b02",
+		"1.node-b02",
+		"CPU imbalance (max/mean): 2.00",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("breakdown output does not contain %q:\n%s", want, output)
+		}
+	}
+}
+
+func TestExportOutput(t *testing.T) {
+	needTestDB(t)
+
+	_, output := runJobhist(t, "-j", "1001", "-x", "ur-json")
+	var jsonRecords accounting.UsageRecords
+	if err := json.Unmarshal([]byte(output), &jsonRecords); err != nil {
+		t.Fatalf("could not decode JSON export: %s", err)
+	}
+	if len(jsonRecords.Records) != 1 {
+		t.Fatalf("got %d JSON records, want 1", len(jsonRecords.Records))
+	}
+	record := jsonRecords.Records[0]
+	if record.UserIdentity.LocalUserId != "alice" || record.Processors != 1 ||
+		record.WallDuration != "PT1800S" || record.Status != "completed" || record.ProjectName != "" {
+		t.Errorf("unexpected JSON record: %+v", record)
+	}
+
+	_, output = runJobhist(t, "-j", "1002", "-x", "ur-xml")
+	var xmlRecords accounting.UsageRecords
+	if err := xml.Unmarshal([]byte(output), &xmlRecords); err != nil {
+		t.Fatalf("could not decode XML export: %s", err)
+	}
+	if len(xmlRecords.Records) != 1 {
+		t.Fatalf("got %d XML records, want 1", len(xmlRecords.Records))
+	}
+	record = xmlRecords.Records[0]
+	if record.Status != "failed" || record.StartTime != "" || record.JobIdentity.LocalJobId != "1002" {
+		t.Errorf("unexpected XML record: %+v", record)
+	}
+}
+
+func TestUsageOutput(t *testing.T) {
+	needTestDB(t)
+
+	_, output := runJobhist(t, "-u", "*", "-q", "--usage-by", "owner")
+	want := [][]string{
+		{"alice", "4", "0.8", "0.3"},
+		// Bob's MPI job has three rows, but it's one job, and only its master row has a cost.
+		{"bob", "1", "16.0", "0.0"},
+	}
+	if got := tableFields(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("got usage table %v, want %v", got, want)
+	}
+
+	budgetFile := filepath.Join(t.TempDir(), "budget")
+	if err := os.WriteFile(budgetFile, []byte("# test allocations\nalice 1 1\nbob 10\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, output = runJobhist(t, "-u", "*", "-q", "--usage-by", "owner", "--budget-file", budgetFile)
+	want = [][]string{
+		{"alice", "4", "0.8", "0.3", "1.0", "83.3%", "0.2", "1.0", "33.3%", "0.7"},
+		{"bob", "1", "16.0", "0.0", "10.0", "160.0%", "-6.0", "-", "-", "-"},
+	}
+	if got := tableFields(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("got budget table %v, want %v", got, want)
+	}
+}
+
+func TestFindRequests(t *testing.T) {
+	needTestDB(t)
+
+	query := accounting.NewQuery()
+	query.DBName = testDBName
+	query.User = "*"
+	query.BackHours = 14 * 24
+	jobs, err := testClient.FindRequests(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only master rows of jobs that started, with what predictions need.
+	type request struct {
+		slots    int
+		waittime int
+		hRT      string
+		gpus     int
+		memory   string
+	}
+	var got []request
+	for _, job := range jobs {
+		got = append(got, request{job.Slots, job.Waittime, job.C__l__h_rt, job.C__l__gpu, job.C__l__memory})
+	}
+	want := []request{
+		{1, 60, "3600", 0, "null"},
+		{16, 600, "7200", 0, "null"},
+		{1, 120, "900", 1, "null"},
+		{1, 120, "900", 1, "null"},
+		{1, 60, "900", 0, "null"},
+	}
+	sort.Slice(got, func(i, j int) bool { return fmt.Sprint(got[i]) < fmt.Sprint(got[j]) })
+	sort.Slice(want, func(i, j int) bool { return fmt.Sprint(want[i]) < fmt.Sprint(want[j]) })
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %+v, want %+v", got, want)
+	}
+
+	waits := accounting.SimilarWaits(jobs, accounting.Request{Cores: 1, Walltime: time.Hour}, 2)
+	if !reflect.DeepEqual(waits, []time.Duration{time.Minute}) {
+		t.Errorf("got similar waits %v, want [1m]", waits)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/olekukonko/tablewriter"
+)
+
+var (
+	nodesCmd = kingpin.Command("nodes", "Rank execution hosts by how often jobs failed on them, to find nodes that might need draining.")
+
+	nodesDays        = nodesCmd.Flag("days", "Number of days of jobs to look at.").Default("7").Int()
+	nodesTop         = nodesCmd.Flag("top", "Number of hosts to show. (0 for all)").Default("20").Int()
+	nodesAlpha       = nodesCmd.Flag("alpha", "Significance level for marking a host as suspect, before correcting for the number of hosts.").Default("0.01").Float64()
+	nodesJobsPerHost = nodesCmd.Flag("jobs-per-host", "Number of problem jobs to list for each suspect host.").Default("10").Int()
+)
+
+func runNodes() {
+	if *nodesDays < 1 {
+		log.Fatal("Error: --days must be at least 1.")
+	}
+	if (*nodesAlpha <= 0) || (*nodesAlpha >= 1) {
+		log.Fatal("Error: --alpha must be between 0 and 1.")
+	}
+
+	// The report lists other users' jobs, and needs everyone's to work out the baseline.
+	err := mustLoadAccess().requireAdmin("nodes")
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	resolveCluster()
+
+	query := accounting.NewQuery()
+	query.Cluster = *searchCluster
+	query.User = "*"
+	query.BackHours = *nodesDays * 24
+	if *searchMHost != "(none)" {
+		query.MasterHost = *searchMHost
+	}
+
+	client := getClient()
+	defer client.Close()
+
+	jobs, err := client.Find(context.Background(), query)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	printNodeReport(os.Stdout, jobs)
+}
+
+func printNodeReport(w io.Writer, jobs []accounting.Job) {
+	if len(jobs) == 0 {
+		fmt.Fprintf(w, "No entries found. (Last %d days searched.)\n", *nodesDays)
+		return
+	}
+
+	report, baseline := accounting.NodeHealthReport(jobs)
+
+	// With hundreds of hosts, some will look bad by chance, so the significance
+	//  level is Bonferroni-corrected for the number of hosts tested.
+	threshold := *nodesAlpha / float64(len(report))
+
+	fmt.Fprintf(w, "%d job rows on %d hosts in the last %d days, %.2f%% with possible node problems.\n",
+		len(jobs), len(report), *nodesDays, 100*baseline)
+	fmt.Fprintf(w, "Hosts marked * have significantly more problems than the rest of the cluster (p < %.2g).\n\n", threshold)
+
+	shown := report
+	if (*nodesTop > 0) && (len(shown) > *nodesTop) {
+		shown = shown[:*nodesTop]
+	}
+
+	table := tablewriter.NewWriter(w)
+	if *hideHeader == false {
+		table.SetHeader([]string{"", "hostname", "rows", "problems", "start failures", "signalled", "problem rate", "p-value"})
+	}
+	table.SetBorder(false)
+	var suspects []accounting.HostHealth
+	for _, h := range report {
+		if h.PValue < threshold {
+			suspects = append(suspects, h)
+		}
+	}
+	for _, h := range shown {
+		mark := ""
+		if h.PValue < threshold {
+			mark = "*"
+		}
+		table.Append([]string{
+			mark,
+			h.Hostname,
+			strconv.Itoa(h.Rows),
+			strconv.Itoa(h.Problems),
+			strconv.Itoa(h.StartFailures),
+			strconv.Itoa(h.Signalled),
+			fmt.Sprintf("%.2f%%", 100*h.ProblemRate),
+			fmt.Sprintf("%.2g", h.PValue),
+		})
+	}
+	table.Render()
+
+	for _, h := range suspects {
+		fmt.Fprintf(w, "\n%s: %d problem row(s), users affected: %s\n", h.Hostname, h.Problems, strings.Join(affectedUsers(h.ProblemJobs), ", "))
+
+		problemJobs := h.ProblemJobs
+		if len(problemJobs) > *nodesJobsPerHost {
+			// Most recent are the most interesting when deciding whether to drain.
+			problemJobs = problemJobs[len(problemJobs)-*nodesJobsPerHost:]
+			fmt.Fprintf(w, "(Showing the most recent %d.)\n", *nodesJobsPerHost)
+		}
+		printJobData(w, problemJobs, []string{"fetime", "owner", "job_number", "task_number", "failed", "exit_status", "job_name"}, -1)
+	}
+}
+
+func affectedUsers(jobs []accounting.Job) []string {
+	seen := make(map[string]bool)
+	var users []string
+	for _, job := range jobs {
+		if !seen[job.Owner] {
+			seen[job.Owner] = true
+			users = append(users, job.Owner)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
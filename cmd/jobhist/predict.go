@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/olekukonko/tablewriter"
+)
+
+var (
+	predictCmd = kingpin.Command("predict-wait", "Estimate how long a job would wait to start, from how long similar jobs waited recently.")
+
+	predictCores    = predictCmd.Flag("cores", "Number of cores the job would request.").Default("1").Int()
+	predictMemory   = predictCmd.Flag("memory", "Memory per core the job would request, e.g. 4G. (Default: match any)").PlaceHolder("<size>").Default("").String()
+	predictWalltime = predictCmd.Flag("walltime", "Walltime the job would request, as hours:minutes:seconds or seconds.").PlaceHolder("<time>").Default("2:00:00").String()
+	predictGPUs     = predictCmd.Flag("gpus", "Number of GPUs the job would request.").Default("0").Int()
+	predictDays     = predictCmd.Flag("days", "Number of days of jobs to compare against.").Default("14").Int()
+	predictFactor   = predictCmd.Flag("factor", "How far from the request (as a multiple either way) a job's cores, memory and walltime can be and still count as similar.").Default("2").Float64()
+)
+
+// Percentiles of wait time to show.
+var predictPercentiles = []float64{25, 50, 75, 90}
+
+// With fewer similar jobs than this, the estimate comes with a warning.
+const predictMinJobs = 20
+
+func runPredictWait() {
+	if *predictCores < 1 {
+		log.Fatal("Error: --cores must be at least 1.")
+	}
+	if *predictFactor < 1 {
+		log.Fatal("Error: --factor must be at least 1.")
+	}
+	if *predictDays < 1 {
+		log.Fatal("Error: --days must be at least 1.")
+	}
+
+	request := accounting.Request{Cores: *predictCores, GPUs: *predictGPUs}
+	var err error
+	request.Walltime, err = accounting.ParseWalltime(*predictWalltime)
+	if err != nil || request.Walltime == 0 {
+		log.Fatalf("Error: %s.", accounting.ErrInvalidWalltime)
+	}
+	if *predictMemory != "" {
+		request.Memory, err = accounting.ParseMemory(*predictMemory)
+		if err != nil {
+			log.Fatalf("Error: %s.", err)
+		}
+	}
+
+	resolveCluster()
+
+	query := accounting.NewQuery()
+	query.Cluster = *searchCluster
+	query.User = "*"
+	query.BackHours = *predictDays * 24
+
+	client := getClient()
+	defer client.Close()
+
+	jobs, err := client.FindRequests(context.Background(), query)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	printWaitPrediction(os.Stdout, jobs, request)
+}
+
+// Ways of changing a request that might get it started sooner, to compare against.
+func alternativeRequests(r accounting.Request) []accounting.Request {
+	var alternatives []accounting.Request
+	if r.Walltime >= 2*time.Hour {
+		a := r
+		a.Walltime = r.Walltime / 2
+		alternatives = append(alternatives, a)
+	}
+	if r.Cores > 1 {
+		a := r
+		a.Cores = r.Cores / 2
+		alternatives = append(alternatives, a)
+	}
+	if r.Memory > 0 {
+		a := r
+		a.Memory = r.Memory / 2
+		alternatives = append(alternatives, a)
+	}
+	return alternatives
+}
+
+func printWaitPrediction(w io.Writer, jobs []accounting.Job, request accounting.Request) {
+	waits := accounting.SimilarWaits(jobs, request, *predictFactor)
+
+	fmt.Fprintf(w, "Request: %s\n", request)
+	if len(waits) == 0 {
+		fmt.Fprintf(w, "No similar jobs started on %s in the last %d days, so there's nothing to estimate from.\n", *searchCluster, *predictDays)
+		return
+	}
+	fmt.Fprintf(w, "Found %d similar job(s) that started on %s in the last %d days.\n", len(waits), *searchCluster, *predictDays)
+	if len(waits) < predictMinJobs {
+		fmt.Fprintf(w, "That's not many, so treat these as rough.\n")
+	}
+	fmt.Fprintln(w)
+
+	table := tablewriter.NewWriter(w)
+	if *hideHeader == false {
+		table.SetHeader([]string{"percentile", "wait"})
+	}
+	table.SetBorder(false)
+	for _, p := range predictPercentiles {
+		table.Append([]string{strconv.FormatFloat(p, 'f', -1, 64) + "%", formatWait(accounting.Percentile(waits, p))})
+	}
+	table.Render()
+
+	alternatives := alternativeRequests(request)
+	if len(alternatives) == 0 {
+		return
+	}
+
+	// These are for seeing whether asking for less would help, so only the
+	//  median is worth showing.
+	fmt.Fprintf(w, "\nMedian waits for smaller requests:\n")
+	table = tablewriter.NewWriter(w)
+	if *hideHeader == false {
+		table.SetHeader([]string{"request", "similar jobs", "median wait"})
+	}
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, a := range alternatives {
+		altWaits := accounting.SimilarWaits(jobs, a, *predictFactor)
+		median := "-"
+		if len(altWaits) > 0 {
+			median = formatWait(accounting.Percentile(altWaits, 50))
+		}
+		table.Append([]string{a.String(), strconv.Itoa(len(altWaits)), median})
+	}
+	table.Render()
+}
+
+func formatWait(d time.Duration) string {
+	return d.Round(time.Minute).String()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/alecthomas/kingpin/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Preset files are YAML, like:
+//
+//	elements:
+//	  gpus: [owner, job_number, C__l__gpu, ewalltime, job_name]
+//	searches:
+//	  mygpujobs:
+//	    description: My GPU jobs from the last week
+//	    args: [--hours, "168", --info, gpus, --filter, "cat_l_gpu>0"]
+//
+// Element sets can be used anywhere elements can, and saved searches are run
+// with "jobhist @<name>". Later files override earlier ones. (Saved searches
+// are run as whoever runs them, so --query in one only works for admins.)
+
+// The site file comes first so users can override it.
+var presetFiles = []string{"/shared/ucl/etc/jobhist-presets.yaml", filepath.Join(os.Getenv("HOME"), ".jobhist-presets.yaml")}
+
+type savedSearch struct {
+	Description string   `yaml:"description"`
+	Args        []string `yaml:"args"`
+	file        string
+}
+
+type presetFile struct {
+	Elements map[string][]string    `yaml:"elements"`
+	Searches map[string]savedSearch `yaml:"searches"`
+}
+
+var (
+	savedSearches     = make(map[string]savedSearch)
+	elementSetSources = make(map[string]string)
+)
+
+// Reads the preset files, adding their element sets to accounting.ElementSets.
+func loadPresets(filenames []string) error {
+	for name := range accounting.ElementSets {
+		elementSetSources[name] = "(built in)"
+	}
+
+	for _, filename := range filenames {
+		contents, err := os.ReadFile(filename)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("could not read preset file: %w", err)
+		}
+
+		var presets presetFile
+		err = yaml.Unmarshal(contents, &presets)
+		if err != nil {
+			return fmt.Errorf("could not parse preset file %s: %w", filename, err)
+		}
+
+		// Sets are expanded as they're read, so they can be made out of the ones
+		//  from earlier files.
+		for name, elements := range presets.Elements {
+			if len(elements) == 0 {
+				return fmt.Errorf("%s: element set %s is empty", filename, name)
+			}
+			accounting.ElementSets[name] = accounting.ExpandElements(strings.Join(elements, ","))
+			elementSetSources[name] = filename
+		}
+		for name, search := range presets.Searches {
+			search.file = filename
+			savedSearches[name] = search
+		}
+	}
+	return nil
+}
+
+// Replaces any @<name> arguments with the saved search's arguments. Only
+// arguments on their own are expanded, not flags' values or anything after "--".
+func expandSavedSearches(args []string) ([]string, error) {
+	return expandSavedSearchesFrom(args, newValueFlags(kingpin.CommandLine.Model()), nil)
+}
+
+// expanding is the searches already being expanded, to catch ones that include themselves.
+func expandSavedSearchesFrom(args []string, flags valueFlags, expanding []string) ([]string, error) {
+	var expanded []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			expanded = append(expanded, args[i:]...)
+			break
+		}
+		if flags.takesNext(arg) && i+1 < len(args) {
+			expanded = append(expanded, arg, args[i+1])
+			i++
+			continue
+		}
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		name := arg[1:]
+		search, ok := savedSearches[name]
+		if !ok {
+			return nil, fmt.Errorf("no saved search called %s (see --list-presets)", name)
+		}
+		for _, e := range expanding {
+			if e == name {
+				return nil, fmt.Errorf("saved search %s includes itself", name)
+			}
+		}
+		searchArgs, err := expandSavedSearchesFrom(search.Args, flags, append(expanding, name))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, searchArgs...)
+	}
+	return expanded, nil
+}
+
+// The flags that take a value, by long and short name, from every command.
+type valueFlags struct {
+	long  map[string]bool
+	short map[rune]bool
+}
+
+func newValueFlags(app *kingpin.ApplicationModel) valueFlags {
+	flags := valueFlags{long: make(map[string]bool), short: make(map[rune]bool)}
+	var add func(*kingpin.FlagGroupModel, *kingpin.CmdGroupModel)
+	add = func(group *kingpin.FlagGroupModel, commands *kingpin.CmdGroupModel) {
+		for _, flag := range group.Flags {
+			if flag.IsBoolFlag() {
+				continue
+			}
+			flags.long[flag.Name] = true
+			if flag.Short != 0 {
+				flags.short[flag.Short] = true
+			}
+		}
+		for _, command := range commands.Commands {
+			add(command.FlagGroupModel, command.CmdGroupModel)
+		}
+	}
+	add(app.FlagGroupModel, app.CmdGroupModel)
+	return flags
+}
+
+// Reports whether an argument is a flag whose value is the next argument.
+func (flags valueFlags) takesNext(arg string) bool {
+	if strings.HasPrefix(arg, "--") {
+		return !strings.Contains(arg, "=") && flags.long[arg[2:]]
+	}
+	if strings.HasPrefix(arg, "-") {
+		// Short flags can be run together, e.g. -qu, where the first that takes
+		//  a value takes the rest of the argument, or the next one if that's all.
+		shorts := []rune(arg[1:])
+		for i, short := range shorts {
+			if flags.short[short] {
+				return i == len(shorts)-1
+			}
+		}
+	}
+	return false
+}
+
+func listPresets(w io.Writer) {
+	fmt.Fprintln(w, "Element sets (use with --info):")
+	var names []string
+	for name := range accounting.ElementSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %15s     %s  %s\n", name, strings.Join(accounting.ElementSets[name], ","), elementSetSources[name])
+	}
+
+	fmt.Fprintln(w, "\nSaved searches (use as jobhist @<name>):")
+	if len(savedSearches) == 0 {
+		fmt.Fprintf(w, "  None. They can be added to: %s\n", strings.Join(presetFiles, " or "))
+		return
+	}
+	names = names[:0]
+	for name := range savedSearches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		search := savedSearches[name]
+		fmt.Fprintf(w, "  %15s     %s\n", "@"+name, search.Description)
+		fmt.Fprintf(w, "  %15s     %s  %s\n", "", quoteArgs(search.Args), search.file)
+	}
+}
+
+// Joins arguments up again, quoting any that would need it in a shell.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t'\"`$*?") {
+			quoted[i] = strconv.Quote(arg)
+		} else {
+			quoted[i] = arg
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandSavedSearches(t *testing.T) {
+	saved := savedSearches
+	t.Cleanup(func() { savedSearches = saved })
+	savedSearches = map[string]savedSearch{
+		"gpu":     {Args: []string{"--hours", "168", "--filter", "cat_l_gpu>0"}},
+		"mygpu":   {Args: []string{"@gpu", "-u", "alice"}},
+		"loop":    {Args: []string{"@loop2"}},
+		"loop2":   {Args: []string{"-q", "@loop"}},
+		"missing": {Args: []string{"@nothing"}},
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{"no searches", []string{"-u", "bob"}, []string{"-u", "bob"}, false},
+		{"search", []string{"@gpu", "-q"}, []string{"--hours", "168", "--filter", "cat_l_gpu>0", "-q"}, false},
+		{"search in a search", []string{"@mygpu"}, []string{"--hours", "168", "--filter", "cat_l_gpu>0", "-u", "alice"}, false},
+		{"after a command", []string{"search", "@gpu"}, []string{"search", "--hours", "168", "--filter", "cat_l_gpu>0"}, false},
+		{"flag value", []string{"--filter", "@gpu", "-u", "@gpu"}, []string{"--filter", "@gpu", "-u", "@gpu"}, false},
+		{"flag value after bool flags", []string{"-qu", "@gpu"}, []string{"-qu", "@gpu"}, false},
+		{"flag value in the same argument", []string{"-u@gpu", "--filter=@gpu", "@gpu"},
+			[]string{"-u@gpu", "--filter=@gpu", "--hours", "168", "--filter", "cat_l_gpu>0"}, false},
+		{"command's flag value", []string{"predict-wait", "--memory", "@gpu"}, []string{"predict-wait", "--memory", "@gpu"}, false},
+		{"after --", []string{"-q", "--", "@gpu"}, []string{"-q", "--", "@gpu"}, false},
+		{"unknown search", []string{"@nothing"}, nil, true},
+		{"unknown search in a search", []string{"@missing"}, nil, true},
+		{"loop", []string{"@loop"}, nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := expandSavedSearches(tc.args)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+}
@@ -0,0 +1,66 @@
+-- A cut-down copy of the *_sgelogs.accounting table, for the jobhist tests.
+-- The column order matters: jobhist selects * and scans columns by position.
+CREATE TABLE `accounting` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `_pos` int unsigned NOT NULL DEFAULT 0,
+  `_checksum` char(32) NOT NULL DEFAULT '',
+  `qname` varchar(64) NOT NULL DEFAULT '',
+  `hostname` varchar(64) NOT NULL DEFAULT '',
+  `ugroup` varchar(64) NOT NULL DEFAULT '',
+  `owner` varchar(64) NOT NULL DEFAULT '',
+  `job_name` varchar(255) NOT NULL DEFAULT '',
+  `job_number` int unsigned NOT NULL DEFAULT 0,
+  `account` varchar(64) NOT NULL DEFAULT '',
+  `priority` int NOT NULL DEFAULT 0,
+  `submission_time` int unsigned NOT NULL DEFAULT 0,
+  `start_time` int unsigned NOT NULL DEFAULT 0,
+  `end_time` int unsigned NOT NULL DEFAULT 0,
+  `failed` int NOT NULL DEFAULT 0,
+  `exit_status` int NOT NULL DEFAULT 0,
+  `ru_wallclock` int NOT NULL DEFAULT 0,
+  `ru_utime` double NOT NULL DEFAULT 0,
+  `ru_stime` double NOT NULL DEFAULT 0,
+  `ru_maxrss` double NOT NULL DEFAULT 0,
+  `ru_ixrss` double NOT NULL DEFAULT 0,
+  `ru_ismrss` double NOT NULL DEFAULT 0,
+  `ru_idrss` double NOT NULL DEFAULT 0,
+  `ru_isrss` double NOT NULL DEFAULT 0,
+  `ru_minflt` double NOT NULL DEFAULT 0,
+  `ru_majflt` double NOT NULL DEFAULT 0,
+  `ru_nswap` double NOT NULL DEFAULT 0,
+  `ru_inblock` double NOT NULL DEFAULT 0,
+  `ru_oublock` double NOT NULL DEFAULT 0,
+  `ru_msgsnd` double NOT NULL DEFAULT 0,
+  `ru_msgrcv` double NOT NULL DEFAULT 0,
+  `ru_nsignals` double NOT NULL DEFAULT 0,
+  `ru_nvcsw` double NOT NULL DEFAULT 0,
+  `ru_nivcsw` double NOT NULL DEFAULT 0,
+  `project` varchar(64) NOT NULL DEFAULT '',
+  `department` varchar(64) NOT NULL DEFAULT '',
+  `granted_pe` varchar(64) NOT NULL DEFAULT '',
+  `slots` int unsigned NOT NULL DEFAULT 0,
+  `task_number` int unsigned NOT NULL DEFAULT 0,
+  `cpu` double NOT NULL DEFAULT 0,
+  `mem` double NOT NULL DEFAULT 0,
+  `io` double NOT NULL DEFAULT 0,
+  `category` text NOT NULL,
+  `iow` double NOT NULL DEFAULT 0,
+  `pe_taskid` varchar(64) NOT NULL DEFAULT 'NONE',
+  `maxvmem` double NOT NULL DEFAULT 0,
+  `arid` int unsigned NOT NULL DEFAULT 0,
+  `ar_submission_time` int unsigned NOT NULL DEFAULT 0,
+  `cost` bigint DEFAULT NULL,
+  `C::l::bonus` int NOT NULL DEFAULT 0,
+  `C::l::cpu` int NOT NULL DEFAULT 0,
+  `C::l::gpu` int NOT NULL DEFAULT 0,
+  `C::l::h_rss` varchar(32) NOT NULL DEFAULT 'null',
+  `C::l::h_rt` varchar(32) NOT NULL DEFAULT 'null',
+  `C::l::h_vmem` varchar(32) NOT NULL DEFAULT 'null',
+  `C::l::memory` varchar(32) NOT NULL DEFAULT 'null',
+  `C::l::penalty` double NOT NULL DEFAULT 0,
+  `C::l::threads` int NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  KEY `job_number` (`job_number`),
+  KEY `owner` (`owner`),
+  KEY `end_time` (`end_time`)
+);
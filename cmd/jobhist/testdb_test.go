@@ -0,0 +1,157 @@
+package main
+
+// Starts a throwaway MariaDB server for the tests, so they don't need one
+//  set up beforehand. It only listens on a socket in a temporary directory,
+//  and is stopped and deleted when the tests finish.
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+var errNoMariaDB = errors.New("no MariaDB server (mariadbd or mysqld) found")
+
+type localDB struct {
+	dir    string
+	server *exec.Cmd
+	exited chan error
+}
+
+// Where MariaDB's server tends to be installed, as well as $PATH, since sbin
+// and libexec often aren't in ordinary users' paths.
+var serverDirs = []string{"/usr/sbin", "/usr/libexec", "/usr/local/sbin", "/usr/local/opt/mariadb/bin", "/opt/homebrew/opt/mariadb/bin"}
+
+// Looks for the first of some programs in $PATH or serverDirs.
+func lookPaths(names ...string) (string, error) {
+	for _, name := range names {
+		p, err := exec.LookPath(name)
+		if err == nil {
+			return p, nil
+		}
+	}
+	for _, dir := range serverDirs {
+		for _, name := range names {
+			p := filepath.Join(dir, name)
+			if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+				return p, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("none of %v found in $PATH or %v", names, serverDirs)
+}
+
+// Sets up a new data directory and starts a server on it, returning the DSN
+// to connect to it as root with.
+func startLocalDB() (*localDB, string, error) {
+	serverPath, err := lookPaths("mariadbd", "mysqld")
+	if err != nil {
+		return nil, "", errNoMariaDB
+	}
+	installPath, err := lookPaths("mariadb-install-db", "mysql_install_db")
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, err := os.MkdirTemp("", "jobhist-test-db")
+	if err != nil {
+		return nil, "", err
+	}
+	db := &localDB{dir: dir, exited: make(chan error, 1)}
+	dataDir := filepath.Join(dir, "data")
+	socket := filepath.Join(dir, "mysqld.sock")
+
+	// The server won't run as root unless it's told to.
+	var userArgs []string
+	if os.Geteuid() == 0 {
+		userArgs = []string{"--user=root"}
+	}
+
+	// Root can log in without a password, which is fine over a socket
+	//  in a directory only we can get into.
+	var installOutput bytes.Buffer
+	install := exec.Command(installPath, append([]string{
+		"--no-defaults",
+		"--datadir=" + dataDir,
+		"--auth-root-authentication-method=normal",
+	}, userArgs...)...)
+	install.Stdout = &installOutput
+	install.Stderr = &installOutput
+	if err := install.Run(); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("could not set up data directory: %w\n%s", err, installOutput.String())
+	}
+
+	db.server = exec.Command(serverPath, append([]string{
+		"--no-defaults",
+		"--datadir=" + dataDir,
+		"--socket=" + socket,
+		"--pid-file=" + filepath.Join(dir, "mysqld.pid"),
+		"--log-error=" + filepath.Join(dir, "error.log"),
+		"--skip-networking",
+	}, userArgs...)...)
+	if err := db.server.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("could not start %s: %w", serverPath, err)
+	}
+	go func() { db.exited <- db.server.Wait() }()
+
+	dsn := "root@unix(" + socket + ")/"
+	if err := db.waitUntilUp(dsn, 60*time.Second); err != nil {
+		db.stop()
+		return nil, "", err
+	}
+	return db, dsn, nil
+}
+
+func (db *localDB) waitUntilUp(dsn string, timeout time.Duration) error {
+	con, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = con.Ping()
+		if err == nil {
+			return nil
+		}
+		select {
+		case exitErr := <-db.exited:
+			db.exited <- exitErr
+			return fmt.Errorf("server exited before it was ready (%v):\n%s", exitErr, db.errorLog())
+		case <-time.After(200 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server wasn't ready after %s: %w\n%s", timeout, err, db.errorLog())
+		}
+	}
+}
+
+func (db *localDB) errorLog() string {
+	contents, _ := os.ReadFile(filepath.Join(db.dir, "error.log"))
+	return string(contents)
+}
+
+// Stops the server and deletes its data.
+func (db *localDB) stop() {
+	select {
+	case <-db.exited:
+	default:
+		db.server.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-db.exited:
+		case <-time.After(30 * time.Second):
+			db.server.Process.Kill()
+			<-db.exited
+		}
+	}
+	os.RemoveAll(db.dir)
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
+const sessionCookieName = "jobweb_session"
+
+// What the handlers need from the accounting DB: an *accounting.Client, or a
+// fake in the tests.
+type jobFinder interface {
+	Find(ctx context.Context, q accounting.Query) ([]accounting.Job, error)
+}
+
+type server struct {
+	client     jobFinder
+	ldap       *adhelper.Client
+	sessions   *sessionStore
+	cluster    string
+	adminGroup string
+	adminUsers []string
+	userDomain string
+	secure     bool
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/logout", s.handleLogout)
+	mux.HandleFunc("/", s.requireSession(s.handleSearch))
+	mux.HandleFunc("/job", s.requireSession(s.handleJob))
+	mux.HandleFunc("/jobs.csv", s.requireSession(s.handleCSV))
+	return mux
+}
+
+type sessionHandler func(w http.ResponseWriter, r *http.Request, sess *session)
+
+func (s *server) requireSession(h sessionHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName)
+		if err == nil {
+			if sess := s.sessions.get(cookie.Value); sess != nil {
+				h(w, r, sess)
+				return
+			}
+		}
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
+
+func (s *server) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := templates.ExecuteTemplate(w, name, data)
+	if err != nil {
+		log.Printf("Error rendering %s: %s", name, err)
+	}
+}
+
+// Usernames are checked before being put into a bind DN.
+var usernameRe = regexp.MustCompile(`^[a-z0-9]+$`)
+
+func (s *server) isAdmin(username string) bool {
+	for _, u := range s.adminUsers {
+		if u == username {
+			return true
+		}
+	}
+	if s.adminGroup == "" {
+		return false
+	}
+	members, err := s.ldap.GetADGroupUsers(s.adminGroup)
+	if err != nil {
+		log.Printf("Error: could not get members of admin group %s: %s", s.adminGroup, err)
+		return false
+	}
+	for _, m := range members {
+		if strings.EqualFold(m, username) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.render(w, "login.html", map[string]string{})
+		return
+	}
+
+	username := strings.ToLower(strings.TrimSpace(r.PostFormValue("username")))
+	password := r.PostFormValue("password")
+
+	if !usernameRe.MatchString(username) {
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, "login.html", map[string]string{"Error": "Invalid username or password."})
+		return
+	}
+
+	bindName := username
+	if s.userDomain != "" {
+		bindName = s.userDomain + `\` + username
+	}
+	err := s.ldap.Authenticate(bindName, password)
+	if err != nil {
+		log.Printf("Failed login for %s: %s", username, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, "login.html", map[string]string{"Error": "Invalid username or password."})
+		return
+	}
+
+	admin := s.isAdmin(username)
+	token, err := s.sessions.create(username, admin)
+	if err != nil {
+		log.Printf("Error: %s", err)
+		http.Error(w, "Could not create session.", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Login for %s (admin: %t)", username, admin)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		s.sessions.remove(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// Parses an optional integer form value, returning def if it's empty.
+func formInt(form url.Values, name string, def int) (int, error) {
+	value := strings.TrimSpace(form.Get(name))
+	if value == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &formError{name}
+	}
+	return i, nil
+}
+
+type formError struct {
+	field string
+}
+
+func (e *formError) Error() string {
+	return "invalid value for " + e.field
+}
+
+// Builds an accounting query from the search form, mirroring jobhist's flags.
+// Non-admins only ever get their own jobs, whatever the form says.
+func (s *server) queryFromForm(form url.Values, sess *session) (accounting.Query, error) {
+	var err error
+	query := accounting.NewQuery()
+
+	query.Cluster = form.Get("cluster")
+	if query.Cluster == "" {
+		query.Cluster = s.cluster
+	}
+
+	if sess.admin {
+		query.User = strings.TrimSpace(form.Get("user"))
+	}
+	if query.User == "" {
+		query.User = sess.user
+	}
+
+	if query.JobNumber, err = formInt(form, "job", 0); err != nil {
+		return query, err
+	}
+	if query.BackHours, err = formInt(form, "hours", -1); err != nil {
+		return query, err
+	}
+	if query.Last, err = formInt(form, "last", -1); err != nil {
+		return query, err
+	}
+	query.MasterHost = strings.TrimSpace(form.Get("host"))
+	query.EndPeriod = strings.TrimSpace(form.Get("period"))
+	query.NoLimits = form.Get("all") != ""
+	query.OmitFails = form.Get("omitfails") != ""
+
+	info := strings.TrimSpace(form.Get("info"))
+	if info == "" {
+		info = "stdset"
+	}
+	query.Elements = accounting.ExpandElements(info)
+
+	return query, nil
+}
+
+type columnHeader struct {
+	Name    string
+	SortURL string
+	Sorted  bool
+	Desc    bool
+}
+
+type resultRow struct {
+	JobURL string
+	Cells  []string
+}
+
+type searchPage struct {
+	User      string
+	Admin     bool
+	Form      url.Values
+	Cluster   string
+	Error     string
+	Message   string
+	Headers   []columnHeader
+	Rows      []resultRow
+	CSVURL    string
+	Elements  []accounting.ElementDesc
+	Searching bool
+}
+
+// Runs the search in the form, sorted as requested.
+func (s *server) findJobs(r *http.Request, sess *session) (accounting.Query, []accounting.Job, error) {
+	form := r.URL.Query()
+	query, err := s.queryFromForm(form, sess)
+	if err != nil {
+		return query, nil, err
+	}
+
+	jobs, err := s.client.Find(r.Context(), query)
+	if err != nil {
+		return query, nil, err
+	}
+
+	if sortElement := form.Get("sort"); sortElement != "" {
+		accounting.SortJobs(jobs, sortElement, form.Get("desc") != "")
+	}
+	return query, jobs, nil
+}
+
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request, sess *session) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	form := r.URL.Query()
+	page := searchPage{
+		User:      sess.user,
+		Admin:     sess.admin,
+		Form:      form,
+		Cluster:   s.cluster,
+		Elements:  accounting.ElementDescriptions,
+		Searching: len(form) > 0,
+	}
+	if !page.Searching {
+		s.render(w, "search.html", page)
+		return
+	}
+
+	query, jobs, err := s.findJobs(r, sess)
+	if err != nil {
+		page.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		s.render(w, "search.html", page)
+		return
+	}
+
+	if len(jobs) == 0 {
+		query = query.WithDefaults()
+		if (query.BackHours > -1) && !query.NoLimits {
+			page.Message = "No entries found. (Last " + strconv.Itoa(query.BackHours) + " hours searched.)"
+		} else {
+			page.Message = "No entries found."
+		}
+	}
+
+	currentSort := form.Get("sort")
+	currentDesc := form.Get("desc") != ""
+	for _, element := range query.Elements {
+		sortForm := cloneValues(form)
+		sortForm.Set("sort", element)
+		sortForm.Del("desc")
+		// Clicking the sorted column again flips the order.
+		if element == currentSort && !currentDesc {
+			sortForm.Set("desc", "1")
+		}
+		page.Headers = append(page.Headers, columnHeader{
+			Name:    element,
+			SortURL: "/?" + sortForm.Encode(),
+			Sorted:  element == currentSort,
+			Desc:    element == currentSort && currentDesc,
+		})
+	}
+
+	for i := range jobs {
+		row := resultRow{
+			JobURL: "/job?" + url.Values{"number": {strconv.Itoa(jobs[i].JobNumber)}, "cluster": {query.Cluster}}.Encode(),
+		}
+		for _, element := range query.Elements {
+			row.Cells = append(row.Cells, accounting.FormatElement(&jobs[i], element))
+		}
+		page.Rows = append(page.Rows, row)
+	}
+	page.CSVURL = "/jobs.csv?" + form.Encode()
+
+	s.render(w, "search.html", page)
+}
+
+func cloneValues(v url.Values) url.Values {
+	c := make(url.Values, len(v))
+	for k, vs := range v {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
+
+type jobPage struct {
+	User    string
+	Admin   bool
+	Number  int
+	Cluster string
+	Error   string
+	Columns []string
+	Rows    [][]string
+}
+
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request, sess *session) {
+	page := jobPage{User: sess.user, Admin: sess.admin}
+
+	number, err := strconv.Atoi(r.URL.Query().Get("number"))
+	if err != nil || number <= 0 {
+		page.Error = "Invalid job number."
+		w.WriteHeader(http.StatusBadRequest)
+		s.render(w, "job.html", page)
+		return
+	}
+	page.Number = number
+
+	query := accounting.NewQuery()
+	query.JobNumber = number
+	query.Cluster = r.URL.Query().Get("cluster")
+	if query.Cluster == "" {
+		query.Cluster = s.cluster
+	}
+	page.Cluster = query.Cluster
+	query.User = sess.user
+	if sess.admin {
+		query.User = "*"
+	}
+	query.Elements = accounting.AllElements
+
+	jobs, err := s.client.Find(r.Context(), query)
+	if err != nil {
+		page.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		s.render(w, "job.html", page)
+		return
+	}
+	if len(jobs) == 0 {
+		page.Error = "No entries found for that job."
+		w.WriteHeader(http.StatusNotFound)
+		s.render(w, "job.html", page)
+		return
+	}
+
+	// One column per row of the job (for parallel or array jobs), one row per element.
+	for i := range jobs {
+		page.Columns = append(page.Columns, fmt.Sprintf("task %d on %s (%s)", jobs[i].TaskNumber, accounting.Unqdn(jobs[i].Hostname), jobs[i].PeTaskid))
+	}
+	for _, element := range accounting.AllElements {
+		line := []string{element}
+		for i := range jobs {
+			line = append(line, accounting.FormatElement(&jobs[i], element))
+		}
+		page.Rows = append(page.Rows, line)
+	}
+
+	s.render(w, "job.html", page)
+}
+
+func (s *server) handleCSV(w http.ResponseWriter, r *http.Request, sess *session) {
+	query, jobs, err := s.findJobs(r, sess)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="jobs.csv"`)
+
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(query.Elements)
+	for i := range jobs {
+		line := make([]string, len(query.Elements))
+		for j, element := range query.Elements {
+			line[j] = accounting.FormatElement(&jobs[i], element)
+		}
+		csvWriter.Write(line)
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		log.Printf("Error writing CSV: %s", err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+// These drive the handlers through HTTP, logging in against the test LDAP
+//  server from adtest (with the users in testdata/ad.ldif) and searching a
+//  fake accounting DB that records what it was asked for.
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper"
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper/adtest"
+)
+
+type fakeDB struct {
+	mu      sync.Mutex
+	jobs    []accounting.Job
+	queries []accounting.Query
+}
+
+// Finds jobs by owner and job number, which is all the tests need.
+func (db *fakeDB) Find(ctx context.Context, q accounting.Query) ([]accounting.Job, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.queries = append(db.queries, q)
+
+	found := []accounting.Job{}
+	for _, job := range db.jobs {
+		if q.User != "*" && job.Owner != q.User {
+			continue
+		}
+		if q.JobNumber > 0 && job.JobNumber != q.JobNumber {
+			continue
+		}
+		found = append(found, job)
+	}
+	return found, nil
+}
+
+func (db *fakeDB) lastQuery(t *testing.T) accounting.Query {
+	t.Helper()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.queries) == 0 {
+		t.Fatal("nothing was searched for")
+	}
+	return db.queries[len(db.queries)-1]
+}
+
+func newTestServer(t *testing.T) (*server, *fakeDB) {
+	t.Helper()
+
+	ldapServer := adtest.NewServer(t, filepath.Join("testdata", "ad.ldif"))
+	ldapClient := adhelper.NewClient(&adhelper.LdapOpts{
+		ServerUrl: ldapServer.URL,
+		Username:  adtest.BindUser,
+		Password:  adtest.BindPassword,
+		BaseDN:    adtest.BaseDN,
+	})
+	t.Cleanup(func() { ldapClient.Close() })
+
+	db := &fakeDB{jobs: []accounting.Job{
+		{Owner: "ccaaali", JobNumber: 101, Hostname: "node-a01", PeTaskid: "NONE"},
+		{Owner: "ccaabob", JobNumber: 201, Hostname: "node-b01", PeTaskid: "NONE"},
+	}}
+	s := &server{
+		client:     db,
+		ldap:       ldapClient,
+		sessions:   newSessionStore(time.Hour),
+		cluster:    "myriad",
+		adminGroup: "rc-admins",
+		adminUsers: []string{"ccaacar"},
+		userDomain: "AD",
+	}
+	return s, db
+}
+
+// Makes a request to the server, with a session cookie if token isn't empty.
+func request(s *server, method string, target string, form url.Values, token string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if token != "" {
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	}
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, r)
+	return w
+}
+
+// Logs in, returning the session token.
+func login(t *testing.T, s *server, username string, password string) string {
+	t.Helper()
+	w := request(s, http.MethodPost, "/login", url.Values{"username": {username}, "password": {password}}, "")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("logging in as %s: got status %d, want %d", username, w.Code, http.StatusSeeOther)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			return cookie.Value
+		}
+	}
+	t.Fatalf("logging in as %s didn't set a session cookie", username)
+	return ""
+}
+
+func TestLogin(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for _, target := range []string{"/", "/job?number=101", "/jobs.csv"} {
+		w := request(s, http.MethodGet, target, nil, "")
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+			t.Errorf("%s without a session: got status %d to %q, want a redirect to /login", target, w.Code, w.Header().Get("Location"))
+		}
+		w = request(s, http.MethodGet, target, nil, "not-a-session")
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("%s with a made-up session: got status %d, want a redirect", target, w.Code)
+		}
+	}
+
+	failures := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "ccaabob", "alicepw"},
+		{"no password", "ccaabob", ""},
+		{"unknown user", "nobody", "bobpw"},
+		{"username with a domain", `AD\ccaabob`, "bobpw"},
+	}
+	for _, tc := range failures {
+		t.Run(tc.name, func(t *testing.T) {
+			w := request(s, http.MethodPost, "/login", url.Values{"username": {tc.username}, "password": {tc.password}}, "")
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			if len(w.Result().Cookies()) > 0 {
+				t.Error("a failed login set a cookie")
+			}
+			if !strings.Contains(w.Body.String(), "Invalid username or password.") {
+				t.Errorf("login page doesn't say why:\n%s", w.Body.String())
+			}
+		})
+	}
+
+	w := request(s, http.MethodPost, "/login", url.Values{"username": {" CCAABOB "}, "password": {"bobpw"}}, "")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Fatalf("got status %d to %q, want a redirect to /", w.Code, w.Header().Get("Location"))
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly || cookie.Path != "/" {
+		t.Fatalf("got session cookie %+v, want an HttpOnly one for /", cookie)
+	}
+	if sess := s.sessions.get(cookie.Value); sess == nil || sess.user != "ccaabob" || sess.admin {
+		t.Errorf("got session %+v, want ccaabob without admin", sess)
+	}
+
+	w = request(s, http.MethodGet, "/", nil, cookie.Value)
+	if w.Code != http.StatusOK {
+		t.Errorf("search page with a session: got status %d", w.Code)
+	}
+
+	w = request(s, http.MethodGet, "/logout", nil, cookie.Value)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("logout: got status %d", w.Code)
+	}
+	w = request(s, http.MethodGet, "/", nil, cookie.Value)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("after logging out: got status %d, want a redirect", w.Code)
+	}
+}
+
+func TestAdmins(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		username  string
+		password  string
+		wantAdmin bool
+	}{
+		{"ccaaali", "alicepw", true}, // In the admin group.
+		{"ccaacar", "carolpw", true}, // In the admin users.
+		{"ccaabob", "bobpw", false},
+	}
+	for _, tc := range tests {
+		token := login(t, s, tc.username, tc.password)
+		if sess := s.sessions.get(token); sess.admin != tc.wantAdmin {
+			t.Errorf("%s: got admin %t, want %t", tc.username, sess.admin, tc.wantAdmin)
+		}
+	}
+}
+
+func TestOwnJobsOnly(t *testing.T) {
+	s, db := newTestServer(t)
+	user := login(t, s, "ccaabob", "bobpw")
+	admin := login(t, s, "ccaaali", "alicepw")
+
+	tests := []struct {
+		name       string
+		token      string
+		target     string
+		wantStatus int
+		wantUser   string
+	}{
+		{"own search", user, "/?hours=24", http.StatusOK, "ccaabob"},
+		{"someone else's jobs", user, "/?user=ccaaali", http.StatusOK, "ccaabob"},
+		{"everyone's jobs", user, "/?user=*", http.StatusOK, "ccaabob"},
+		{"someone else's jobs as CSV", user, "/jobs.csv?user=ccaaali", http.StatusOK, "ccaabob"},
+		{"own job", user, "/job?number=201", http.StatusOK, "ccaabob"},
+		{"someone else's job", user, "/job?number=101", http.StatusNotFound, "ccaabob"},
+		{"admin's own search", admin, "/?hours=24", http.StatusOK, "ccaaali"},
+		{"admin searching for someone", admin, "/?user=ccaabob", http.StatusOK, "ccaabob"},
+		{"admin searching for everyone", admin, "/?user=*", http.StatusOK, "*"},
+		{"admin looking at someone's job", admin, "/job?number=201", http.StatusOK, "*"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := request(s, http.MethodGet, tc.target, nil, tc.token)
+			if w.Code != tc.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tc.wantStatus)
+			}
+			if got := db.lastQuery(t).User; got != tc.wantUser {
+				t.Errorf("searched for user %q, want %q", got, tc.wantUser)
+			}
+		})
+	}
+
+	w := request(s, http.MethodGet, "/jobs.csv?user=ccaaali&info=owner,job_number", nil, user)
+	if got, want := w.Body.String(), "owner,job_number\nccaabob,201\n"; got != want {
+		t.Errorf("got CSV %q, want %q", got, want)
+	}
+	w = request(s, http.MethodGet, "/job?number=101", nil, user)
+	if strings.Contains(w.Body.String(), "node-a01") {
+		t.Error("job page shows details of someone else's job")
+	}
+}
+
+func TestBadForms(t *testing.T) {
+	s, _ := newTestServer(t)
+	token := login(t, s, "ccaabob", "bobpw")
+
+	for _, target := range []string{"/?hours=lots", "/?job=x", "/?last=1.5", "/job?number=-1", "/job"} {
+		w := request(s, http.MethodGet, target, nil, token)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/accounting"
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper"
+	"github.com/UCL-RITS/go-clustertools/internal/clusters"
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var description = `
+A small web server for looking through job history, like jobhist.
+
+Users log in with their AD credentials. Everyone can see their own jobs,
+and members of the admin group (or listed admin users) can see everyone's.
+`
+
+var (
+	app = kingpin.New("jobweb", description)
+
+	listenAddr   = app.Flag("listen", "Address to listen on.").Short('l').Default("localhost:8080").String()
+	tlsCertFile  = app.Flag("tls-cert", "Certificate to serve HTTPS with. (Default: plain HTTP, for use behind a proxy.)").PlaceHolder("file").Default("").String()
+	tlsKeyFile   = app.Flag("tls-key", "Key for the HTTPS certificate.").PlaceHolder("file").Default("").String()
+	cluster      = app.Flag("cluster", "Cluster whose jobs are shown by default. (Default: this cluster)").Short('c').PlaceHolder("<cluster>").Default("auto").String()
+	sessionHours = app.Flag("session-hours", "Hours before users have to log in again.").Default("12").Int()
+	adminGroup   = app.Flag("admin-group", "AD group whose members can see all users' jobs.").PlaceHolder("<group>").Default("").String()
+	adminUsers   = app.Flag("admin-user", "User who can see all users' jobs. (Can be repeated.)").PlaceHolder("<username>").Strings()
+	userDomain   = app.Flag("user-domain", "Domain users log in to, prefixed to their username for AD binds.").Default("AD").String()
+
+	profile       = app.Flag("profile", "Profile from the LDAP config files to use. (Default: from $AD_PROFILE or the config files)").PlaceHolder("name").String()
+	ldapUrl       = app.Flag("server", "URL of the LDAP/AD server to connect to. (Default: from config, or ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/)").Short('s').PlaceHolder("url").String()
+	bindUser      = app.Flag("bind-user", "User to search the server for group members with. (\"Bind\" user.) (Default: from config, or AD\\sa-ritsldap01)").Short('u').PlaceHolder("user").String()
+	bindCredsFile = app.Flag("creds-file", "File to get bind credentials from. (Default: from config, or first of $JOBWEB_ADPWFILE /shared/ucl/etc/adpw)").PlaceHolder("file").String()
+	certFile      = app.Flag("cert", "Certificate to use with LDAPS. (Default: from config)").PlaceHolder("file").String()
+	insecure      = app.Flag("insecure", "Insecurely ignore LDAP server certificate. (Default: from config, or no)").Short('k').IsSetByUser(&insecureSet).Bool()
+	searchBase    = app.Flag("base", "Search base in the LDAP tree. (Default: from config, or DC=ad,DC=ucl,DC=ac,DC=uk)").Short('b').PlaceHolder("dn").String()
+
+	debug = app.Flag("debug", "Enable debug mode.").Bool()
+
+	// Whether --insecure or --no-insecure was given, to override the config.
+	insecureSet bool
+
+	commitLabel string
+	buildDate   string
+)
+
+func main() {
+	app.Version(fmt.Sprintf("jobweb commit %s built on %s", commitLabel, buildDate))
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	if *cluster == "auto" {
+		var err error
+		*cluster, err = clusters.GetLocalClusterName()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The bind credentials are only needed to look up the admin group.
+	ldapOpts, err := adhelper.LoadConfig(adhelper.ConfigOptions{
+		Profile: *profile,
+		Flags: adhelper.LdapOpts{
+			ServerUrl:    *ldapUrl,
+			Username:     *bindUser,
+			PasswordFile: *bindCredsFile,
+			BaseDN:       *searchBase,
+			Insecure:     setFlag(insecure, insecureSet),
+			CertFile:     *certFile,
+		},
+		PasswordFiles: []string{os.Getenv("JOBWEB_ADPWFILE"), "/shared/ucl/etc/adpw"},
+		NoPassword:    *adminGroup == "",
+	})
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+
+	// Logins can come in together, so allow a few admin group lookups at once.
+	ldapClient := adhelper.NewClient(ldapOpts)
+	defer ldapClient.Close()
+	ldapClient.MaxConns = 4
+
+	client, err := accounting.NewClient(accounting.DefaultDSN)
+	if err != nil {
+		log.Fatalf("Error: %s.", err)
+	}
+	defer client.Close()
+	client.Debug = *debug
+
+	s := &server{
+		client:     client,
+		ldap:       ldapClient,
+		sessions:   newSessionStore(time.Duration(*sessionHours) * time.Hour),
+		cluster:    *cluster,
+		adminGroup: *adminGroup,
+		adminUsers: *adminUsers,
+		userDomain: *userDomain,
+		secure:     *tlsCertFile != "",
+	}
+
+	log.Printf("Listening on %s", *listenAddr)
+	httpServer := &http.Server{
+		Addr:              *listenAddr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if *tlsCertFile != "" {
+		err = httpServer.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	log.Fatal(err)
+}
+
+// Returns a flag's value if it was given, or nil so that the config's is used.
+func setFlag(value *bool, set bool) *bool {
+	if !set {
+		return nil
+	}
+	return value
+}
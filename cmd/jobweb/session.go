@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type session struct {
+	user    string
+	admin   bool
+	expires time.Time
+}
+
+// Sessions are only kept in memory, so everyone has to log in again if the server restarts.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	lifetime time.Duration
+}
+
+func newSessionStore(lifetime time.Duration) *sessionStore {
+	return &sessionStore{
+		sessions: make(map[string]*session),
+		lifetime: lifetime,
+	}
+}
+
+func (s *sessionStore) create(user string, admin bool) (string, error) {
+	tokenBytes := make([]byte, 32)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", fmt.Errorf("could not generate session token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Tidy up while we're here, so abandoned sessions don't pile up.
+	now := time.Now()
+	for t, sess := range s.sessions {
+		if now.After(sess.expires) {
+			delete(s.sessions, t)
+		}
+	}
+
+	s.sessions[token] = &session{user: user, admin: admin, expires: now.Add(s.lifetime)}
+	return token, nil
+}
+
+// Returns nil if there's no such session, or it has expired.
+func (s *sessionStore) get(token string) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(sess.expires) {
+		delete(s.sessions, token)
+		return nil
+	}
+	return sess
+}
+
+func (s *sessionStore) remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
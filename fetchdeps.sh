@@ -9,4 +9,8 @@ else
   go get github.com/olekukonko/tablewriter
   go get gopkg.in/alecthomas/kingpin.v2
   go get github.com/Showmax/go-fqdn
+  go get github.com/gdamore/tcell/v2
+  go get gopkg.in/yaml.v3
+  go get github.com/go-ldap/ldap/v3/gssapi
+  go get github.com/go-ldap/ldif
 fi
@@ -0,0 +1,194 @@
+package accounting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// A Check is a kind of problem that turns up in accounting data.
+type Check struct {
+	Name        string
+	Description string
+	// SQL condition matching the rows with the problem. {table} is replaced with
+	//  the accounting table, and {window} with the conditions for which rows are
+	//  being checked, for subqueries that look at other rows.
+	Condition string
+	// Elements worth showing for example rows.
+	Elements []string
+	// SQL statement that fixes the rows, if there is one. {table} is the accounting
+	//  table and {where} is the condition for which rows to fix.
+	Repair string
+	// Anything the person running the repair should know.
+	RepairNote string
+}
+
+// AuditChecks are the problems we know the accounting data can have.
+var AuditChecks = []Check{
+	{
+		Name:        "duplicate_checksum",
+		Description: "rows that repeat an earlier row's checksum, e.g. from an accounting file being loaded twice",
+		// Both sides of the join are limited to the rows being checked, or it
+		//  would be across the whole table. That doesn't miss anything, because
+		//  a duplicate row has the same times and owner as the one it repeats.
+		// Wrapped in an extra SELECT, because MySQL won't let a DELETE use a
+		//  subquery on the same table otherwise.
+		Condition: "`_checksum` != '' AND `id` IN (SELECT `id` FROM (SELECT a.`id` " +
+			"FROM (SELECT `id`, `_checksum` FROM {table} WHERE {window}) AS a " +
+			"JOIN (SELECT `id`, `_checksum` FROM {table} WHERE {window}) AS b " +
+			"ON a.`_checksum` = b.`_checksum` AND a.`id` > b.`id`) AS dups)",
+		Elements:   []string{"id", "_checksum", "job_number", "task_number", "owner", "hostname", "fetime"},
+		Repair:     "DELETE FROM {table} WHERE {where}",
+		RepairNote: "keeps the first row with each checksum",
+	},
+	{
+		Name:        "missing_checksum",
+		Description: "rows with no checksum, so duplicates of them can't be found",
+		Condition:   "`_checksum` = ''",
+		Elements:    []string{"id", "job_number", "task_number", "owner", "hostname", "fetime"},
+	},
+	{
+		Name:        "null_cost",
+		Description: "rows where cost is NULL",
+		Condition:   "`cost` IS NULL",
+		Elements:    []string{"id", "job_number", "task_number", "owner", "slots", "cost", "fetime"},
+		Repair:      "UPDATE {table} SET `cost` = `slots` WHERE {where}",
+		RepairNote:  "uses slots, which misses hyperthreads on clusters that have them",
+	},
+	{
+		Name:        "null_req_time",
+		Description: "rows where the requested time is the text \"null\", as in rows from before 2019",
+		Condition:   "`C::l::h_rt` = 'null'",
+		Elements:    []string{"id", "job_number", "task_number", "owner", "req_time", "category", "fetime"},
+		// Only rows where the category has an h_rt followed by something else can
+		//  be fixed, because that's what the extraction relies on.
+		Repair:     "UPDATE {table} SET `C::l::h_rt` = " + reqTimeFromCategorySQL + " WHERE {where} AND `category` REGEXP 'h_rt=[0-9]+,'",
+		RepairNote: "takes h_rt from the category string, where it's there",
+	},
+	{
+		Name:        "missing_times",
+		Description: "rows with a zero start or end time that aren't marked as failed",
+		Condition:   "`failed` = 0 AND (`start_time` = 0 OR `end_time` = 0)",
+		Elements:    []string{"id", "job_number", "task_number", "owner", "submission_time", "start_time", "end_time", "failed"},
+	},
+	{
+		Name:        "end_before_start",
+		Description: "rows that end before they start",
+		Condition:   "`start_time` != 0 AND `end_time` != 0 AND `end_time` < `start_time`",
+		Elements:    []string{"id", "job_number", "task_number", "owner", "fstime", "fetime"},
+	},
+	{
+		Name:        "negative_wait",
+		Description: "rows that start before they were submitted",
+		Condition:   "`start_time` != 0 AND `start_time` < `submission_time`",
+		Elements:    []string{"id", "job_number", "task_number", "owner", "fsubtime", "fstime", "waittime"},
+	},
+	{
+		Name:        "missing_category",
+		Description: "rows with an empty category, so no record of what was requested",
+		Condition:   "`category` = ''",
+		Elements:    []string{"id", "job_number", "task_number", "owner", "category", "fetime"},
+	},
+}
+
+// A Finding is what a check found in an accounting DB.
+type Finding struct {
+	Check    Check
+	Count    int
+	Examples []Job
+}
+
+// Returns the check's own condition for a DB, for the rows matching window.
+func (c Check) condition(dbName string, window string) string {
+	return strings.NewReplacer("{table}", dbName+".accounting", "{window}", window).Replace(c.Condition)
+}
+
+// Returns a query's conditions as one, for the rows a check looks at.
+func auditWindow(q Query) (string, error) {
+	conditions, err := q.Conditions()
+	if err != nil {
+		return "", err
+	}
+	if len(conditions) == 0 {
+		return "1=1", nil
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+// Returns the conditions matching the rows a check finds, within a query's limits.
+func (c Check) conditions(q Query) (string, error) {
+	dbName, err := q.AccountingDB()
+	if err != nil {
+		return "", err
+	}
+	window, err := auditWindow(q)
+	if err != nil {
+		return "", err
+	}
+	return window + " AND (" + c.condition(dbName, window) + ")", nil
+}
+
+// RepairSQL returns a statement fixing the rows a check would find with a query,
+// or "" if the check has no repair.
+func (c Check) RepairSQL(q Query) (string, error) {
+	if c.Repair == "" {
+		return "", nil
+	}
+	dbName, err := q.AccountingDB()
+	if err != nil {
+		return "", err
+	}
+	where, err := c.conditions(q)
+	if err != nil {
+		return "", err
+	}
+	return strings.NewReplacer("{table}", dbName+".accounting", "{where}", where).Replace(c.Repair), nil
+}
+
+// Audit runs checks over the rows a query would find, returning how many rows
+// each check matched and up to examples of them.
+func (c *Client) Audit(ctx context.Context, q Query, checks []Check, examples int) ([]Finding, error) {
+	dbName, err := q.AccountingDB()
+	if err != nil {
+		return nil, err
+	}
+	window, err := auditWindow(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	for _, check := range checks {
+		where, err := check.conditions(q)
+		if err != nil {
+			return nil, err
+		}
+
+		finding := Finding{Check: check}
+		countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s.accounting WHERE %s", dbName, where)
+		if c.Debug {
+			log.Printf("Making query: %s", countQuery)
+		}
+		err = c.db.QueryRowContext(ctx, countQuery).Scan(&finding.Count)
+		if err != nil {
+			return nil, fmt.Errorf("could not run %s check: %w", check.Name, err)
+		}
+
+		if finding.Count > 0 && examples > 0 {
+			// Last is ignored with NoLimits, and it's enough to stop the default
+			//  time limit applying anyway.
+			exampleQuery := q
+			exampleQuery.Where = "(" + check.condition(dbName, window) + ")"
+			exampleQuery.NoLimits = false
+			exampleQuery.Last = examples
+			exampleQuery.Elements = check.Elements
+			finding.Examples, err = c.Find(ctx, exampleQuery)
+			if err != nil {
+				return nil, fmt.Errorf("could not get examples for %s check: %w", check.Name, err)
+			}
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
@@ -0,0 +1,102 @@
+package accounting
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func findCheck(t *testing.T, name string) Check {
+	t.Helper()
+	for _, check := range AuditChecks {
+		if check.Name == name {
+			return check
+		}
+	}
+	t.Fatalf("no %s check", name)
+	return Check{}
+}
+
+func auditQuery() Query {
+	q := NewQuery()
+	q.DBName = "test_sgelogs"
+	q.User = "*"
+	q.EndedAfter = time.Unix(1700000000, 0)
+	return q
+}
+
+func TestRepairSQL(t *testing.T) {
+	const window = "(end_time >= 1700000000 OR (end_time = 0 AND submission_time >= 1700000000))"
+
+	tests := []struct {
+		name  string
+		check string
+		query func(*Query)
+		want  string
+	}{
+		{"duplicates", "duplicate_checksum", func(q *Query) {},
+			"DELETE FROM test_sgelogs.accounting WHERE " + window + " AND " +
+				"(`_checksum` != '' AND `id` IN (SELECT `id` FROM (SELECT a.`id` " +
+				"FROM (SELECT `id`, `_checksum` FROM test_sgelogs.accounting WHERE " + window + ") AS a " +
+				"JOIN (SELECT `id`, `_checksum` FROM test_sgelogs.accounting WHERE " + window + ") AS b " +
+				"ON a.`_checksum` = b.`_checksum` AND a.`id` > b.`id`) AS dups))"},
+		{"duplicates on one host", "duplicate_checksum", func(q *Query) { q.MasterHost = "node-a01" },
+			"DELETE FROM test_sgelogs.accounting WHERE hostname = \"node-a01\"  AND " + window + " AND " +
+				"(`_checksum` != '' AND `id` IN (SELECT `id` FROM (SELECT a.`id` " +
+				"FROM (SELECT `id`, `_checksum` FROM test_sgelogs.accounting WHERE hostname = \"node-a01\"  AND " + window + ") AS a " +
+				"JOIN (SELECT `id`, `_checksum` FROM test_sgelogs.accounting WHERE hostname = \"node-a01\"  AND " + window + ") AS b " +
+				"ON a.`_checksum` = b.`_checksum` AND a.`id` > b.`id`) AS dups))"},
+		{"duplicates over everything", "duplicate_checksum", func(q *Query) {
+			q.EndedAfter = time.Time{}
+			q.NoLimits = true
+		},
+			"DELETE FROM test_sgelogs.accounting WHERE 1=1 AND " +
+				"(`_checksum` != '' AND `id` IN (SELECT `id` FROM (SELECT a.`id` " +
+				"FROM (SELECT `id`, `_checksum` FROM test_sgelogs.accounting WHERE 1=1) AS a " +
+				"JOIN (SELECT `id`, `_checksum` FROM test_sgelogs.accounting WHERE 1=1) AS b " +
+				"ON a.`_checksum` = b.`_checksum` AND a.`id` > b.`id`) AS dups))"},
+		{"null cost", "null_cost", func(q *Query) {},
+			"UPDATE test_sgelogs.accounting SET `cost` = `slots` WHERE " + window + " AND (`cost` IS NULL)"},
+		{"no repair", "missing_times", func(q *Query) {}, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q := auditQuery()
+			tc.query(&q)
+			got, err := findCheck(t, tc.check).RepairSQL(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuditConditionsLimited(t *testing.T) {
+	// Every reference to the table in a check has to be limited to the rows
+	//  being checked, or it'll scan the whole table.
+	q := auditQuery()
+	for _, check := range AuditChecks {
+		where, err := check.conditions(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tables := strings.Count(where, "FROM test_sgelogs.accounting")
+		windows := strings.Count(where, "FROM test_sgelogs.accounting WHERE (end_time >= 1700000000")
+		if tables != windows {
+			t.Errorf("%s: %d subqueries on the table, but only %d limited to the window:\n%s", check.Name, tables, windows, where)
+		}
+		if !strings.HasPrefix(where, "(end_time >= 1700000000") {
+			t.Errorf("%s: conditions don't start with the window:\n%s", check.Name, where)
+		}
+	}
+
+	q.User = "not a user"
+	if _, err := findCheck(t, "duplicate_checksum").RepairSQL(q); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("got %v for an invalid user, want ErrInvalidUsername", err)
+	}
+}
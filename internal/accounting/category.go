@@ -0,0 +1,291 @@
+package accounting
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Category is a job's resource request, parsed from the category column.
+//
+// The scheduler writes the category as the qsub options that affect where a
+// job can run, e.g.:
+//
+//	-U users -u ccaaxyz -l h_rt=3600,memory=4G,gpu=1 -pe mpi 16 -P Free -ac a=b
+type Category struct {
+	Resources     map[string]string // -l, or -hard -l
+	SoftResources map[string]string // -soft -l
+	PE            string            // -pe name
+	PESlots       string            // -pe slots, which can be a range like 8-16
+	Queues        []string          // -q
+	MasterQueues  []string          // -masterq
+	Project       string            // -P
+	Owner         string            // -u
+	AccessLists   []string          // -U
+	Context       map[string]string // -ac
+	// Any other options, with their arguments joined by spaces.
+	Other map[string]string
+}
+
+var ErrInvalidCategory = errors.New("invalid category string")
+
+// ParseCategory parses a category string. An empty string is an empty category.
+func ParseCategory(s string) (Category, error) {
+	c := Category{
+		Resources:     make(map[string]string),
+		SoftResources: make(map[string]string),
+		Context:       make(map[string]string),
+		Other:         make(map[string]string),
+	}
+
+	fields := strings.Fields(s)
+	soft := false
+	for i := 0; i < len(fields); {
+		option := fields[i]
+		if !strings.HasPrefix(option, "-") || option == "-" {
+			return c, fmt.Errorf("%w: expected an option at %q", ErrInvalidCategory, option)
+		}
+		i++
+
+		// Everything up to the next option is this one's arguments, except
+		//  for -pe, whose slot range can look like an option (e.g. -4).
+		var args []string
+		if option == "-pe" {
+			if i+2 > len(fields) {
+				return c, fmt.Errorf("%w: -pe needs a name and slots", ErrInvalidCategory)
+			}
+			args = fields[i : i+2]
+			i += 2
+		} else {
+			for i < len(fields) && !strings.HasPrefix(fields[i], "-") {
+				args = append(args, fields[i])
+				i++
+			}
+		}
+
+		switch option {
+		case "-hard":
+			soft = false
+		case "-soft":
+			soft = true
+		case "-l":
+			resources := c.Resources
+			if soft {
+				resources = c.SoftResources
+			}
+			for _, arg := range args {
+				err := parseAssignments(arg, resources, "true")
+				if err != nil {
+					return c, err
+				}
+			}
+		case "-ac":
+			for _, arg := range args {
+				err := parseAssignments(arg, c.Context, "")
+				if err != nil {
+					return c, err
+				}
+			}
+		case "-pe":
+			c.PE = args[0]
+			c.PESlots = args[1]
+		case "-q":
+			c.Queues = append(c.Queues, splitList(args)...)
+		case "-masterq":
+			c.MasterQueues = append(c.MasterQueues, splitList(args)...)
+		case "-P":
+			if len(args) != 1 {
+				return c, fmt.Errorf("%w: -P needs one project", ErrInvalidCategory)
+			}
+			c.Project = args[0]
+		case "-u":
+			if len(args) != 1 {
+				return c, fmt.Errorf("%w: -u needs one user", ErrInvalidCategory)
+			}
+			c.Owner = args[0]
+		case "-U":
+			c.AccessLists = append(c.AccessLists, splitList(args)...)
+		default:
+			c.Other[option] = strings.Join(args, " ")
+		}
+	}
+	return c, nil
+}
+
+// Parses a list like h_rt=3600,memory=4G into a map. Names without a value are
+// given noValue, as SGE does for boolean resources.
+func parseAssignments(s string, into map[string]string, noValue string) error {
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		name, value, found := strings.Cut(item, "=")
+		if name == "" {
+			return fmt.Errorf("%w: no name in %q", ErrInvalidCategory, item)
+		}
+		if !found {
+			value = noValue
+		}
+		into[name] = value
+	}
+	return nil
+}
+
+func splitList(args []string) []string {
+	var items []string
+	for _, arg := range args {
+		for _, item := range strings.Split(arg, ",") {
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// String puts the category back together, in a fixed order. It's equivalent to
+// the original, but not necessarily identical.
+func (c Category) String() string {
+	var parts []string
+	if len(c.AccessLists) > 0 {
+		parts = append(parts, "-U", strings.Join(c.AccessLists, ","))
+	}
+	if c.Owner != "" {
+		parts = append(parts, "-u", c.Owner)
+	}
+	if len(c.Resources) > 0 {
+		parts = append(parts, "-l", joinAssignments(c.Resources))
+	}
+	if len(c.SoftResources) > 0 {
+		parts = append(parts, "-soft", "-l", joinAssignments(c.SoftResources), "-hard")
+	}
+	if c.PE != "" {
+		parts = append(parts, "-pe", c.PE, c.PESlots)
+	}
+	if len(c.Queues) > 0 {
+		parts = append(parts, "-q", strings.Join(c.Queues, ","))
+	}
+	if len(c.MasterQueues) > 0 {
+		parts = append(parts, "-masterq", strings.Join(c.MasterQueues, ","))
+	}
+	if c.Project != "" {
+		parts = append(parts, "-P", c.Project)
+	}
+	if len(c.Context) > 0 {
+		parts = append(parts, "-ac", joinAssignments(c.Context))
+	}
+	var others []string
+	for option := range c.Other {
+		others = append(others, option)
+	}
+	sort.Strings(others)
+	for _, option := range others {
+		parts = append(parts, option)
+		if c.Other[option] != "" {
+			parts = append(parts, c.Other[option])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func joinAssignments(m map[string]string) string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := make([]string, len(names))
+	for i, name := range names {
+		items[i] = name + "=" + m[name]
+	}
+	return strings.Join(items, ",")
+}
+
+// ParsedCategory returns the job's category, parsed. It's only parsed once per
+// job, and a category that can't be parsed gives an error each time.
+func (j *Job) ParsedCategory() (Category, error) {
+	if j.parsedCategory == nil {
+		c, err := ParseCategory(j.Category)
+		j.parsedCategory = &parsedCategory{c, err}
+	}
+	return j.parsedCategory.category, j.parsedCategory.err
+}
+
+type parsedCategory struct {
+	category Category
+	err      error
+}
+
+// Element name prefixes for the parts of the category with names of their own.
+const (
+	resourceElementPrefix     = "cat_l_"
+	softResourceElementPrefix = "cat_soft_l_"
+	contextElementPrefix      = "cat_ac_"
+)
+
+// Reports whether an element is one of the category's resources or context
+// variables, which can have any name.
+func isNamedCategoryElement(element string) bool {
+	for _, prefix := range []string{resourceElementPrefix, softResourceElementPrefix, contextElementPrefix} {
+		if strings.HasPrefix(element, prefix) && len(element) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats the elements that come from the category, returning false if the
+// element isn't one of them.
+func formatCategoryElement(j *Job, element string) (string, bool) {
+	if !strings.HasPrefix(element, "cat_") || !IsElement(element) {
+		return "", false
+	}
+	c, err := j.ParsedCategory()
+	if err != nil {
+		return "(invalid)", true
+	}
+
+	switch {
+	case strings.HasPrefix(element, resourceElementPrefix):
+		return c.Resources[strings.TrimPrefix(element, resourceElementPrefix)], true
+	case strings.HasPrefix(element, softResourceElementPrefix):
+		return c.SoftResources[strings.TrimPrefix(element, softResourceElementPrefix)], true
+	case strings.HasPrefix(element, contextElementPrefix):
+		return c.Context[strings.TrimPrefix(element, contextElementPrefix)], true
+	}
+
+	switch element {
+	case "cat_pe":
+		return c.PE, true
+	case "cat_pe_slots":
+		return c.PESlots, true
+	case "cat_queue":
+		return strings.Join(c.Queues, ","), true
+	case "cat_master_queue":
+		return strings.Join(c.MasterQueues, ","), true
+	case "cat_project":
+		return c.Project, true
+	case "cat_owner":
+		return c.Owner, true
+	case "cat_access_lists":
+		return strings.Join(c.AccessLists, ","), true
+	case "cat_resources":
+		return joinAssignments(c.Resources), true
+	}
+	return "", false
+}
+
+// Works out the requested walltime from the category, for rows from before
+// h_rt was stored separately.
+func reqTimeFromCategory(j *Job) int {
+	c, err := j.ParsedCategory()
+	if err != nil {
+		return 0
+	}
+	walltime, err := ParseWalltime(c.Resources["h_rt"])
+	if err != nil {
+		return 0
+	}
+	return int(walltime.Seconds())
+}
@@ -0,0 +1,154 @@
+package accounting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseCategory(t *testing.T) {
+	// Makes a category with the maps made, as ParseCategory does.
+	category := func(set func(*Category)) Category {
+		c := Category{
+			Resources:     map[string]string{},
+			SoftResources: map[string]string{},
+			Context:       map[string]string{},
+			Other:         map[string]string{},
+		}
+		set(&c)
+		return c
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want Category
+	}{
+		{"empty", "", category(func(c *Category) {})},
+		{"everything",
+			"-U users,staff -u ccaaxyz -l h_rt=3600,memory=4G,gpu=1 -pe mpi 16 -q a.q,b.q -masterq m.q -P Free -ac a=b,c=d",
+			category(func(c *Category) {
+				c.AccessLists = []string{"users", "staff"}
+				c.Owner = "ccaaxyz"
+				c.Resources = map[string]string{"h_rt": "3600", "memory": "4G", "gpu": "1"}
+				c.PE = "mpi"
+				c.PESlots = "16"
+				c.Queues = []string{"a.q", "b.q"}
+				c.MasterQueues = []string{"m.q"}
+				c.Project = "Free"
+				c.Context = map[string]string{"a": "b", "c": "d"}
+			})},
+		{"resource lists with commas",
+			"-l h_rt=3600,,memory=1G, -l tmpfs=10G,exclusive",
+			category(func(c *Category) {
+				c.Resources = map[string]string{"h_rt": "3600", "memory": "1G", "tmpfs": "10G", "exclusive": "true"}
+			})},
+		{"resources with = in the value", "-l h=node-a01,arch=lx-amd64",
+			category(func(c *Category) { c.Resources = map[string]string{"h": "node-a01", "arch": "lx-amd64"} })},
+		{"soft and hard resources",
+			"-soft -l gpu_type=v100 -hard -l gpu=1",
+			category(func(c *Category) {
+				c.SoftResources = map[string]string{"gpu_type": "v100"}
+				c.Resources = map[string]string{"gpu": "1"}
+			})},
+		{"PE slot range", "-pe smp 8-16",
+			category(func(c *Category) { c.PE = "smp"; c.PESlots = "8-16" })},
+		{"PE slot range that looks like an option", "-pe mpi -4 -l h_rt=60",
+			category(func(c *Category) {
+				c.PE = "mpi"
+				c.PESlots = "-4"
+				c.Resources = map[string]string{"h_rt": "60"}
+			})},
+		{"queues in more than one option", "-q a.q -q b.q,c.q",
+			category(func(c *Category) { c.Queues = []string{"a.q", "b.q", "c.q"} })},
+		{"context variable without a value", "-ac DEBUG,x=1",
+			category(func(c *Category) { c.Context = map[string]string{"DEBUG": "", "x": "1"} })},
+		{"other options", "-binding linear:1 -notify -l h_rt=60",
+			category(func(c *Category) {
+				c.Other = map[string]string{"-binding": "linear:1", "-notify": ""}
+				c.Resources = map[string]string{"h_rt": "60"}
+			})},
+		{"extra spaces", "  -P   Free  ",
+			category(func(c *Category) { c.Project = "Free" })},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseCategory(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseCategoryErrors(t *testing.T) {
+	for _, in := range []string{
+		"users -l h_rt=60",
+		"-",
+		"-pe",
+		"-pe mpi",
+		"-P",
+		"-P Free Other",
+		"-u",
+		"-u ccaaxyz ccaaabc",
+		"-l =3600",
+		"-l h_rt=60,=1G",
+		"-ac =x",
+	} {
+		if _, err := ParseCategory(in); !errors.Is(err, ErrInvalidCategory) {
+			t.Errorf("ParseCategory(%q): got %v, want ErrInvalidCategory", in, err)
+		}
+	}
+}
+
+func TestCategoryString(t *testing.T) {
+	in := "-ac b=2,a=1 -P Free -q b.q,a.q -pe mpi 16 -soft -l y=1 -hard -l memory=4G,h_rt=3600 -u ccaaxyz -U users -notify"
+	want := "-U users -u ccaaxyz -l h_rt=3600,memory=4G -soft -l y=1 -hard -pe mpi 16 -q b.q,a.q -P Free -ac a=1,b=2 -notify"
+	c, err := ParseCategory(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// What String writes parses back to the same thing.
+	again, err := ParseCategory(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, c) {
+		t.Errorf("got %+v after a round trip, want %+v", again, c)
+	}
+}
+
+func TestCategoryElements(t *testing.T) {
+	job := Job{Category: "-U users -l h_rt=3600,memory=4G -soft -l gpu=1 -pe mpi 16 -q a.q -P Free -ac x=y"}
+	tests := map[string]string{
+		"cat_l_h_rt":       "3600",
+		"cat_l_memory":     "4G",
+		"cat_l_gpu":        "",
+		"cat_soft_l_gpu":   "1",
+		"cat_ac_x":         "y",
+		"cat_pe":           "mpi",
+		"cat_pe_slots":     "16",
+		"cat_queue":        "a.q",
+		"cat_project":      "Free",
+		"cat_access_lists": "users",
+		"cat_resources":    "h_rt=3600,memory=4G",
+	}
+	for element, want := range tests {
+		if got := FormatElement(&job, element); got != want {
+			t.Errorf("%s: got %q, want %q", element, got, want)
+		}
+	}
+
+	broken := Job{Category: "-pe mpi"}
+	if got := FormatElement(&broken, "cat_pe"); got != "(invalid)" {
+		t.Errorf("got %q for an invalid category, want (invalid)", got)
+	}
+}
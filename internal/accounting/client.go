@@ -0,0 +1,90 @@
+package accounting
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// DefaultDSN is the server with the accounting DBs for all our clusters.
+const DefaultDSN = "ccspapp:U4Ah+fSt@tcp(db.rc.ucl.ac.uk:3306)/"
+
+// A Client searches the accounting DBs on a MySQL/MariaDB server.
+type Client struct {
+	db *sql.DB
+
+	// If set, queries and row counts are logged.
+	Debug bool
+}
+
+// NewClient makes a Client for the server in a go-sql-driver DSN.
+// The DSN shouldn't name a DB, because each query names its own.
+func NewClient(dsn string) (*Client, error) {
+	// Might need allowNativePasswords=True in future - need to look into it more
+	//con, err := sql.Open("mysql", "ccspapp:U4Ah+fSt@tcp(mysql.rc.ucl.ac.uk:3306)/?allowNativePasswords=True")
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open accounting DB connection: %w", err)
+	}
+	return &Client{db: db}, nil
+}
+
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// Find returns the jobs matching a query, in order of end time.
+func (c *Client) Find(ctx context.Context, q Query) ([]Job, error) {
+	query, err := q.SQL()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Debug {
+		log.Printf("Making query: %s", query)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not query accounting DB: %w", err)
+	}
+	defer rows.Close()
+
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	jobs = FilterJobs(jobs, q.Filters)
+
+	if c.Debug {
+		log.Printf("%d rows captured", len(jobs))
+	}
+	return jobs, nil
+}
+
+// MostRecentRowTime returns the time of the latest submission or end in an accounting DB,
+// which is roughly when it was last updated.
+func (c *Client) MostRecentRowTime(ctx context.Context, dbName string) (time.Time, error) {
+	var maxSubTime int
+	var maxEndTime int
+
+	// Normally we'd iterate over rows but this will only ever return 1.
+	err := c.db.QueryRowContext(ctx, fmt.Sprintf("SELECT MAX(`submission_time`) AS `max_sub_time`, MAX(`end_time`) AS `max_end_time` FROM (SELECT * FROM `%s`.`accounting` ORDER BY id DESC LIMIT 1000) AS t", dbName)).Scan(&maxSubTime, &maxEndTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not get most recent row time: %w", err)
+	}
+
+	var maxTimestamp int
+	if maxEndTime > maxSubTime {
+		maxTimestamp = maxEndTime
+	} else {
+		maxTimestamp = maxSubTime
+	}
+
+	// The 0 is for nanoseconds, we don't record those.
+	return time.Unix(int64(maxTimestamp), 0), nil
+}
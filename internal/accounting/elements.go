@@ -0,0 +1,330 @@
+package accounting
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatElement returns the named element of a job, formatted for display.
+// Element names are the accounting table's column names, plus the calculated
+// values described in ElementDescriptions.
+func FormatElement(s *Job, element string) string {
+	switch element {
+	case "id":
+		return strconv.Itoa(s.Id)
+	case "_pos":
+		return strconv.Itoa(s.Pos)
+	case "_checksum":
+		return s.Checksum
+	case "qname":
+		return s.Qname
+	case "hostname":
+		return Unqdn(s.Hostname)
+	case "ugroup":
+		return s.Ugroup
+	case "owner":
+		return s.Owner
+	case "job_name":
+		return s.JobName
+	case "job_number":
+		return strconv.Itoa(s.JobNumber)
+	case "account":
+		return s.Account
+	case "priority":
+		return strconv.Itoa(s.Priority)
+	case "submission_time":
+		return strconv.Itoa(s.SubmissionTime)
+	case "start_time":
+		return strconv.Itoa(s.StartTime)
+	case "end_time":
+		return strconv.Itoa(s.EndTime)
+	case "failed":
+		return strconv.Itoa(s.Failed)
+	case "exit_status":
+		return strconv.Itoa(s.ExitStatus)
+	case "ru_wallclock":
+		return strconv.Itoa(s.RuWallclock)
+	case "ru_utime":
+		return strconv.FormatFloat(s.RuUtime, 'G', 9, 32)
+	case "ru_stime":
+		return strconv.FormatFloat(s.RuStime, 'G', 9, 32)
+	case "ru_maxrss":
+		return strconv.FormatFloat(s.RuMaxrss, 'G', 9, 32)
+	case "ru_ixrss":
+		return strconv.FormatFloat(s.RuIxrss, 'G', 9, 32)
+	case "ru_ismrss":
+		return strconv.FormatFloat(s.RuIsmrss, 'G', 9, 32)
+	case "ru_idrss":
+		return strconv.FormatFloat(s.RuIdrss, 'G', 9, 32)
+	case "ru_isrss":
+		return strconv.FormatFloat(s.RuIsrss, 'G', 9, 32)
+	case "ru_minflt":
+		return strconv.FormatFloat(s.RuMinflt, 'G', 9, 32)
+	case "ru_majflt":
+		return strconv.FormatFloat(s.RuMajflt, 'G', 9, 32)
+	case "ru_nswap":
+		return strconv.FormatFloat(s.RuNswap, 'G', 9, 32)
+	case "ru_inblock":
+		return strconv.FormatFloat(s.RuInblock, 'G', 9, 32)
+	case "ru_oublock":
+		return strconv.FormatFloat(s.RuOublock, 'G', 9, 32)
+	case "ru_msgsnd":
+		return strconv.FormatFloat(s.RuMsgsnd, 'G', 9, 32)
+	case "ru_msgrcv":
+		return strconv.FormatFloat(s.RuMsgrcv, 'G', 9, 32)
+	case "ru_nsignals":
+		return strconv.FormatFloat(s.RuNsignals, 'G', 9, 32)
+	case "ru_nvcsw":
+		return strconv.FormatFloat(s.RuNvcsw, 'G', 9, 32)
+	case "ru_nivcsw":
+		return strconv.FormatFloat(s.RuNivcsw, 'G', 9, 32)
+	case "project":
+		return s.Project
+	case "department":
+		return s.Department
+	case "granted_pe":
+		return s.GrantedPe
+	case "slots":
+		return strconv.Itoa(s.Slots)
+	case "task_number":
+		return strconv.Itoa(s.TaskNumber)
+	case "cpu":
+		return strconv.FormatFloat(s.Cpu, 'G', 9, 32)
+	case "mem":
+		return strconv.FormatFloat(s.Mem, 'G', 9, 32)
+	case "io":
+		return strconv.FormatFloat(s.Io, 'G', 9, 32)
+	case "category":
+		return s.Category
+	case "iow":
+		return strconv.FormatFloat(s.Iow, 'G', 9, 32)
+	case "pe_taskid":
+		return s.PeTaskid
+	case "maxvmem":
+		return strconv.FormatFloat(s.Maxvmem, 'G', 9, 32)
+	case "arid":
+		return strconv.Itoa(s.Arid)
+	case "ar_submission_time":
+		return strconv.Itoa(s.ArSubmissionTime)
+	case "cost":
+		if s.Cost.Valid == true {
+			return strconv.FormatInt(s.Cost.Int64, 10)
+		} else {
+			return "(null)"
+		}
+	case "C__l__bonus":
+		return strconv.Itoa(s.C__l__bonus)
+	case "C__l__cpu":
+		return strconv.Itoa(s.C__l__cpu)
+	case "C__l__gpu":
+		return strconv.Itoa(s.C__l__gpu)
+	case "C__l__h_rss":
+		return s.C__l__h_rss
+	case "C__l__h_rt":
+		return s.C__l__h_rt
+	case "C__l__h_vmem":
+		return s.C__l__h_vmem
+	case "C__l__memory":
+		return s.C__l__memory
+	case "C__l__penalty":
+		return strconv.FormatFloat(s.C__l__penalty, 'G', 9, 32)
+	case "C__l__threads":
+		return strconv.Itoa(s.C__l__threads)
+	case "fsubtime":
+		return s.Fsubtime
+	case "fstime":
+		return s.Fstime
+	case "fetime":
+		return s.Fetime
+	case "slowdown":
+		return strconv.FormatFloat(s.Slowdown, 'f', 1, 32)
+	case "ewalltime":
+		return strconv.Itoa(s.Ewalltime)
+	case "waittime":
+		return strconv.Itoa(s.Waittime)
+	case "req_time":
+		return s.ReqTime
+	case "req_time_calc":
+		return strconv.Itoa(s.ReqTimeCalc)
+	case "req_slowdown":
+		if s.ReqSlowdown.Valid == true {
+			return strconv.FormatFloat(s.ReqSlowdown.Float64, 'f', 1, 32)
+		} else {
+			return "(null)"
+		}
+	case "cpu_efficiency":
+		return strconv.FormatFloat(s.CpuEfficiency, 'f', 9, 32)
+	default:
+		if value, ok := formatCategoryElement(s, element); ok {
+			return value
+		}
+		return "(element not found)"
+	}
+
+}
+
+type ElementDesc struct {
+	Label       string
+	Description string
+}
+
+// ElementDescriptions lists the elements worth showing to users, with a short
+// description of each. FormatElement accepts more than these.
+var ElementDescriptions = []ElementDesc{
+	{"qname", "the name of the internal queue this job used"},
+	{"hostname", "the hostname of the master node this job ran on"},
+	{"ugroup", "the effective group id of the job owner"},
+	{"owner", "the user who owns the job"},
+	{"job_name", "the name of the job in the scheduler"},
+	{"job_number", "the job ID"},
+	{"account", "a string used to calculate Gold spending"},
+	{"priority", "priority value assigned to the job, by the queue"},
+	{"submission_time", "the time the job was submitted, in seconds since the UNIX epoch"},
+	{"start_time", "the time the job started, in seconds since the UNIX epoch (0 if failed to start)"},
+	{"end_time", "the time the job ended, in seconds since the UNIX epoch (0 if failed to start)"},
+	{"failed", "a numeric error code indicated whether and why a job failed at the scheduler level"},
+	{"exit_status", "the exit status of the job, or an additional error code from the scheduler in case of failure"},
+	{"ewalltime", "elapsed time for the job"},
+	// I don't trust the ru_ ones to mean anything sensible
+	// {"ru_wallclock", ""},
+	// {"ru_utime,", ""},
+	// {"ru_stime,", ""},
+	// {"ru_maxrss,", ""},
+	// {"ru_ixrss,", ""},
+	// {"ru_ismrss,", ""},
+	// {"ru_idrss,", ""},
+	// {"ru_isrss,", ""},
+	// {"ru_minflt,", ""},
+	// {"ru_majflt,", ""},
+	// {"ru_nswap,", ""},
+	// {"ru_inblock,", ""},
+	// {"ru_oublock,", ""},
+	// {"ru_msgsnd,", ""},
+	// {"ru_msgrcv,", ""},
+	// {"ru_nsignals,", ""},
+	// {"ru_nvcsw,", ""},
+	// {"ru_nivcsw,", ""},
+	{"slots", "'slots' granted to the job by the scheduler"},
+	{"cost", "number of cores blocked out by the job (virtual cores on clusters with hyperthreading)"},
+	{"task_number", "the task ID, for array jobs"},
+	// I don't trust the cpu, mem, or io ones either
+	// {"cpu", ""},
+	// {"mem", ""},
+	// {"io", ""},
+	// {"iow", ""},
+	// {"maxvmem", ""},
+	{"category", "some stuck-together info about the job"},
+	// Then there's some other stuff which doesn't apply to any of our jobs
+	// {"pe_taskid", "this would only be populated if we had the accounting_summary setting turned off in the scheduler. See `man accounting`."},
+	// {"arid", "advanced reservation ID. We never use these."},
+	// {"ar_submission_time", "advanced reservation submission time. We never use these."},
+	// And then the subset of category break-outs that actually seem useful
+	{"C__l__threads", "whether the job requested use of all hyperthreaded cores"},
+	{"C__l__gpu", "number of GPUs requested"},
+	{"C__l__memory", "RAM per core requested"},
+	// And the ones that don't
+	// {"C__l__bonus", "Don't know"},
+	// {"C__l__cpu", "Don't know"},
+	// {"C__l__h_rt", "The same as req_time"},
+	// {"C__l__penalty", "Don't know"},
+	// {"C__l__h_rss", "A memory resource request I don't think we use"},
+	// {"C__l__h_vmem", "Ditto"},
+	// The parts of the category, parsed out
+	{"cat_l_<resource>", "any resource requested with -l, e.g. cat_l_h_rt, cat_l_memory, cat_l_tmpfs"},
+	{"cat_soft_l_<resource>", "any soft resource request"},
+	{"cat_resources", "all the resources requested with -l"},
+	{"cat_pe", "the parallel environment requested"},
+	{"cat_pe_slots", "the number of slots requested from the parallel environment (can be a range)"},
+	{"cat_queue", "the queues requested with -q"},
+	{"cat_master_queue", "the queues requested for the master task with -masterq"},
+	{"cat_project", "the project requested with -P"},
+	{"cat_owner", "the user the category was for"},
+	{"cat_access_lists", "the access lists the job was allowed to use"},
+	{"cat_ac_<variable>", "any context variable set with -ac"},
+	// And then some add-ons, calculated rather than stored (except req_time, which used to be calculated)
+	{"fsubtime", "submission time, converted into readable format"},
+	{"fstime", "start time, converted into readable format"},
+	{"fetime", "end time, converted into readable format"},
+	{"waittime", "how long the job spent waiting (0 if failed to start)"},
+	{"cpu_efficiency", "experimental: number of CPU processing seconds divided by elapsed walltime"},
+	{"req_time", "maximum walltime requested by job"},
+	{"req_time_calc", "maximum walltime requested by job, in seconds (parsed from the category, for jobs before req_time was stored)"},
+	{"slowdown", "wait time + run time / run_time"},
+	{"req_slowdown", "slowdown, calculated from time requested rather than run time"},
+	{"stdset", "a shortcut for the default set of printed fields"},
+	{"memory", "a shortcut for fields about memory requested and used"},
+	{"timing", "a shortcut for fields about when jobs were waiting and running"},
+	{"failures", "a shortcut for fields about why jobs failed"},
+}
+
+// StandardElements is the default set of elements shown, also available as "stdset".
+var StandardElements = []string{"fstime", "fetime", "hostname", "owner", "job_number", "task_number", "exit_status", "job_name"}
+
+// ElementSets are named lists of elements that can be used in place of an
+// element name. More can be added, e.g. from preset files.
+var ElementSets = map[string][]string{
+	"stdset":   StandardElements,
+	"memory":   {"owner", "job_number", "task_number", "slots", "C__l__memory", "maxvmem", "ru_maxrss", "mem", "job_name"},
+	"timing":   {"owner", "job_number", "task_number", "fsubtime", "fstime", "fetime", "waittime", "ewalltime", "req_time", "slowdown"},
+	"failures": {"fetime", "hostname", "owner", "job_number", "task_number", "failed", "exit_status", "job_name"},
+}
+
+// ExpandElements splits a CSV list of element names, expanding any element sets.
+func ExpandElements(elements string) []string {
+	var expanded []string
+	for _, el := range strings.Split(elements, ",") {
+		if set, ok := ElementSets[el]; ok {
+			expanded = append(expanded, set...)
+		} else {
+			expanded = append(expanded, el)
+		}
+	}
+	return expanded
+}
+
+// AllElements lists every element FormatElement knows, in table column order
+// followed by the calculated ones, apart from the category's resources and
+// context variables (cat_l_<resource> and so on).
+var AllElements = []string{
+	"id", "_pos", "_checksum", "qname", "hostname", "ugroup", "owner", "job_name", "job_number",
+	"account", "priority", "submission_time", "start_time", "end_time", "failed", "exit_status",
+	"ru_wallclock", "ru_utime", "ru_stime", "ru_maxrss", "ru_ixrss", "ru_ismrss", "ru_idrss", "ru_isrss",
+	"ru_minflt", "ru_majflt", "ru_nswap", "ru_inblock", "ru_oublock", "ru_msgsnd", "ru_msgrcv",
+	"ru_nsignals", "ru_nvcsw", "ru_nivcsw", "project", "department", "granted_pe", "slots",
+	"task_number", "cpu", "mem", "io", "category", "iow", "pe_taskid", "maxvmem", "arid",
+	"ar_submission_time", "cost", "C__l__bonus", "C__l__cpu", "C__l__gpu", "C__l__h_rss",
+	"C__l__h_rt", "C__l__h_vmem", "C__l__memory", "C__l__penalty", "C__l__threads",
+	"fsubtime", "fstime", "fetime", "slowdown", "ewalltime", "waittime", "cpu_efficiency",
+	"req_time", "req_time_calc", "req_slowdown",
+	"cat_resources", "cat_pe", "cat_pe_slots", "cat_queue", "cat_master_queue", "cat_project",
+	"cat_owner", "cat_access_lists",
+}
+
+// IsElement reports whether FormatElement knows an element.
+func IsElement(element string) bool {
+	return stringInSlice(element, AllElements) || isNamedCategoryElement(element)
+}
+
+// Compares element values numerically if they both look like numbers.
+func lessElement(a string, b string) bool {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return fa < fb
+	}
+	return a < b
+}
+
+// SortJobs sorts jobs by the formatted value of an element, numerically where
+// the values are numbers. Jobs with equal values stay in the same order.
+func SortJobs(jobs []Job, element string, descending bool) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		a := FormatElement(&jobs[i], element)
+		b := FormatElement(&jobs[j], element)
+		if descending {
+			return lessElement(b, a)
+		}
+		return lessElement(a, b)
+	})
+}
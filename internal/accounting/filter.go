@@ -0,0 +1,95 @@
+package accounting
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A Filter picks out jobs by the value of an element. Unlike the rest of a
+// Query, filters are checked in Go, so they work on any element, including
+// the calculated ones and the parts of the category.
+type Filter struct {
+	Element string
+	Op      string // One of FilterOps.
+	Value   string
+}
+
+// FilterOps are the comparisons a Filter can make. = and != take wildcards, and
+// the others compare numerically where both values are numbers.
+var FilterOps = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+var ErrInvalidFilter = errors.New("invalid filter, please use <element><op><value> with an op of =, !=, <, <=, > or >=")
+
+// ParseFilter parses a filter written like cat_pe=mpi or waittime>3600.
+func ParseFilter(s string) (Filter, error) {
+	// The two-character ops are first in FilterOps, so they're found before
+	//  the ones they start with.
+	for _, op := range FilterOps {
+		if i := strings.Index(s, op); i > 0 {
+			f := Filter{Element: s[:i], Op: op, Value: s[i+len(op):]}
+			if strings.ContainsAny(f.Element, "!<>=") {
+				continue
+			}
+			if !IsElement(f.Element) {
+				return Filter{}, fmt.Errorf("no element called %s (see --list-elements)", f.Element)
+			}
+			return f, nil
+		}
+	}
+	return Filter{}, ErrInvalidFilter
+}
+
+func (f Filter) String() string {
+	return f.Element + f.Op + f.Value
+}
+
+// Matches reports whether a job passes the filter.
+func (f Filter) Matches(j *Job) bool {
+	value := FormatElement(j, f.Element)
+	switch f.Op {
+	case "=":
+		return valueMatches(f.Value, value)
+	case "!=":
+		return !valueMatches(f.Value, value)
+	case "<":
+		return lessElement(value, f.Value)
+	case "<=":
+		return !lessElement(f.Value, value)
+	case ">":
+		return lessElement(f.Value, value)
+	case ">=":
+		return !lessElement(value, f.Value)
+	}
+	return false
+}
+
+func valueMatches(pattern string, value string) bool {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return pattern == value
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+// FilterJobs returns the jobs that pass all the filters.
+func FilterJobs(jobs []Job, filters []Filter) []Job {
+	if len(filters) == 0 {
+		return jobs
+	}
+	filtered := make([]Job, 0, len(jobs))
+	for i := range jobs {
+		passes := true
+		for _, f := range filters {
+			if !f.Matches(&jobs[i]) {
+				passes = false
+				break
+			}
+		}
+		if passes {
+			filtered = append(filtered, jobs[i])
+		}
+	}
+	return filtered
+}
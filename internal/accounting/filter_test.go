@@ -0,0 +1,71 @@
+package accounting
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Filter
+	}{
+		{"cat_pe=mpi", Filter{"cat_pe", "=", "mpi"}},
+		{"job_name!=ser*", Filter{"job_name", "!=", "ser*"}},
+		{"waittime>3600", Filter{"waittime", ">", "3600"}},
+		{"waittime>=3600", Filter{"waittime", ">=", "3600"}},
+		{"cat_l_h_rt<7200", Filter{"cat_l_h_rt", "<", "7200"}},
+		{"cat_l_h_rt<=7200", Filter{"cat_l_h_rt", "<=", "7200"}},
+		{"cat_ac_opts=a=b", Filter{"cat_ac_opts", "=", "a=b"}},
+		{"cat_project=", Filter{"cat_project", "=", ""}},
+	}
+	for _, tc := range tests {
+		got, err := ParseFilter(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseFilter(%q): got %+v, %v, want %+v", tc.in, got, err, tc.want)
+		}
+		if err == nil && got.String() != tc.in {
+			t.Errorf("ParseFilter(%q).String(): got %q", tc.in, got.String())
+		}
+	}
+
+	for _, in := range []string{"", "cat_pe", "=mpi", "!=mpi", "cat_l_=1"} {
+		if _, err := ParseFilter(in); err == nil {
+			t.Errorf("ParseFilter(%q): got no error", in)
+		}
+	}
+	if _, err := ParseFilter("no_such_element=1"); err == nil || errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("got %v for an unknown element, want it to say so", err)
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	job := Job{JobName: "serial-test", Category: "-l h_rt=3600 -pe smp 8", Waittime: 120}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"job_name=serial-test", true},
+		{"job_name=ser*", true},
+		{"job_name=ser", false},
+		{"job_name!=ser*", false},
+		{"cat_pe=smp", true},
+		{"cat_pe_slots>4", true},
+		{"cat_pe_slots<10", true},
+		{"cat_pe_slots>=8", true},
+		{"cat_pe_slots<=7", false},
+		// Numbers are compared as numbers, not strings.
+		{"cat_l_h_rt>900", true},
+		{"waittime<60", false},
+		{"cat_l_gpu=", true},
+	}
+	for _, tc := range tests {
+		f, err := ParseFilter(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Matches(&job); got != tc.want {
+			t.Errorf("%s: got %t, want %t", tc.filter, got, tc.want)
+		}
+	}
+}
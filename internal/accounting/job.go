@@ -0,0 +1,185 @@
+package accounting
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// A Job is one row from a cluster's accounting table, plus the values
+// calculated when it's queried.
+//
+// Parallel jobs can have more than one row (see PeTaskid), and array jobs have
+// one row per task.
+type Job struct {
+	Id               int // 'Primary table id'
+	Pos              int
+	Checksum         string  //  'md5_hex checksum of line in file',
+	Qname            string  // 'Name of the cluster queue in which the job has run.',
+	Hostname         string  // 'Name of the execution host.',
+	Ugroup           string  // 'The effective group id of the job owner when executing the job.',
+	Owner            string  // 'Owner of the Grid Engine job.',
+	JobName          string  // 'Job name.',
+	JobNumber        int     // 'Job identifier - job number.',
+	Account          string  // 'An account string as specified by the qsub(1) or qalter(1) -A option.',
+	Priority         int     // 'Priority value assigned to the job corresponding to the priority parameter in the queue configuration.',
+	SubmissionTime   int     // 'Submission time (GMT unix time stamp).',
+	StartTime        int     // 'Start time (GMT unix time stamp).',
+	EndTime          int     // 'End time (GMT unix time stamp).',
+	Failed           int     // 'Indicates the problem which occurred in case a job could not be started on the execution host.',
+	ExitStatus       int     // 'Exit status of the job script (or Grid Engine specific status in case of certain error conditions).',
+	RuWallclock      int     // 'Difference between end_time and start_time.',
+	RuUtime          float64 // 'user time used',
+	RuStime          float64 // 'system time used',
+	RuMaxrss         float64 // 'maximum resident set size',
+	RuIxrss          float64 // 'integral shared memory size',
+	RuIsmrss         float64
+	RuIdrss          float64       // 'integral unshared data size',
+	RuIsrss          float64       // 'integral unshared stack size',
+	RuMinflt         float64       // 'page reclaims',
+	RuMajflt         float64       // 'page faults',
+	RuNswap          float64       // 'swaps',
+	RuInblock        float64       // 'block input operations',
+	RuOublock        float64       // 'block output operations',
+	RuMsgsnd         float64       // 'messages sent',
+	RuMsgrcv         float64       // 'messages received',
+	RuNsignals       float64       // 'signals received',
+	RuNvcsw          float64       // 'voluntary context switches',
+	RuNivcsw         float64       // 'involuntary context switches',
+	Project          string        // 'The project which was assigned to the job.',
+	Department       string        // 'The department which was assigned to the job.',
+	GrantedPe        string        // 'The parallel environment which was selected for that job.',
+	Slots            int           // 'The number of slots which were dispatched to the job by the scheduler.',
+	TaskNumber       int           // 'Array job task index number.',
+	Cpu              float64       // 'The cpu time usage in seconds.',
+	Mem              float64       // 'The integral memory usage in Gbytes cpu seconds.',
+	Io               float64       // 'The amount of data transferred in input/output operations.',
+	Category         string        // 'A string specifying the job category.',
+	Iow              float64       // 'The io wait time in seconds.',
+	PeTaskid         string        // 'If this identifier is set the task was part of a parallel job and was passed to Grid Engine via the qrsh -inherit interface.',
+	Maxvmem          float64       // 'The maximum vmem size in bytes.',
+	Arid             int           // 'Advance reservation identifier. If the job used resources of an advance reservation then this field contains a positive integer identifier otherwise the value is ''0''.',
+	ArSubmissionTime int           // 'If the job used resources of an advance reservation then this field contains the submission time (GMT unix time stamp) of the advance reservation, otherwise the value is ''0''.',
+	Cost             sql.NullInt64 // This is a special type because at some point something broke and now there are NULL entries for it.
+	C__l__bonus      int           //,
+	C__l__cpu        int           //,
+	C__l__gpu        int           //,
+	C__l__h_rss      string        //,
+	C__l__h_rt       string        //,
+	C__l__h_vmem     string        //,
+	C__l__memory     string        //,
+	C__l__penalty    float64       //,
+	C__l__threads    int           //,
+	// ^-- db columns, v-- statement-calculated values
+	Fsubtime      string          //, 'Formatted submission time',
+	Fstime        string          //, 'Formatted start time',
+	Fetime        string          //, 'Formatted end time',
+	Slowdown      float64         //, '(Bad) slowdown metric',
+	Ewalltime     int             //, 'Elapsed walltime',
+	Waittime      int             //, 'Time between submission and starting',
+	CpuEfficiency float64         //, 'Experimental efficiency calculation',
+	ReqTime       string          //, 'Requested job time (stored) -- this is a string because someone made a mess in the past :(',
+	ReqSlowdown   sql.NullFloat64 //, 'Slowdown metric using requested time (stored) instead of run time. A special type because some rows have invalid req_time data.',
+	// v-- worked out in Go
+	ReqTimeCalc int // Requested job time in seconds, parsed from the category field.
+
+	parsedCategory *parsedCategory // Filled in by ParsedCategory.
+}
+
+// Scans rows selected with SELECT * plus the calculated columns, in that order.
+func scanJobs(rows *sql.Rows) ([]Job, error) {
+	jobs := make([]Job, 0)
+
+	i := 0
+	for rows.Next() {
+		var s Job
+		err := rows.Scan(
+			&s.Id,
+			&s.Pos,
+			&s.Checksum,
+			&s.Qname,
+			&s.Hostname,
+			&s.Ugroup,
+			&s.Owner,
+			&s.JobName,
+			&s.JobNumber,
+			&s.Account,
+			&s.Priority,
+			&s.SubmissionTime,
+			&s.StartTime,
+			&s.EndTime,
+			&s.Failed,
+			&s.ExitStatus,
+			&s.RuWallclock,
+			&s.RuUtime,
+			&s.RuStime,
+			&s.RuMaxrss,
+			&s.RuIxrss,
+			&s.RuIsmrss,
+			&s.RuIdrss,
+			&s.RuIsrss,
+			&s.RuMinflt,
+			&s.RuMajflt,
+			&s.RuNswap,
+			&s.RuInblock,
+			&s.RuOublock,
+			&s.RuMsgsnd,
+			&s.RuMsgrcv,
+			&s.RuNsignals,
+			&s.RuNvcsw,
+			&s.RuNivcsw,
+			&s.Project,
+			&s.Department,
+			&s.GrantedPe,
+			&s.Slots,
+			&s.TaskNumber,
+			&s.Cpu,
+			&s.Mem,
+			&s.Io,
+			&s.Category,
+			&s.Iow,
+			&s.PeTaskid,
+			&s.Maxvmem,
+			&s.Arid,
+			&s.ArSubmissionTime,
+			&s.Cost,
+			&s.C__l__bonus,
+			&s.C__l__cpu,
+			&s.C__l__gpu,
+			&s.C__l__h_rss,
+			&s.C__l__h_rt,
+			&s.C__l__h_vmem,
+			&s.C__l__memory,
+			&s.C__l__penalty,
+			&s.C__l__threads,
+			&s.Fsubtime,
+			&s.Fstime,
+			&s.Fetime,
+			&s.Slowdown,
+			&s.Ewalltime,
+			&s.Waittime,
+			&s.CpuEfficiency,
+			&s.ReqTime,
+			&s.ReqSlowdown,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan accounting row %d: %w", i, err)
+		}
+		s.ReqTimeCalc = reqTimeFromCategory(&s)
+		jobs = append(jobs, s)
+		i += 1
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read accounting rows: %w", err)
+	}
+	return jobs, nil
+}
+
+// Unqdn removes the DNS suffix from a hostname.
+func Unqdn(s string) string {
+	if i := strings.Index(s, "."); i < 0 {
+		return s
+	} else {
+		return s[0:i]
+	}
+}
@@ -0,0 +1,126 @@
+package accounting
+
+import (
+	"math"
+	"sort"
+)
+
+// IsNodeProblem reports whether a job row ended in a way that might be the
+// node's fault rather than the job's.
+func IsNodeProblem(job *Job) bool {
+	// Failed codes under 100 mean the job didn't get going properly on the node,
+	//  e.g. the shepherd couldn't start or the spool directory couldn't be written.
+	// 100 is the scheduler killing the job, usually for going over a limit or being
+	//  qdel'd, and exit status 137 is the SIGKILL that does it, so those are left
+	//  out. Any other signal killing a job is a bit suspicious, though.
+	if (job.Failed != 0) && (job.Failed != 100) {
+		return true
+	}
+	if (job.Failed == 0) && (job.ExitStatus > 128) && (job.ExitStatus != 137) {
+		return true
+	}
+	return false
+}
+
+// HostHealth describes the jobs that ran on an execution host.
+type HostHealth struct {
+	Hostname      string
+	Rows          int     // Job rows (including tasks of parallel jobs) on the host.
+	Problems      int     // Rows that IsNodeProblem.
+	StartFailures int     // Rows with a failed code under 100.
+	Signalled     int     // Rows killed by a signal other than the scheduler's SIGKILL.
+	ProblemRate   float64 // Problems over Rows.
+	PValue        float64 // Chance of at least this many problems if the host were as good as the rest of the cluster.
+	ProblemJobs   []Job   // The rows with problems, in end time order.
+}
+
+// NodeHealthReport works out a HostHealth for every execution host in a set of
+// job rows, most suspicious first, along with the problem rate across the whole
+// set as a baseline.
+func NodeHealthReport(jobs []Job) ([]HostHealth, float64) {
+	byHost := make(map[string]*HostHealth)
+	totalProblems := 0
+	for i := range jobs {
+		job := &jobs[i]
+		h, ok := byHost[job.Hostname]
+		if !ok {
+			h = &HostHealth{Hostname: job.Hostname}
+			byHost[job.Hostname] = h
+		}
+		h.Rows += 1
+		if IsNodeProblem(job) {
+			h.Problems += 1
+			h.ProblemJobs = append(h.ProblemJobs, *job)
+			totalProblems += 1
+			if (job.Failed != 0) && (job.Failed < 100) {
+				h.StartFailures += 1
+			} else if job.ExitStatus > 128 {
+				h.Signalled += 1
+			}
+		}
+	}
+
+	baseline := 0.0
+	if len(jobs) > 0 {
+		baseline = float64(totalProblems) / float64(len(jobs))
+	}
+
+	report := make([]HostHealth, 0, len(byHost))
+	for _, h := range byHost {
+		h.ProblemRate = float64(h.Problems) / float64(h.Rows)
+
+		// The host is compared against everywhere else, so that one really bad
+		//  host doesn't drag the baseline up and hide itself.
+		// The rest of the cluster's rate gets a problem and a good row added
+		//  (Laplace's rule of succession), so that if it happened to have no
+		//  problems at all, a single one elsewhere doesn't look impossible.
+		otherRows := len(jobs) - h.Rows
+		otherRate := baseline
+		if otherRows > 0 {
+			otherRate = float64(totalProblems-h.Problems+1) / float64(otherRows+2)
+		}
+		h.PValue = BinomialTail(h.Problems, h.Rows, otherRate)
+		report = append(report, *h)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].PValue != report[j].PValue {
+			return report[i].PValue < report[j].PValue
+		}
+		if report[i].ProblemRate != report[j].ProblemRate {
+			return report[i].ProblemRate > report[j].ProblemRate
+		}
+		return report[i].Hostname < report[j].Hostname
+	})
+	return report, baseline
+}
+
+// BinomialTail returns the chance of k or more successes from n trials that
+// each succeed with probability p: a one-sided binomial test.
+func BinomialTail(k int, n int, p float64) float64 {
+	if k <= 0 {
+		return 1
+	}
+	if k > n {
+		return 0
+	}
+	if p <= 0 {
+		return 0
+	}
+	if p >= 1 {
+		return 1
+	}
+
+	// Summed in log space, because the binomial coefficients get enormous for
+	//  busy hosts.
+	logP := math.Log(p)
+	logQ := math.Log1p(-p)
+	lgN, _ := math.Lgamma(float64(n + 1))
+	tail := 0.0
+	for i := k; i <= n; i++ {
+		lgI, _ := math.Lgamma(float64(i + 1))
+		lgNI, _ := math.Lgamma(float64(n - i + 1))
+		tail += math.Exp(lgN - lgI - lgNI + float64(i)*logP + float64(n-i)*logQ)
+	}
+	return math.Min(tail, 1)
+}
@@ -0,0 +1,173 @@
+package accounting
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestIsNodeProblem(t *testing.T) {
+	tests := []struct {
+		name       string
+		failed     int
+		exitStatus int
+		want       bool
+	}{
+		{"fine", 0, 0, false},
+		{"job's own error", 0, 1, false},
+		{"couldn't start", 26, 0, true},
+		{"killed by the scheduler", 100, 137, false},
+		{"SIGKILL", 0, 137, false},
+		{"segfault", 0, 139, true},
+		{"SIGBUS", 0, 135, true},
+	}
+	for _, tc := range tests {
+		job := Job{Failed: tc.failed, ExitStatus: tc.exitStatus}
+		if got := IsNodeProblem(&job); got != tc.want {
+			t.Errorf("%s: got %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBinomialTail(t *testing.T) {
+	tests := []struct {
+		k    int
+		n    int
+		p    float64
+		want float64
+	}{
+		{1, 1, 0.5, 0.5},
+		{2, 4, 0.5, 11.0 / 16},
+		{10, 10, 0.5, 1.0 / 1024},
+		{3, 10, 0.1, 1 - (0.3486784401 + 0.387420489 + 0.1937102445)},
+		{3, 5, 1.0 / 15, 2031.0 / 759375},
+		// Big enough that the coefficients would overflow without logs.
+		{5000, 10000, 0.5, 0.5039894},
+		{0, 5, 0.3, 1},
+		{-1, 5, 0.3, 1},
+		{6, 5, 0.3, 0},
+		{1, 5, 0, 0},
+		{0, 5, 0, 1},
+		{5, 5, 1, 1},
+		{0, 0, 0.5, 1},
+	}
+	for _, tc := range tests {
+		got := BinomialTail(tc.k, tc.n, tc.p)
+		if math.Abs(got-tc.want) > 1e-6 {
+			t.Errorf("BinomialTail(%d, %d, %v): got %v, want %v", tc.k, tc.n, tc.p, got, tc.want)
+		}
+	}
+}
+
+// Makes n rows on a host, the first problems of which are node problems.
+func hostRows(hostname string, n int, problems int) []Job {
+	var jobs []Job
+	for i := 0; i < n; i++ {
+		job := Job{Hostname: hostname}
+		if i < problems {
+			job.ExitStatus = 139
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func TestNodeHealthReport(t *testing.T) {
+	type hostResult struct {
+		hostname string
+		rows     int
+		problems int
+		rate     float64
+		pValue   float64
+	}
+	tests := []struct {
+		name         string
+		jobs         []Job
+		wantBaseline float64
+		want         []hostResult
+	}{
+		{"no jobs", nil, 0, []hostResult{}},
+		{"single host",
+			hostRows("node-a01", 4, 2),
+			0.5,
+			// With nothing to compare against, the host is compared with itself.
+			[]hostResult{{"node-a01", 4, 2, 0.5, 11.0 / 16}}},
+		{"no problems anywhere",
+			append(hostRows("node-b01", 3, 0), hostRows("node-a01", 3, 0)...),
+			0,
+			[]hostResult{{"node-a01", 3, 0, 0, 1}, {"node-b01", 3, 0, 0, 1}}},
+		// The rest's rates have a problem and a good row added, so here
+		//  they're 4 in 5 and 3 in 4 rather than certain.
+		{"problems everywhere",
+			append(hostRows("node-a01", 2, 2), hostRows("node-b01", 3, 3)...),
+			1,
+			[]hostResult{{"node-b01", 3, 3, 1, 0.421875}, {"node-a01", 2, 2, 1, 0.64}}},
+		{"one bad host",
+			append(append(hostRows("node-ok", 5, 0), hostRows("node-good", 10, 1)...), hostRows("node-bad", 5, 3)...),
+			4.0 / 20,
+			[]hostResult{
+				// 3 of 5, where the rest have 1 in 15, counted as 2 in 17.
+				{"node-bad", 5, 3, 0.6, 0.0135450},
+				// 1 of 10, where the rest have 3 in 10, counted as 4 in 12.
+				{"node-good", 10, 1, 0.1, 1 - 0.0173415},
+				{"node-ok", 5, 0, 0, 1},
+			}},
+		// With no problems anywhere else, one isn't suspicious in a lot of rows.
+		{"no problems elsewhere",
+			append(hostRows("node-a01", 1000, 1), hostRows("node-b01", 1000, 0)...),
+			1.0 / 2000,
+			[]hostResult{
+				{"node-a01", 1000, 1, 0.001, 0.6315692},
+				// 0 problems is never suspicious.
+				{"node-b01", 1000, 0, 0, 1},
+			}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			report, baseline := NodeHealthReport(tc.jobs)
+			if math.Abs(baseline-tc.wantBaseline) > 1e-9 {
+				t.Errorf("got baseline %v, want %v", baseline, tc.wantBaseline)
+			}
+			got := []hostResult{}
+			for _, h := range report {
+				got = append(got, hostResult{h.Hostname, h.Rows, h.Problems, h.ProblemRate, h.PValue})
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got hosts %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				g, w := got[i], tc.want[i]
+				if g.hostname != w.hostname || g.rows != w.rows || g.problems != w.problems ||
+					math.Abs(g.rate-w.rate) > 1e-9 || math.Abs(g.pValue-w.pValue) > 1e-6 {
+					t.Errorf("host %d: got %+v, want %+v", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeHealthReportCounts(t *testing.T) {
+	jobs := []Job{
+		{Hostname: "node-a01", Failed: 26, EndTime: 1},
+		{Hostname: "node-a01", ExitStatus: 139, EndTime: 2},
+		{Hostname: "node-a01", Failed: 100, ExitStatus: 137, EndTime: 3},
+		{Hostname: "node-a01", ExitStatus: 1, EndTime: 4},
+	}
+	report, _ := NodeHealthReport(jobs)
+	if len(report) != 1 {
+		t.Fatalf("got %d hosts, want 1", len(report))
+	}
+	h := report[0]
+	if h.Rows != 4 || h.Problems != 2 || h.StartFailures != 1 || h.Signalled != 1 {
+		t.Errorf("got %d rows, %d problems, %d start failures and %d signalled, want 4, 2, 1 and 1",
+			h.Rows, h.Problems, h.StartFailures, h.Signalled)
+	}
+	var ends []int
+	for _, job := range h.ProblemJobs {
+		ends = append(ends, job.EndTime)
+	}
+	if !reflect.DeepEqual(ends, []int{1, 2}) {
+		t.Errorf("got problem jobs ending at %v, want [1 2]", ends)
+	}
+}
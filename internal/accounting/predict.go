@@ -0,0 +1,218 @@
+package accounting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Request is the resources a job asks the scheduler for.
+type Request struct {
+	Cores    int
+	Memory   float64 // Bytes per core, or 0 to match any.
+	Walltime time.Duration
+	GPUs     int
+}
+
+func (r Request) String() string {
+	description := fmt.Sprintf("%d core(s), walltime %s", r.Cores, r.Walltime)
+	if r.Memory > 0 {
+		description += fmt.Sprintf(", %s per core", FormatMemory(r.Memory))
+	}
+	if r.GPUs > 0 {
+		description += fmt.Sprintf(", %d GPU(s)", r.GPUs)
+	}
+	return description
+}
+
+var ErrInvalidMemory = errors.New("invalid memory size, please use e.g. 512M or 4G")
+var ErrInvalidWalltime = errors.New("invalid walltime, please use hours:minutes:seconds or seconds")
+
+// ParseMemory reads a memory size in bytes the way the scheduler takes them, e.g. 4G.
+// Upper case suffixes are powers of 1024 and lower case ones are powers of 1000.
+func ParseMemory(s string) (float64, error) {
+	multipliers := map[byte]float64{
+		'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40,
+		'k': 1e3, 'm': 1e6, 'g': 1e9, 't': 1e12,
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrInvalidMemory
+	}
+	multiplier := 1.0
+	if m, ok := multipliers[s[len(s)-1]]; ok {
+		multiplier = m
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, ErrInvalidMemory
+	}
+	return n * multiplier, nil
+}
+
+// FormatMemory writes a memory size in bytes in the largest whole-ish unit, e.g. 4G.
+func FormatMemory(bytes float64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   float64
+	}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if bytes >= unit.size {
+			return strconv.FormatFloat(bytes/unit.size, 'f', -1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatFloat(bytes, 'f', -1, 64)
+}
+
+// ParseWalltime reads a time the way the scheduler takes them, either as
+// hours:minutes:seconds or as seconds.
+func ParseWalltime(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, ErrInvalidWalltime
+	}
+	seconds := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, ErrInvalidWalltime
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// A job's request, as far as we can tell from its row. ok is false for rows
+// that aren't useful to compare against, e.g. slave rows of parallel jobs, jobs
+// that never started, and old rows from before requested time was stored.
+func requestOf(job *Job) (r Request, ok bool) {
+	if job.PeTaskid != "NONE" || job.StartTime == 0 {
+		return r, false
+	}
+	walltime, err := ParseWalltime(job.C__l__h_rt)
+	if err != nil {
+		return r, false
+	}
+	r.Cores = job.Slots
+	r.Walltime = walltime
+	r.GPUs = job.C__l__gpu
+	// Memory is left as 0 if it wasn't recorded, so it'll match anything.
+	r.Memory, _ = ParseMemory(job.C__l__memory)
+	return r, true
+}
+
+// The columns requestOf and SimilarWaits use, which are all FindRequests gets.
+const requestColumns = "pe_taskid, slots, start_time, " +
+	"CAST(start_time AS SIGNED INTEGER) - CAST(submission_time AS SIGNED INTEGER) AS waittime, " +
+	"`C::l::h_rt`, `C::l::gpu`, `C::l::memory`"
+
+// RequestsSQL returns the SQL for FindRequests: the master rows of the jobs a
+// query would find that started, with only the columns needed to compare
+// their requests. Last and Filters aren't used.
+func (q Query) RequestsSQL() (string, error) {
+	q = q.WithDefaults()
+
+	queryFrom, err := q.AccountingDB()
+	if err != nil {
+		return "", err
+	}
+	conditions, err := q.Conditions()
+	if err != nil {
+		return "", err
+	}
+	conditions = append(conditions, "pe_taskid = \"NONE\"", "start_time > 0")
+	return fmt.Sprintf("SELECT %s FROM %s.accounting WHERE %s", requestColumns, queryFrom, strings.Join(conditions, " AND ")), nil
+}
+
+// FindRequests gets the jobs a query would find, for SimilarWaits, with only
+// what it needs filled in. Predictions look at everyone's jobs over weeks,
+// which would be a lot of whole rows to hold at once.
+func (c *Client) FindRequests(ctx context.Context, q Query) ([]Job, error) {
+	query, err := q.RequestsSQL()
+	if err != nil {
+		return nil, err
+	}
+	if c.Debug {
+		log.Printf("Making query: %s", query)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not query accounting DB: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]Job, 0)
+	for rows.Next() {
+		var j Job
+		err := rows.Scan(&j.PeTaskid, &j.Slots, &j.StartTime, &j.Waittime, &j.C__l__h_rt, &j.C__l__gpu, &j.C__l__memory)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan accounting row: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read accounting rows: %w", err)
+	}
+	if c.Debug {
+		log.Printf("%d rows captured", len(jobs))
+	}
+	return jobs, nil
+}
+
+func withinFactor(value float64, target float64, factor float64) bool {
+	return (value >= target/factor) && (value <= target*factor)
+}
+
+// Similar reports whether a request is within a factor of this one in cores,
+// memory and walltime, and asks for the same number of GPUs.
+func (r Request) Similar(other Request, factor float64) bool {
+	if r.GPUs != other.GPUs {
+		return false
+	}
+	if !withinFactor(float64(other.Cores), float64(r.Cores), factor) {
+		return false
+	}
+	if !withinFactor(other.Walltime.Seconds(), r.Walltime.Seconds(), factor) {
+		return false
+	}
+	if (r.Memory > 0) && (other.Memory > 0) && !withinFactor(other.Memory, r.Memory, factor) {
+		return false
+	}
+	return true
+}
+
+// SimilarWaits returns how long each job similar to a request waited to start, shortest first.
+func SimilarWaits(jobs []Job, r Request, factor float64) []time.Duration {
+	var waits []time.Duration
+	for i := range jobs {
+		jobRequest, ok := requestOf(&jobs[i])
+		if !ok || !r.Similar(jobRequest, factor) {
+			continue
+		}
+		waits = append(waits, time.Duration(jobs[i].Waittime)*time.Second)
+	}
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	return waits
+}
+
+// Percentile returns the nearest-rank percentile of some sorted durations.
+func Percentile(sorted []time.Duration, percentile float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
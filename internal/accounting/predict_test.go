@@ -0,0 +1,208 @@
+package accounting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"4G", 4 << 30, false},
+		{"512M", 512 << 20, false},
+		{"1.5G", 1.5 * (1 << 30), false},
+		{"2T", 2 << 40, false},
+		{"16K", 16 << 10, false},
+		{"4g", 4e9, false},
+		{"512m", 512e6, false},
+		{"1000", 1000, false},
+		{" 4G ", 4 << 30, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"G", 0, true},
+		{"4X", 0, true},
+		{"-1G", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseMemory(tc.in)
+		if tc.wantErr {
+			if !errors.Is(err, ErrInvalidMemory) {
+				t.Errorf("ParseMemory(%q): got %v, %v, want ErrInvalidMemory", tc.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("ParseMemory(%q): got %v, %v, want %v", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestFormatMemory(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{4 << 30, "4G"},
+		{1.5 * (1 << 30), "1.5G"},
+		{512 << 20, "512M"},
+		{3 << 40, "3T"},
+		{1 << 10, "1K"},
+		{1000, "1000"},
+		{0, "0"},
+	}
+	for _, tc := range tests {
+		if got := FormatMemory(tc.in); got != tc.want {
+			t.Errorf("FormatMemory(%v): got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseWalltime(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"3600", time.Hour, false},
+		{"1:30:00", 90 * time.Minute, false},
+		{"48:00:00", 48 * time.Hour, false},
+		{"10:30", 10*time.Minute + 30*time.Second, false},
+		{"0", 0, false},
+		{" 900 ", 15 * time.Minute, false},
+		{"", 0, true},
+		{"null", 0, true},
+		{"1:2:3:4", 0, true},
+		{"1::00", 0, true},
+		{"-60", 0, true},
+		{"1h", 0, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseWalltime(tc.in)
+		if tc.wantErr {
+			if !errors.Is(err, ErrInvalidWalltime) {
+				t.Errorf("ParseWalltime(%q): got %v, %v, want ErrInvalidWalltime", tc.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("ParseWalltime(%q): got %v, %v, want %v", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		durations  []time.Duration
+		percentile float64
+		want       time.Duration
+	}{
+		{sorted, 50, 5},
+		{sorted, 90, 9},
+		{sorted, 91, 10},
+		{sorted, 100, 10},
+		{sorted, 0, 1},
+		{sorted, 1, 1},
+		{sorted, 150, 10},
+		{[]time.Duration{7}, 50, 7},
+		{[]time.Duration{1, 2, 3}, 50, 2},
+		{[]time.Duration{1, 2, 3, 4}, 75, 3},
+		{nil, 50, 0},
+	}
+	for _, tc := range tests {
+		if got := Percentile(tc.durations, tc.percentile); got != tc.want {
+			t.Errorf("Percentile(%v, %v): got %v, want %v", tc.durations, tc.percentile, got, tc.want)
+		}
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	base := Request{Cores: 16, Memory: 4 << 30, Walltime: 12 * time.Hour}
+	tests := []struct {
+		name  string
+		other Request
+		want  bool
+	}{
+		{"same", base, true},
+		{"within factor", Request{Cores: 32, Memory: 2 << 30, Walltime: 24 * time.Hour}, true},
+		{"too many cores", Request{Cores: 33, Memory: 4 << 30, Walltime: 12 * time.Hour}, false},
+		{"too few cores", Request{Cores: 7, Memory: 4 << 30, Walltime: 12 * time.Hour}, false},
+		{"too long", Request{Cores: 16, Memory: 4 << 30, Walltime: 25 * time.Hour}, false},
+		{"too short", Request{Cores: 16, Memory: 4 << 30, Walltime: 5 * time.Hour}, false},
+		{"too much memory", Request{Cores: 16, Memory: 9 << 30, Walltime: 12 * time.Hour}, false},
+		{"memory not recorded", Request{Cores: 16, Walltime: 12 * time.Hour}, true},
+		{"GPUs", Request{Cores: 16, Memory: 4 << 30, Walltime: 12 * time.Hour, GPUs: 1}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := base.Similar(tc.other, 2); got != tc.want {
+				t.Errorf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+
+	anyMemory := Request{Cores: 16, Walltime: 12 * time.Hour}
+	if !anyMemory.Similar(Request{Cores: 16, Memory: 100 << 30, Walltime: 12 * time.Hour}, 2) {
+		t.Error("a request without memory didn't match one with it")
+	}
+}
+
+func TestSimilarWaits(t *testing.T) {
+	job := func(waittime int, slots int, hRT string, memory string, gpus int) Job {
+		return Job{PeTaskid: "NONE", StartTime: 1000, Waittime: waittime, Slots: slots, C__l__h_rt: hRT, C__l__memory: memory, C__l__gpu: gpus}
+	}
+	neverStarted := job(50, 4, "3600", "1G", 0)
+	neverStarted.StartTime = 0
+	slaveRow := job(60, 4, "3600", "1G", 0)
+	slaveRow.PeTaskid = "1.node-a01"
+
+	jobs := []Job{
+		job(300, 4, "3600", "1G", 0),
+		job(100, 8, "1:30:00", "", 0),
+		job(200, 4, "3600", "1G", 0),
+		job(10, 4, "3600", "1G", 1),   // Different GPUs.
+		job(20, 64, "3600", "1G", 0),  // Too many cores.
+		job(30, 4, "null", "1G", 0),   // From before h_rt was stored.
+		job(40, 4, "3600", "100G", 0), // Too much memory.
+		neverStarted,
+		slaveRow,
+	}
+	request := Request{Cores: 4, Memory: 1 << 30, Walltime: time.Hour}
+	got := SimilarWaits(jobs, request, 2)
+	want := []time.Duration{100 * time.Second, 200 * time.Second, 300 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got waits %v, want %v", got, want)
+	}
+
+	if got := SimilarWaits(nil, request, 2); len(got) != 0 {
+		t.Errorf("got waits %v from no jobs", got)
+	}
+}
+
+func TestRequestsSQL(t *testing.T) {
+	q := NewQuery()
+	q.DBName = "test_sgelogs"
+	q.User = "*"
+	q.EndedAfter = time.Unix(1700000000, 0)
+	got, err := q.RequestsSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT " + requestColumns + " FROM test_sgelogs.accounting WHERE " +
+		"(end_time >= 1700000000 OR (end_time = 0 AND submission_time >= 1700000000)) AND " +
+		"pe_taskid = \"NONE\" AND start_time > 0"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	q.User = "not a user"
+	if _, err := q.RequestsSQL(); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("got %v for an invalid user, want ErrInvalidUsername", err)
+	}
+}
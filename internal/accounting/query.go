@@ -0,0 +1,304 @@
+package accounting
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/UCL-RITS/go-clustertools/internal/clusters"
+)
+
+// A Query describes a search of a cluster's accounting table.
+// Use NewQuery to get one with nothing set, because some of the "unset" values aren't zero.
+type Query struct {
+	Cluster     string    // Cluster to search, which is used to find the accounting DB.
+	DBName      string    // Accounting DB to search, if not using Cluster's.
+	User        string    // Owner to search for, wildcards okay. "" means $USER, "*" means anyone.
+	Users       []string  // Owners to search for instead of User, if set. No wildcards.
+	JobNumber   int       // Single job number to search for, or 0 for any.
+	MasterHost  string    // Master node to search for, wildcards okay. "" means any.
+	BackHours   int       // Hours back in time to search, or -1 for the default.
+	Last        int       // Only find this many of the most recent jobs, or -1 for no limit.
+	NoLimits    bool      // Ignore BackHours and Last.
+	EndPeriod   string    // Only find jobs that ended in this year-month, e.g. 2022-11.
+	EndedAfter  time.Time // Only find jobs that ended at or after this time, if set.
+	EndedBefore time.Time // Only find jobs that ended before this time, if set.
+	OmitFails   bool      // Leave out jobs with a non-zero SGE failure code.
+	Where       string    // Arbitrary extra WHERE clause. Dangerous: this goes straight into the SQL.
+	Elements    []string  // Elements that will be used, so expensive calculated ones can be skipped if not.
+	Filters     []Filter  // Checked against the rows found, so after Last is applied.
+}
+
+// DefaultBackHours is the time limit used if nothing else limits a search.
+const DefaultBackHours = 48
+
+func NewQuery() Query {
+	return Query{
+		BackHours: -1,
+		Last:      -1,
+	}
+}
+
+// WithDefaults fills in the values a Query would default to when searched with.
+// This is mostly useful for reporting what was actually searched for.
+func (q Query) WithDefaults() Query {
+	// Searching for a specific job is fast enough and specific enough that we should
+	//  ignore the time bounds unless explicitly specified
+	// We also disable the default time limit if a specific number of jobs is searched for
+	// Or if a specific period is being searched for
+	if (q.JobNumber <= 0) && (q.BackHours == -1) && (q.Last < 0) && (q.EndPeriod == "") &&
+		q.EndedAfter.IsZero() && q.EndedBefore.IsZero() {
+		q.BackHours = DefaultBackHours
+	}
+
+	// If no explicit user-to-search-for has been specified, and we're searching for a specific job ID,
+	//  assume any user is fine.
+	// (Otherwise we default to searching for the current user.)
+	if (q.JobNumber > 0) && (q.User == "") {
+		q.User = "*"
+	}
+	if (q.User == "") && (len(q.Users) == 0) {
+		q.User = os.Getenv("USER")
+	}
+
+	return q
+}
+
+// AccountingDB returns the name of the DB the query will search.
+func (q Query) AccountingDB() (string, error) {
+	if q.DBName != "" {
+		return q.DBName, nil
+	}
+	return clusters.GetClusterAccountingDBName(q.Cluster)
+}
+
+var (
+	ErrInvalidUsername = errors.New("invalid username")
+	ErrInvalidHostname = errors.New("invalid hostname")
+	ErrInvalidPeriod   = errors.New("invalid period provided, please use year-month, e.g. 2022-11")
+)
+
+func dropUnsafeChars(r rune) rune {
+	if (r >= 'a') && (r <= 'z') {
+		return r
+	}
+
+	if (r >= '0') && (r <= '9') {
+		return r
+	}
+
+	if r == '-' {
+		return r
+	}
+
+	// Some specials to allow SQL pattern matching
+	if (r == '*') || (r == '%') {
+		return '%'
+	}
+
+	if (r == '?') || (r == '_') {
+		return '_'
+	}
+
+	return -1
+}
+
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidUsername reports whether a name could be a cluster username, without wildcards.
+func IsValidUsername(user string) bool {
+	safeUser := strings.Map(dropUnsafeChars, user)
+	return (user != "") &&
+		(utf8.RuneCountInString(user) <= 7) &&
+		(safeUser == user) &&
+		!strings.ContainsAny(user, "%_")
+}
+
+// Builds a condition matching a column against a user-supplied value that may have wildcards.
+func matchCondition(column string, safeValue string) string {
+	// Note: _ is the single-character wildcard in SQL
+	if strings.ContainsAny(safeValue, "%_") {
+		return fmt.Sprintf("%s LIKE \"%s\" ", column, safeValue)
+	}
+	return fmt.Sprintf("%s = \"%s\" ", column, safeValue)
+}
+
+// Pulls the h_rt value out of the category string, for rows from before it was stored separately.
+// This only works when something comes after h_rt in the -l list, so it's only
+// used for repairs, where the rows it can't handle are left alone.
+const reqTimeFromCategorySQL = "substr(`accounting`.`category`,(locate('h_rt=',`accounting`.`category`) + 5),(locate(',',substr(`accounting`.`category`,(locate('h_rt=',`accounting`.`category`) + 5))) - 1))"
+
+// SelectColumns returns the columns selected from the accounting table: everything
+// stored, then the calculated values, in the order Job expects them.
+func (q Query) SelectColumns() string {
+	// There's a bunch of extra derived fields we want to add here.
+	querySelect := "*, " +
+		"DATE_FORMAT(FROM_UNIXTIME(submission_time), \"%Y-%m-%d %T\") AS fsubtime," +
+		"DATE_FORMAT(FROM_UNIXTIME(start_time), \"%Y-%m-%d %T\") AS fstime, " +
+		"DATE_FORMAT(FROM_UNIXTIME(end_time), \"%Y-%m-%d %T\") AS fetime, " +
+		// This line is really defensive because: start_time and end_time can both be zero for a failed job, and stupid unsigned arithmetic won't let the numbers be negative
+		"(greatest((`accounting`.`end_time` - least(`accounting`.`submission_time`,`accounting`.`start_time`)),1) / greatest((`accounting`.`end_time` - `accounting`.`start_time`),1)) AS slowdown, " +
+		"end_time - start_time AS ewalltime, " +
+		"CAST(start_time AS SIGNED INTEGER) - CAST(submission_time AS SIGNED INTEGER) as waittime, " +
+		"(ru_utime + ru_stime) / (GREATEST(slots,1) * (0.9+CAST(end_time AS SIGNED INTEGER) - CAST(start_time AS SIGNED INTEGER))) AS eff, " +
+		// avoid div/0 errors by adding 0.9 -- works out that jobs taking less than a second take 0.9 seconds
+		// also avoid div/0 by using greatest(slots,1): if the shepherd fails, the job has slots = 0
+
+		"`C::l::h_rt` AS `req_time` "
+		// ^-- warning: this field only started being generated in 2019 and is "null" (text -_-) for earlier rows
+
+	// req_time_calc used to be worked out here too, but it's parsed from the category
+	//  in Go now (see ParseCategory), which copes with h_rt coming last.
+
+	// These elements are expensive to retrieve, so we want to avoid calculating them if we don't need them
+	if q.usesElement("req_slowdown") {
+		querySelect += ", "
+		querySelect += "CASE "
+		querySelect += " WHEN `end_time` = 0 OR `start_time` = 0 OR `C::l::h_rt` = \"null\" THEN NULL "
+		querySelect += " ELSE (((`start_time` - `submission_time`) + (CAST(`C::l::h_rt` AS INTEGER))) / GREATEST(CAST(`C::l::h_rt` AS INTEGER), 1)) "
+		querySelect += "END "
+		querySelect += " AS `req_slowdown`"
+	} else {
+		querySelect += ", 0 as `req_slowdown`"
+	}
+
+	return querySelect
+}
+
+// Reports whether an element is shown or filtered on, so has to be selected.
+// Filters are checked in Go after the query, so they need the real values too.
+func (q Query) usesElement(element string) bool {
+	if stringInSlice(element, q.Elements) {
+		return true
+	}
+	for _, filter := range q.Filters {
+		if filter.Element == element {
+			return true
+		}
+	}
+	return false
+}
+
+// Conditions returns the WHERE conditions for the query, to be joined with AND.
+func (q Query) Conditions() ([]string, error) {
+	q = q.WithDefaults()
+
+	var conditions []string
+
+	if (q.BackHours > -1) && (!q.NoLimits) {
+		time_condition := " (" +
+			"        (end_time > (UNIX_TIMESTAMP(SUBDATE(NOW(), INTERVAL %d HOUR)))) OR " +
+			"      (start_time > (UNIX_TIMESTAMP(SUBDATE(NOW(), INTERVAL %d HOUR)))) OR " +
+			" (submission_time > (UNIX_TIMESTAMP(SUBDATE(NOW(), INTERVAL %d HOUR))))" +
+			") "
+		time_condition_composed := fmt.Sprintf(time_condition,
+			(uint64)(q.BackHours),
+			(uint64)(q.BackHours),
+			(uint64)(q.BackHours))
+		conditions = append(conditions, time_condition_composed)
+	}
+
+	if len(q.Users) > 0 {
+		for _, user := range q.Users {
+			if !IsValidUsername(user) {
+				return nil, ErrInvalidUsername
+			}
+		}
+		conditions = append(conditions, fmt.Sprintf("owner IN (\"%s\") ", strings.Join(q.Users, "\", \"")))
+	} else if q.User != "*" {
+		// Check for username validity
+		safeUser := strings.Map(dropUnsafeChars, q.User)
+		if (utf8.RuneCountInString(q.User) > 7) ||
+			(len(safeUser) < len(q.User)) {
+			return nil, ErrInvalidUsername
+		}
+		conditions = append(conditions, matchCondition("owner", safeUser))
+	}
+
+	if q.JobNumber > 0 {
+		conditions = append(conditions, fmt.Sprintf("job_number = %d ", q.JobNumber))
+	}
+
+	if q.MasterHost != "" {
+		safeHost := strings.Map(dropUnsafeChars, q.MasterHost)
+		if len(safeHost) < len(q.MasterHost) {
+			return nil, ErrInvalidHostname
+		}
+		conditions = append(conditions, matchCondition("hostname", safeHost))
+	}
+
+	if q.EndPeriod != "" {
+		endPeriodTime, err := time.Parse("2006-01", q.EndPeriod)
+		if err != nil {
+			return nil, ErrInvalidPeriod
+		}
+		endPeriodTimePlusMonth := endPeriodTime.AddDate(0, 1, 0)
+
+		endPeriodUnixTime := endPeriodTime.Unix()
+		endPeriodPMUnixTime := endPeriodTimePlusMonth.Unix()
+
+		conditions = append(conditions, fmt.Sprintf("end_time >= %d AND end_time < %d", endPeriodUnixTime, endPeriodPMUnixTime))
+	}
+
+	// Jobs that failed to start have an end_time of 0, so those go by when they
+	//  were submitted instead, or they'd never turn up in a time range.
+	if !q.EndedAfter.IsZero() {
+		after := q.EndedAfter.Unix()
+		conditions = append(conditions, fmt.Sprintf("(end_time >= %d OR (end_time = 0 AND submission_time >= %d))", after, after))
+	}
+	if !q.EndedBefore.IsZero() {
+		before := q.EndedBefore.Unix()
+		conditions = append(conditions, fmt.Sprintf("(end_time < %d AND (end_time > 0 OR submission_time < %d))", before, before))
+	}
+
+	if q.OmitFails {
+		conditions = append(conditions, "failed = 0")
+	}
+
+	if q.Where != "" {
+		// Danger
+		conditions = append(conditions, fmt.Sprintf(" %s ", q.Where))
+	}
+
+	return conditions, nil
+}
+
+// SQL returns the full SQL statement for the query.
+func (q Query) SQL() (string, error) {
+	q = q.WithDefaults()
+
+	queryFrom, err := q.AccountingDB()
+	if err != nil {
+		return "", err
+	}
+
+	conditions, err := q.Conditions()
+	if err != nil {
+		return "", err
+	}
+
+	// We don't need a where clause if there are no conditions
+	queryWhere := ""
+	if len(conditions) > 0 {
+		queryWhere = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s.accounting %s ORDER BY end_time", q.SelectColumns(), queryFrom, queryWhere)
+	if (q.Last >= 0) && (!q.NoLimits) {
+		// We need to flip the order to get only the last rows by end_time,
+		//   but then we want the order to be flipped *back* for display
+		query = fmt.Sprintf("SELECT * FROM (%s DESC LIMIT %d) AS t1 ORDER BY end_time", query, q.Last)
+	}
+
+	return query, nil
+}
@@ -0,0 +1,36 @@
+package accounting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectColumnsCalculated(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements []string
+		filters  []string
+		want     bool
+	}{
+		{"not used", nil, nil, false},
+		{"shown", []string{"owner", "req_slowdown"}, nil, true},
+		{"filtered on", nil, []string{"req_slowdown>2"}, true},
+		{"other filter", nil, []string{"slowdown>2"}, false},
+	}
+	for _, tc := range tests {
+		q := NewQuery()
+		q.Elements = tc.elements
+		for _, s := range tc.filters {
+			filter, err := ParseFilter(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q.Filters = append(q.Filters, filter)
+		}
+		columns := q.SelectColumns()
+		got := strings.Contains(columns, "CASE ")
+		if got != tc.want || strings.Contains(columns, "0 as `req_slowdown`") == tc.want {
+			t.Errorf("%s: got req_slowdown calculated %t, want %t:\n%s", tc.name, got, tc.want, columns)
+		}
+	}
+}
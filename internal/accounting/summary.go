@@ -0,0 +1,176 @@
+package accounting
+
+import (
+	"sort"
+	"time"
+)
+
+// A Summary describes a set of jobs as a whole.
+type Summary struct {
+	Jobs           int // Jobs, counting each array task separately.
+	Failed         int // Jobs that failed to start or exited non-zero.
+	CoreHours      float64
+	GPUHours       float64
+	MeanWait       time.Duration // Mean time from submission to start, for jobs that started.
+	MeanEfficiency float64       // Mean CPU time over core time, for jobs that ran.
+	TopFailing     []NameCount   // The job names that failed most often, most first.
+}
+
+// A NameCount is a job name and how many times it came up.
+type NameCount struct {
+	Name  string
+	Count int
+}
+
+// Succeeded returns the number of jobs that didn't fail.
+func (s Summary) Succeeded() int {
+	return s.Jobs - s.Failed
+}
+
+// SuccessRate returns the percentage of jobs that didn't fail.
+func (s Summary) SuccessRate() float64 {
+	if s.Jobs == 0 {
+		return 0
+	}
+	return 100 * float64(s.Succeeded()) / float64(s.Jobs)
+}
+
+// MeanEfficiencyPercent returns MeanEfficiency as a percentage.
+func (s Summary) MeanEfficiencyPercent() float64 {
+	return 100 * s.MeanEfficiency
+}
+
+type jobKey struct {
+	number int
+	task   int
+}
+
+// Returns the master row of each job (or task of an array job) in some rows,
+// in the order the jobs first come up. A parallel job can have a row per host,
+// but only the master row (pe_taskid NONE) has the job's slots and cost, so
+// counting any of the others would count the job twice. A job with no master
+// row in the rows is represented by its first.
+func masterRows(jobs []Job) []*Job {
+	masters := make(map[jobKey]*Job)
+	var keys []jobKey
+	for i := range jobs {
+		row := &jobs[i]
+		key := jobKey{row.JobNumber, row.TaskNumber}
+		master, ok := masters[key]
+		if !ok {
+			keys = append(keys, key)
+		}
+		if master == nil || row.PeTaskid == "NONE" {
+			masters[key] = row
+		}
+	}
+
+	rows := make([]*Job, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, masters[key])
+	}
+	return rows
+}
+
+// Summarise works out a Summary for a set of job rows, listing at most topFailing
+// job names in TopFailing.
+func Summarise(jobs []Job, topFailing int) Summary {
+	// Parallel jobs can have a row per host (see breakdown in jobhist), so
+	//  everything is worked out per job first. Only the master row (pe_taskid NONE)
+	//  has the job's slots and exit status, but all the rows have CPU time.
+	type jobTotals struct {
+		master  *Job
+		cpuTime float64
+	}
+	byJob := make(map[jobKey]*jobTotals)
+	var keys []jobKey
+	for i := range jobs {
+		row := &jobs[i]
+		key := jobKey{row.JobNumber, row.TaskNumber}
+		t, ok := byJob[key]
+		if !ok {
+			t = &jobTotals{}
+			byJob[key] = t
+			keys = append(keys, key)
+		}
+		if t.master == nil || row.PeTaskid == "NONE" {
+			t.master = row
+		}
+		t.cpuTime += row.RuUtime + row.RuStime
+	}
+
+	var s Summary
+	var totalWait time.Duration
+	var started int
+	var totalEfficiency float64
+	var ran int
+	failedNames := make(map[string]int)
+
+	for _, key := range keys {
+		t := byJob[key]
+		job := t.master
+		s.Jobs += 1
+		s.CoreHours += job.CoreHours()
+		s.GPUHours += job.GPUHours()
+
+		if job.Failed != 0 || job.ExitStatus != 0 {
+			s.Failed += 1
+			failedNames[job.JobName] += 1
+		}
+
+		if job.StartTime != 0 {
+			totalWait += time.Duration(job.Waittime) * time.Second
+			started += 1
+		}
+
+		if job.Ewalltime > 0 {
+			slots := job.Slots
+			if slots < 1 {
+				slots = 1
+			}
+			totalEfficiency += t.cpuTime / float64(slots*job.Ewalltime)
+			ran += 1
+		}
+	}
+
+	if started > 0 {
+		s.MeanWait = totalWait / time.Duration(started)
+	}
+	if ran > 0 {
+		s.MeanEfficiency = totalEfficiency / float64(ran)
+	}
+
+	for name, count := range failedNames {
+		s.TopFailing = append(s.TopFailing, NameCount{name, count})
+	}
+	sort.Slice(s.TopFailing, func(i, j int) bool {
+		if s.TopFailing[i].Count != s.TopFailing[j].Count {
+			return s.TopFailing[i].Count > s.TopFailing[j].Count
+		}
+		return s.TopFailing[i].Name < s.TopFailing[j].Name
+	})
+	if len(s.TopFailing) > topFailing {
+		s.TopFailing = s.TopFailing[:topFailing]
+	}
+
+	return s
+}
+
+// SummariseBy works out a Summary for each account, project or owner (see
+// UsageGroupings) in a set of job rows.
+func SummariseBy(jobs []Job, groupBy string, topFailing int) (map[string]Summary, error) {
+	groups := make(map[string][]Job)
+	for i := range jobs {
+		key, err := usageGroupKey(&jobs[i], groupBy)
+		if err != nil {
+			return nil, err
+		}
+		groups[key] = append(groups[key], jobs[i])
+	}
+
+	summaries := make(map[string]Summary, len(groups))
+	for key, groupJobs := range groups {
+		summaries[key] = Summarise(groupJobs, topFailing)
+	}
+	return summaries, nil
+}
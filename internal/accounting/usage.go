@@ -0,0 +1,148 @@
+package accounting
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CoreHours returns the core-hours a job row used.
+func (s *Job) CoreHours() float64 {
+	// Core-hours are worked out from cost where we have it, because that's what
+	//  the scheduler actually blocked out (it includes hyperthreads, for example).
+	// Some rows have a NULL cost, so we fall back to slots for those.
+	cores := float64(s.Slots)
+	if s.Cost.Valid {
+		cores = float64(s.Cost.Int64)
+	}
+	return cores * float64(s.Ewalltime) / 3600
+}
+
+// GPUHours returns the GPU-hours a job row used.
+func (s *Job) GPUHours() float64 {
+	return float64(s.C__l__gpu) * float64(s.Ewalltime) / 3600
+}
+
+// An Allocation from a budget file, in hours.
+type Allocation struct {
+	CoreHours float64
+	GPUHours  float64
+	HasGPU    bool
+}
+
+// A UsageTotal is the usage of a group of jobs sharing an account, project or owner.
+type UsageTotal struct {
+	Name      string
+	Jobs      int
+	CoreHours float64
+	GPUHours  float64
+}
+
+// UsageGroupings are the fields SumUsage can group jobs by.
+var UsageGroupings = []string{"account", "project", "owner"}
+
+// ReadBudgetFile reads allocations from a budget file.
+//
+// Budget files are plain text, one allocation per line:
+//
+//	<name> <core-hours> [<gpu-hours>]
+//
+// where name is an account, project or user depending on what usage is being
+// grouped by. Blank lines and anything after a # are ignored.
+func ReadBudgetFile(filename string) (map[string]Allocation, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open budget file: %w", err)
+	}
+	defer file.Close()
+
+	allocations := make(map[string]Allocation)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber += 1
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected <name> <core-hours> [<gpu-hours>]", filename, lineNumber)
+		}
+
+		var a Allocation
+		a.CoreHours, err = strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid core-hours value: %w", filename, lineNumber, err)
+		}
+		if len(fields) == 3 {
+			a.GPUHours, err = strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid gpu-hours value: %w", filename, lineNumber, err)
+			}
+			a.HasGPU = true
+		}
+		allocations[fields[0]] = a
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read budget file: %w", err)
+	}
+	return allocations, nil
+}
+
+func usageGroupKey(s *Job, groupBy string) (string, error) {
+	switch groupBy {
+	case "account":
+		return s.Account, nil
+	case "project":
+		return s.Project, nil
+	case "owner":
+		return s.Owner, nil
+	default:
+		return "", fmt.Errorf("cannot group usage by %s", groupBy)
+	}
+}
+
+// SumUsage totals the usage of jobs grouped by one of UsageGroupings, sorted by name.
+// Jobs with a row per host are counted once, from their master row, as in Summarise.
+func SumUsage(jobs []Job, groupBy string) ([]UsageTotal, error) {
+	totals := make(map[string]*UsageTotal)
+	for _, job := range masterRows(jobs) {
+		key, err := usageGroupKey(job, groupBy)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := totals[key]
+		if !ok {
+			t = &UsageTotal{Name: key}
+			totals[key] = t
+		}
+		t.Jobs += 1
+		t.CoreHours += job.CoreHours()
+		t.GPUHours += job.GPUHours()
+	}
+
+	sortedTotals := make([]UsageTotal, 0, len(totals))
+	for _, t := range totals {
+		sortedTotals = append(sortedTotals, *t)
+	}
+	sort.Slice(sortedTotals, func(i, j int) bool {
+		return sortedTotals[i].Name < sortedTotals[j].Name
+	})
+	return sortedTotals, nil
+}
+
+// PercentOf returns used as a percentage of allocated.
+func PercentOf(used float64, allocated float64) float64 {
+	if allocated <= 0 {
+		// Anything used out of nothing is over budget.
+		if used > 0 {
+			return 100
+		}
+		return 0
+	}
+	return 100 * used / allocated
+}
@@ -0,0 +1,38 @@
+package accounting
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestSumUsage(t *testing.T) {
+	cost := func(n int64) sql.NullInt64 { return sql.NullInt64{Int64: n, Valid: true} }
+	jobs := []Job{
+		// A parallel job, with a cost on a slave row too, and GPUs from its category on every row.
+		{Owner: "bob", JobNumber: 1, PeTaskid: "1.node-b01", Ewalltime: 3600, Cost: cost(4), C__l__gpu: 1},
+		{Owner: "bob", JobNumber: 1, PeTaskid: "NONE", Ewalltime: 3600, Slots: 16, Cost: cost(16), C__l__gpu: 1},
+		{Owner: "bob", JobNumber: 1, PeTaskid: "1.node-b02", Ewalltime: 3600, C__l__gpu: 1},
+		// Tasks of an array job are separate jobs.
+		{Owner: "alice", JobNumber: 2, TaskNumber: 1, PeTaskid: "NONE", Ewalltime: 1800, Slots: 1},
+		{Owner: "alice", JobNumber: 2, TaskNumber: 2, PeTaskid: "NONE", Ewalltime: 1800, Slots: 1},
+		// Just a slave row, e.g. if the master row was filtered out.
+		{Owner: "alice", JobNumber: 3, PeTaskid: "1.node-a01", Ewalltime: 3600, Cost: cost(2)},
+	}
+
+	got, err := SumUsage(jobs, "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []UsageTotal{
+		{Name: "alice", Jobs: 3, CoreHours: 3},
+		{Name: "bob", Jobs: 1, CoreHours: 16, GPUHours: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := SumUsage(jobs, "colour"); err == nil {
+		t.Error("got no error grouping by something that isn't a grouping")
+	}
+}
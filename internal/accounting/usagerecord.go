@@ -0,0 +1,173 @@
+package accounting
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// This is a subset of the OGF Usage Record 2.0 format (GFD.204), covering the
+//  job usage record fields we actually have something to put in.
+// The JSON export uses the same structure, so the two can be checked against each other.
+
+// UsageRecords is the root element of a usage record document.
+type UsageRecords struct {
+	XMLName xml.Name         `xml:"http://schema.ogf.org/urf/2013/04/urf UsageRecords" json:"-"`
+	Records []JobUsageRecord `xml:"JobUsageRecord" json:"JobUsageRecords"`
+}
+
+// A JobUsageRecord is the usage record for a single job row.
+type JobUsageRecord struct {
+	RecordIdentity URRecordIdentity `xml:"RecordIdentity" json:"RecordIdentity"`
+	JobIdentity    URJobIdentity    `xml:"JobIdentity" json:"JobIdentity"`
+	UserIdentity   URUserIdentity   `xml:"UserIdentity" json:"UserIdentity"`
+	JobName        string           `xml:"JobName,omitempty" json:"JobName,omitempty"`
+	Status         string           `xml:"Status" json:"Status"`
+	ExitStatus     int              `xml:"ExitStatus" json:"ExitStatus"`
+	WallDuration   string           `xml:"WallDuration" json:"WallDuration"`
+	CpuDuration    URCpuDuration    `xml:"CpuDuration" json:"CpuDuration"`
+	StartTime      string           `xml:"StartTime,omitempty" json:"StartTime,omitempty"`
+	EndTime        string           `xml:"EndTime,omitempty" json:"EndTime,omitempty"`
+	MachineName    string           `xml:"MachineName" json:"MachineName"`
+	Host           string           `xml:"Host,omitempty" json:"Host,omitempty"`
+	Queue          string           `xml:"Queue,omitempty" json:"Queue,omitempty"`
+	ProjectName    string           `xml:"ProjectName,omitempty" json:"ProjectName,omitempty"`
+	Memory         URMemory         `xml:"Memory" json:"Memory"`
+	Processors     int              `xml:"Processors" json:"Processors"`
+	Resources      []URResource     `xml:"Resource,omitempty" json:"Resources,omitempty"`
+}
+
+type URRecordIdentity struct {
+	RecordId   string `xml:"recordId,attr" json:"recordId"`
+	CreateTime string `xml:"createTime,attr" json:"createTime"`
+}
+
+type URJobIdentity struct {
+	LocalJobId string `xml:"LocalJobId" json:"LocalJobId"`
+}
+
+type URUserIdentity struct {
+	LocalUserId string `xml:"LocalUserId" json:"LocalUserId"`
+	LocalGroup  string `xml:"LocalGroup,omitempty" json:"LocalGroup,omitempty"`
+}
+
+type URCpuDuration struct {
+	UsageType string `xml:"usageType,attr" json:"usageType"`
+	Duration  string `xml:",chardata" json:"Duration"`
+}
+
+type URMemory struct {
+	Metric      string `xml:"metric,attr" json:"metric"`
+	Type        string `xml:"type,attr" json:"type"`
+	StorageUnit string `xml:"storageUnit,attr" json:"storageUnit"`
+	Value       int64  `xml:",chardata" json:"Value"`
+}
+
+// Resource is the UR extension point for things the standard doesn't name.
+type URResource struct {
+	Description string `xml:"description,attr" json:"description"`
+	Value       string `xml:",chardata" json:"Value"`
+}
+
+// UR durations are xsd:duration values.
+// We only have whole seconds for walltime, but cpu time is fractional.
+func urDuration(seconds float64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	return "PT" + strconv.FormatFloat(seconds, 'f', -1, 64) + "S"
+}
+
+// Start and end times are 0 for jobs that failed to start, and the UR fields
+// are optional, so we leave them out rather than claim 1970.
+func urTime(unixTime int) string {
+	if unixTime == 0 {
+		return ""
+	}
+	return time.Unix(int64(unixTime), 0).UTC().Format(time.RFC3339)
+}
+
+func makeJobUsageRecord(row *Job, clusterName string, createTime string) JobUsageRecord {
+	localJobId := strconv.Itoa(row.JobNumber)
+	if row.TaskNumber != 0 {
+		localJobId = fmt.Sprintf("%d.%d", row.JobNumber, row.TaskNumber)
+	}
+
+	status := "completed"
+	if row.Failed != 0 {
+		status = "failed"
+	}
+
+	record := JobUsageRecord{
+		RecordIdentity: URRecordIdentity{
+			RecordId:   fmt.Sprintf("%s:%s:%d", clusterName, localJobId, row.Id),
+			CreateTime: createTime,
+		},
+		JobIdentity:  URJobIdentity{LocalJobId: localJobId},
+		UserIdentity: URUserIdentity{LocalUserId: row.Owner, LocalGroup: row.Ugroup},
+		JobName:      row.JobName,
+		Status:       status,
+		ExitStatus:   row.ExitStatus,
+		WallDuration: urDuration(float64(row.Ewalltime)),
+		CpuDuration:  URCpuDuration{UsageType: "all", Duration: urDuration(row.Cpu)},
+		StartTime:    urTime(row.StartTime),
+		EndTime:      urTime(row.EndTime),
+		MachineName:  clusterName,
+		Host:         Unqdn(row.Hostname),
+		Queue:        row.Qname,
+		ProjectName:  row.Project,
+		Memory:       URMemory{Metric: "max", Type: "virtual", StorageUnit: "B", Value: int64(row.Maxvmem)},
+		Processors:   row.Slots,
+	}
+
+	if row.Department != "" {
+		record.Resources = append(record.Resources, URResource{Description: "department", Value: row.Department})
+	}
+	if row.Account != "" {
+		record.Resources = append(record.Resources, URResource{Description: "account", Value: row.Account})
+	}
+
+	return record
+}
+
+// MakeUsageRecords converts job rows from a cluster into usage records.
+func MakeUsageRecords(jobs []Job, clusterName string) *UsageRecords {
+	createTime := time.Now().UTC().Format(time.RFC3339)
+	records := &UsageRecords{Records: make([]JobUsageRecord, 0, len(jobs))}
+	for i := range jobs {
+		records.Records = append(records.Records, makeJobUsageRecord(&jobs[i], clusterName, createTime))
+	}
+	return records
+}
+
+// UsageRecordFormats are the formats WriteUsageRecords can write.
+var UsageRecordFormats = []string{"ur-xml", "ur-json"}
+
+// WriteUsageRecords writes job rows from a cluster as usage records, in one of UsageRecordFormats.
+func WriteUsageRecords(w io.Writer, jobs []Job, clusterName string, format string) error {
+	records := MakeUsageRecords(jobs, clusterName)
+
+	switch format {
+	case "ur-xml":
+		io.WriteString(w, xml.Header)
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(records); err != nil {
+			return fmt.Errorf("could not encode usage records as XML: %w", err)
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	case "ur-json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(records); err != nil {
+			return fmt.Errorf("could not encode usage records as JSON: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+}
@@ -1,9 +1,8 @@
 package adhelper
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
+	"strings"
 
 	"github.com/go-ldap/ldap/v3"
 )
@@ -13,8 +12,24 @@ type LdapOpts struct {
 	Username  string `yaml:"bind_username"`
 	Password  string `yaml:"bind_password"`
 	BaseDN    string `yaml:"base_dn"`
-	Insecure  bool   `yaml:"allow_insecure"`
+	Insecure  *bool  `yaml:"allow_insecure"`
 	CertFile  string `yaml:"cert_file"`
+
+	// More on how the server's certificate is checked.
+	TLS TLSOpts `yaml:"tls"`
+
+	// File to read Password from, if it's not given. (See LoadConfig.)
+	PasswordFile string `yaml:"bind_password_file"`
+
+	// How to bind: simple, gssapi or external. (Default: simple)
+	BindMethod string       `yaml:"bind_method"`
+	Kerberos   KerberosOpts `yaml:"kerberos"`
+	// Certificate and key to give the server, for external binds.
+	ClientCertFile string `yaml:"client_cert_file"`
+	ClientKeyFile  string `yaml:"client_key_file"`
+
+	// Defaults for searches, which can also be set per search.
+	Search SearchOptions `yaml:"search"`
 }
 
 //var exampleLdapOpts = LdapOpts{
@@ -22,69 +37,36 @@ type LdapOpts struct {
 //	Username:  "AD\\user",
 //	Password:  "hunter2",
 //	BaseDN:    "DC=ad,DC=example,DC=com",
-//	Insecure:  true,
+//	Insecure:  Bool(true),
 //  CertFile:  ""
 //}
 
-func RunADSearch(opts *LdapOpts, searchExpression string, returnFields []string) (*ldap.SearchResult, error) {
-	var rootCertPool *x509.CertPool
-	var err error
-	if opts.CertFile != "" {
-		rootCertPool, err = certPoolFromFile(opts.CertFile)
-		if err != nil {
-			return nil, fmt.Errorf("could not create cert pool from file: %w", err)
-		}
+// Dials the LDAP server, without binding.
+func dial(opts *LdapOpts) (*ldap.Conn, error) {
+	config, err := tlsConfig(opts)
+	if err != nil {
+		return nil, err
 	}
 
-	dialOpts := ldap.DialWithTLSConfig(
-		&tls.Config{
-			InsecureSkipVerify: opts.Insecure,
-			RootCAs:            rootCertPool,
-		},
-	)
-	conn, err := ldap.DialURL(opts.ServerUrl, dialOpts)
+	conn, err := ldap.DialURL(opts.ServerUrl, ldap.DialWithTLSConfig(config))
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to LDAP server: %w", err)
 	}
-	defer conn.Close()
-	err = conn.Bind(opts.Username, opts.Password)
-	if err != nil {
-		return nil, fmt.Errorf("could not bind on LDAP server: %w", err)
-	}
-
-	// Prototype, just for reference
-	// func NewSearchRequest(
-	//    BaseDN string,
-	//    Scope, DerefAliases, SizeLimit, TimeLimit int,
-	//    TypesOnly bool,
-	//    Filter string,
-	//    Attributes []string,
-	//    Controls []Control,
-	// ) *SearchRequest
-
-	// This EscapeFilter prevents wildcard searches by escaping the asterisk.
-	// We do want to be able to run wildcard searches.
-	// TODO: make your own filter I guess
-	// searchExpression = ldap.EscapeFilter(searchExpression))
-	// In the meanwhile, this is... a little unsafe
-
-	searchReq := ldap.NewSearchRequest(
-		opts.BaseDN,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		searchExpression,
-		returnFields, //empty attributes means all of them
-		[]ldap.Control{},
-	)
-
-	sr, err := conn.SearchWithPaging(searchReq, 1)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search LDAP: %w", err)
+	if isTrue(opts.TLS.StartTLS) && !strings.HasPrefix(strings.ToLower(opts.ServerUrl), "ldaps:") {
+		err = conn.StartTLS(config)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not start TLS on LDAP connection: %w", err)
+		}
 	}
-	return sr, nil
-
-	// E.g. debug print
-	//for _, entry := range sr.Entries {
-	//    fmt.Printf("%s: %v\n", entry.DN, entry.GetAttributeValue("cn"))
-	//}
+	return conn, nil
+}
 
+// RunADSearch binds to the server and returns the entries under the base DN
+// matching a filter, with the given attributes (or all of them, if none are given).
+// It's for one-off searches: use a Client to make more than one.
+func RunADSearch(opts *LdapOpts, filter Filter, returnFields []string) (*ldap.SearchResult, error) {
+	client := NewClient(opts)
+	defer client.Close()
+	return client.Search(filter, returnFields)
 }
@@ -1,63 +1,47 @@
 package adhelper
 
-import (
-	"errors"
-	"fmt"
-	"regexp"
-)
-
-// Returns a slice containing the usernames of members of an AD group.
+// Returns a slice containing the usernames of direct members of an AD group.
 func GetADGroupMembers(ldapOpts *LdapOpts, groupname string) ([]string, error) {
+	client := NewClient(ldapOpts)
+	defer client.Close()
+	return client.GetADGroupMembers(groupname)
+}
 
-	expression := fmt.Sprintf("(&(objectCategory=Group)(cn=%s))", groupname)
-	results, err := RunADSearch(ldapOpts, expression, []string{"member"})
+// GetADGroupMembers returns the names of the direct members of an AD group.
+// Groups nested in it are included by name, rather than their members: use
+// GetADGroupUsers or GetADGroupMembersNested to follow them.
+func (c *Client) GetADGroupMembers(groupname string) ([]string, error) {
+	filter := And(Eq("objectCategory", "Group"), Eq("cn", groupname))
+	results, err := c.Search(filter, []string{"member"})
 	if err != nil {
 		return nil, err
 	}
 
-	members := []string{}
+	memberDNs := []string{}
 	for _, entry := range results.Entries {
-		for _, memberCN := range entry.GetAttributeValues("member") {
-			membername, err := deCN(memberCN)
-			if err != nil {
-				return nil, err
-			}
-			members = append(members, membername)
-		}
+		memberDNs = append(memberDNs, entry.GetAttributeValues("member")...)
 	}
-
-	return members, nil
+	return c.ResolveUsernames(memberDNs)
 }
 
+// Returns a slice containing the usernames of people in a department.
 func GetADDeptMembers(ldapOpts *LdapOpts, deptName string) ([]string, error) {
-	expression := fmt.Sprintf("(department=%s)", deptName)
-	results, err := RunADSearch(ldapOpts, expression, []string{"cn"})
+	client := NewClient(ldapOpts)
+	defer client.Close()
+	return client.GetADDeptMembers(deptName)
+}
+
+// GetADDeptMembers returns the usernames of people in a department.
+func (c *Client) GetADDeptMembers(deptName string) ([]string, error) {
+	results, err := c.Search(Eq("department", deptName), usernameAttributes)
 	if err != nil {
 		return nil, err
 	}
 
 	members := []string{}
 	for _, entry := range results.Entries {
-		for _, memberCN := range entry.GetAttributeValues("cn") {
-			membername, err := deCN(memberCN)
-			if err != nil {
-				return nil, err
-			}
-			members = append(members, membername)
-		}
+		members = append(members, entryUsername(entry))
 	}
 
 	return members, nil
 }
-
-// In theory this should match any AD Canonical Name (CN) with a submatch of just the non-canonical name.
-var CNre = regexp.MustCompile(`[Cc][Nn]=([A-Za-z0-9]+)(?:,[Oo][Uu]=[A-Za-z0-9-]+)*(?:,[Dd][Cc]=[A-Za-z0-9-]+)*`)
-
-// Gets just the actual name from a full CN returned from AD.
-func deCN(s string) (string, error) {
-	matches := CNre.FindStringSubmatch(s)
-	if matches == nil {
-		return "", errors.New("attempted to deCN something that didn't seem to be a CN")
-	}
-	return matches[1], nil
-}
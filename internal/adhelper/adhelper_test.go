@@ -0,0 +1,453 @@
+package adhelper
+
+// These run against the in-process server from adtest, serving the entries
+//  in testdata/ad.ldif.
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper/adtest"
+)
+
+const testBaseDN = adtest.BaseDN
+
+// Starts a test server with the entries in testdata/<name>.ldif.
+func newTestServer(t *testing.T, name string) *adtest.Server {
+	t.Helper()
+	return adtest.NewServer(t, filepath.Join("testdata", name+".ldif"))
+}
+
+// Options for connecting to a test server as its bind user.
+func testOpts(s *adtest.Server) *LdapOpts {
+	return &LdapOpts{
+		ServerUrl: s.URL,
+		Username:  adtest.BindUser,
+		Password:  adtest.BindPassword,
+		BaseDN:    adtest.BaseDN,
+	}
+}
+
+func entryDNs(t *testing.T, opts *LdapOpts, filter Filter, so SearchOptions) ([]string, error) {
+	t.Helper()
+	client := NewClient(opts)
+	defer client.Close()
+	result, err := client.SearchWithOptions(filter, []string{"cn"}, so)
+	dns := []string{}
+	if result != nil {
+		for _, entry := range result.Entries {
+			dns = append(dns, entry.DN)
+		}
+	}
+	return dns, err
+}
+
+func TestRunADSearch(t *testing.T) {
+	s := newTestServer(t, "ad")
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		want    []string
+		wantErr error
+	}{
+		{"exact cn", Eq("cn", "ccaaali"), []string{"CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com"}, nil},
+		{"case-insensitive", Eq("CN", "CCAAALI"), []string{"CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com"}, nil},
+		{"escaped comma in the name", Eq("cn", "Smith, Dave"), []string{`CN=Smith\, Dave,OU=Users,DC=ad,DC=example,DC=com`}, nil},
+		{"wildcard", Wildcard("cn", "ccaa*"), []string{
+			"CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com",
+			"CN=ccaabob,OU=Users,DC=ad,DC=example,DC=com",
+			"CN=ccaacar,OU=Users,DC=ad,DC=example,DC=com",
+		}, nil},
+		{"wildcard in the middle", Wildcard("mail", "*@example.*"), []string{
+			"CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com",
+			"CN=ccaabob,OU=Users,DC=ad,DC=example,DC=com",
+			"CN=ccaacar,OU=Users,DC=ad,DC=example,DC=com",
+			`CN=Smith\, Dave,OU=Users,DC=ad,DC=example,DC=com`,
+			"CN=Erin O'Neill-Jones,OU=Users,DC=ad,DC=example,DC=com",
+		}, nil},
+		// The * and brackets are part of the value, not the filter.
+		{"special characters escaped", Eq("department", "Physics (Astro*)"), []string{"CN=Erin O'Neill-Jones,OU=Users,DC=ad,DC=example,DC=com"}, nil},
+		{"injection attempt", Eq("cn", "*)(objectClass=*"), []string{}, nil},
+		{"and", And(Eq("department", "Physics"), Present("mail")), []string{
+			"CN=ccaacar,OU=Users,DC=ad,DC=example,DC=com",
+			`CN=Smith\, Dave,OU=Users,DC=ad,DC=example,DC=com`,
+		}, nil},
+		{"not", And(Eq("objectCategory", "Group"), Not(Wildcard("cn", "*-*"))), []string{"CN=everyone,OU=Groups,DC=ad,DC=example,DC=com"}, nil},
+		{"raw", Raw("(&(objectCategory=Person)(displayName=Bob*))"), []string{"CN=ccaabob,OU=Users,DC=ad,DC=example,DC=com"}, nil},
+		{"nothing found", Eq("cn", "nobody"), []string{}, nil},
+		{"invalid attribute", Eq("cn)(x", "y"), []string{}, ErrInvalidAttribute},
+		{"empty and", And(), []string{}, ErrEmptyFilter},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := RunADSearch(testOpts(s), tc.filter, []string{"cn"})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			got := []string{}
+			if result != nil {
+				for _, entry := range result.Entries {
+					got = append(got, entry.DN)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSearchOptions(t *testing.T) {
+	s := newTestServer(t, "ad")
+	allUsers := []string{
+		"CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com",
+		"CN=ccaabob,OU=Users,DC=ad,DC=example,DC=com",
+		"CN=ccaacar,OU=Users,DC=ad,DC=example,DC=com",
+		`CN=Smith\, Dave,OU=Users,DC=ad,DC=example,DC=com`,
+		"CN=Erin O'Neill-Jones,OU=Users,DC=ad,DC=example,DC=com",
+	}
+
+	tests := []struct {
+		name      string
+		filter    Filter
+		so        SearchOptions
+		want      []string
+		wantLimit bool
+	}{
+		{"one page", Eq("objectCategory", "Person"), SearchOptions{}, allUsers, false},
+		{"several pages", Eq("objectCategory", "Person"), SearchOptions{PageSize: 2}, allUsers, false},
+		{"size limit", Eq("objectCategory", "Person"), SearchOptions{SizeLimit: 2}, allUsers[:2], true},
+		{"size limit across pages", Eq("objectCategory", "Person"), SearchOptions{SizeLimit: 3, PageSize: 2}, allUsers[:3], true},
+		{"size limit not reached", Eq("objectCategory", "Person"), SearchOptions{SizeLimit: 5}, allUsers, false},
+		{"base scope", Present("objectClass"), SearchOptions{BaseDN: "OU=Users," + testBaseDN, Scope: "base"}, []string{"OU=Users,DC=ad,DC=example,DC=com"}, false},
+		{"one level scope", Present("objectClass"), SearchOptions{BaseDN: testBaseDN, Scope: "one"}, []string{
+			"OU=Users,DC=ad,DC=example,DC=com",
+			"OU=Groups,DC=ad,DC=example,DC=com",
+		}, false},
+		{"one level scope with escaped commas", Present("objectClass"), SearchOptions{BaseDN: "OU=Users," + testBaseDN, Scope: "one"}, allUsers, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := entryDNs(t, testOpts(s), tc.filter, tc.so)
+			if IsLimitExceeded(err) != tc.wantLimit {
+				t.Errorf("got error %v, want limit exceeded: %v", err, tc.wantLimit)
+			} else if err != nil && !tc.wantLimit {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	for _, so := range []SearchOptions{{Scope: "all"}, {Deref: "sometimes"}, {PageSize: -1}} {
+		if _, err := entryDNs(t, testOpts(s), Present("cn"), so); err == nil {
+			t.Errorf("options %+v: expected an error", so)
+		}
+	}
+}
+
+func TestEntryIterator(t *testing.T) {
+	s := newTestServer(t, "ad")
+	client := NewClient(testOpts(s))
+	defer client.Close()
+
+	// Stopping part way through has to abandon the search, and leave the
+	//  connection fit to use again.
+	it := client.SearchIter(Present("objectClass"), nil, SearchOptions{PageSize: 2})
+	if !it.Next() || !it.Next() || !it.Next() {
+		t.Fatalf("expected at least three entries: %v", it.Err())
+	}
+	it.Close()
+	if it.Next() {
+		t.Errorf("got an entry after closing the iterator")
+	}
+
+	result, err := client.Search(Eq("cn", "rc-admins"), nil)
+	if err != nil || len(result.Entries) != 1 {
+		t.Fatalf("search after abandoning another failed: %d entries, error %v", len(result.Entries), err)
+	}
+	if binds, _ := s.Counts(); binds != 1 {
+		t.Errorf("got %d binds, want the one connection reused", binds)
+	}
+}
+
+func TestClientReconnects(t *testing.T) {
+	s := newTestServer(t, "ad")
+	client := NewClient(testOpts(s))
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Search(Eq("cn", "ccaaali"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if binds, searches := s.Counts(); binds != 1 || searches != 3 {
+		t.Errorf("got %d binds and %d searches, want 1 and 3", binds, searches)
+	}
+
+	// As when AD drops a connection that's been idle too long.
+	s.DropConnections()
+	if _, err := client.Search(Eq("cn", "ccaaali"), nil); err != nil {
+		t.Fatalf("search after the connection was dropped failed: %s", err)
+	}
+	if binds, _ := s.Counts(); binds != 2 {
+		t.Errorf("got %d binds, want 2", binds)
+	}
+
+	client.Close()
+	if _, err := client.Search(Eq("cn", "ccaaali"), nil); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("got error %v from a closed client, want %v", err, ErrClientClosed)
+	}
+}
+
+func TestBindFailure(t *testing.T) {
+	s := newTestServer(t, "ad")
+	opts := testOpts(s)
+	opts.Password = "wrong"
+	if _, err := RunADSearch(opts, Eq("cn", "ccaaali"), nil); err == nil {
+		t.Errorf("search with a wrong bind password succeeded")
+	}
+
+	opts.BindMethod = "telepathy"
+	if _, err := RunADSearch(opts, Eq("cn", "ccaaali"), nil); !errors.Is(err, ErrInvalidBindMethod) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidBindMethod)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestServer(t, "ad")
+
+	tests := []struct {
+		user     string
+		password string
+		ok       bool
+	}{
+		{`AD\ccaaali`, "alicepw", true},
+		{"CN=ccaabob,OU=Users,DC=ad,DC=example,DC=com", "bobpw", true},
+		{`AD\ccaaali`, "bobpw", false},
+		{`AD\ccaaali`, "", false},
+		{`AD\nobody`, "alicepw", false},
+	}
+
+	for _, tc := range tests {
+		err := Authenticate(testOpts(s), tc.user, tc.password)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s with password %q: got error %v, want success %v", tc.user, tc.password, err, tc.ok)
+		}
+	}
+}
+
+func TestGetADGroupMembers(t *testing.T) {
+	s := newTestServer(t, "ad")
+
+	tests := []struct {
+		group string
+		want  []string
+	}{
+		{"rc-admins", []string{"ccaaali", "rc-support"}},
+		// Members with commas and spaces in their CNs get their usernames.
+		{"rc-support", []string{"ccaabob", "ccaadsm"}},
+		{"physics-users", []string{"ccaacar", "ccaadsm", "ccaaeoj"}},
+		{"everyone", []string{"rc-admins", "rc-support", "physics-users"}},
+		{"nonexistent", []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.group, func(t *testing.T) {
+			got, err := GetADGroupMembers(testOpts(s), tc.group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetADGroupMembersNested(t *testing.T) {
+	s := newTestServer(t, "ad")
+
+	type member struct {
+		username string
+		via      []string
+	}
+	tests := []struct {
+		group   string
+		want    []member
+		wantErr error
+	}{
+		{"rc-admins", []member{
+			{"ccaaali", []string{"rc-admins"}},
+			{"ccaabob", []string{"rc-admins", "rc-support"}},
+			{"ccaadsm", []string{"rc-admins", "rc-support"}},
+		}, nil},
+		// Dave is in two of the nested groups, but is only listed once.
+		{"everyone", []member{
+			{"ccaaali", []string{"everyone", "rc-admins"}},
+			{"ccaabob", []string{"everyone", "rc-support"}},
+			{"ccaadsm", []string{"everyone", "rc-support"}},
+			{"ccaacar", []string{"everyone", "physics-users"}},
+			{"ccaaeoj", []string{"everyone", "physics-users"}},
+		}, nil},
+		{"loop-a", []member{
+			{"ccaacar", []string{"loop-a"}},
+			{"ccaaeoj", []string{"loop-a", "loop-b"}},
+		}, nil},
+		{"nonexistent", nil, ErrGroupNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.group, func(t *testing.T) {
+			members, err := GetADGroupMembersNested(testOpts(s), tc.group)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			var got []member
+			for _, m := range members {
+				got = append(got, member{m.Username, m.Via})
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetADDeptMembers(t *testing.T) {
+	s := newTestServer(t, "ad")
+
+	tests := []struct {
+		dept string
+		want []string
+	}{
+		{"Research Computing", []string{"ccaaali", "ccaabob"}},
+		{"Physics", []string{"ccaacar", "ccaadsm"}},
+		{"physics", []string{"ccaacar", "ccaadsm"}},
+		// Not a wildcard, so it doesn't match Physics too.
+		{"Physics (Astro*)", []string{"ccaaeoj"}},
+		{"Chemistry", []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.dept, func(t *testing.T) {
+			got, err := GetADDeptMembers(testOpts(s), tc.dept)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveUsernames(t *testing.T) {
+	s := newTestServer(t, "ad")
+	client := NewClient(testOpts(s))
+	defer client.Close()
+
+	dns := []string{
+		// Written differently from how the server has it.
+		`cn=smith\2c dave,ou=users,dc=ad,dc=example,dc=com`,
+		// Outside the tree, so it can't be looked up.
+		"CN=Frank Foreign,OU=Elsewhere,DC=other,DC=com",
+		"CN=ccaaali,OU=Users,DC=ad,DC=example,DC=com",
+	}
+	got, err := client.ResolveUsernames(dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ccaadsm", "Frank Foreign", "ccaaali"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGetUserGroups(t *testing.T) {
+	s := newTestServer(t, "ad")
+
+	groupNames := func(groups []UserGroup, direct bool) []string {
+		names := []string{}
+		for _, g := range groups {
+			if g.Direct == direct {
+				names = append(names, g.Name)
+			}
+		}
+		return names
+	}
+
+	tests := []struct {
+		user       string
+		nested     bool
+		wantDirect []string
+		wantNested []string
+	}{
+		{"ccaabob", false, []string{"rc-support"}, []string{}},
+		{"ccaabob", true, []string{"rc-support"}, []string{"everyone", "rc-admins"}},
+		{"ccaadsm", true, []string{"physics-users", "rc-support"}, []string{"everyone", "rc-admins"}},
+		{"ccaacar", true, []string{"loop-a", "physics-users"}, []string{"everyone", "loop-b"}},
+	}
+
+	for _, tc := range tests {
+		groups, err := GetUserGroups(testOpts(s), tc.user, tc.nested)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := groupNames(groups, true); !reflect.DeepEqual(got, tc.wantDirect) {
+			t.Errorf("%s: got direct groups %q, want %q", tc.user, got, tc.wantDirect)
+		}
+		if got := groupNames(groups, false); !reflect.DeepEqual(got, tc.wantNested) {
+			t.Errorf("%s: got nested groups %q, want %q", tc.user, got, tc.wantNested)
+		}
+	}
+
+	if _, err := GetUserGroups(testOpts(s), "nobody", true); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	s := newTestServer(t, "ad")
+	client := NewClient(testOpts(s))
+	defer client.Close()
+	filename := filepath.Join(t.TempDir(), "snapshots.yaml")
+
+	snapshots, err := LoadSnapshots(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := client.SnapshotGroup("rc-admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := snapshots.Update("rc-admins", current)
+	if !change.Since.IsZero() || !reflect.DeepEqual(change.Added, []string{"ccaaali", "ccaabob", "ccaadsm"}) {
+		t.Errorf("unexpected first change: %+v", change)
+	}
+	if err := snapshots.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err = LoadSnapshots(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCredentialFile(filename); err != nil {
+		t.Errorf("snapshot file should only be readable by its owner: %s", err)
+	}
+	current.Members = []string{"ccaaali", "ccaaeoj"}
+	change = snapshots.Update("rc-admins", current)
+	if !reflect.DeepEqual(change.Added, []string{"ccaaeoj"}) || !reflect.DeepEqual(change.Removed, []string{"ccaabob", "ccaadsm"}) {
+		t.Errorf("got change %+v", change)
+	}
+	if change.Since.IsZero() {
+		t.Errorf("change should say when the earlier snapshot was from")
+	}
+}
@@ -0,0 +1,523 @@
+// Package adtest runs an LDAP server inside tests, serving entries from an
+// LDIF file, so adhelper and the programs using it can be tested without the
+// real AD.
+//
+// It only does what adhelper needs: simple binds, searches (with paging and
+// size limits), the AD "in chain" matching rule, and LDAPS or StartTLS. Names
+// and values are compared case-insensitively, as AD does.
+package adtest
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldif"
+)
+
+// The base DN the test LDIF files use, and the bind user that always works.
+const (
+	BaseDN       = "DC=ad,DC=example,DC=com"
+	BindUser     = `AD\sa-test`
+	BindPassword = "hunter2"
+)
+
+const (
+	startTLSOID         = "1.3.6.1.4.1.1466.20037"
+	matchingRuleInChain = "1.2.840.113556.1.4.1941"
+)
+
+// A Server is a running test LDAP server.
+type Server struct {
+	URL     string
+	entries []*ldap.Entry
+
+	// Bind names that work, with their passwords. Users can bind by DN or
+	//  as AD\<sAMAccountName>.
+	passwords map[string]string
+
+	mu       sync.Mutex
+	binds    int // Successful binds.
+	searches int
+	conns    map[net.Conn]bool
+	listener net.Listener
+
+	// For StartTLS, if it's supported.
+	tlsConfig *tls.Config
+}
+
+// NewServer starts a server with the entries in an LDIF file, which is
+// stopped at the end of the test.
+func NewServer(t testing.TB, ldifFile string) *Server {
+	t.Helper()
+	return startServer(t, ldifFile, nil, false)
+}
+
+// NewTLSServer starts a server that talks TLS with the given config, either
+// straight away on an ldaps:// URL or after StartTLS on an ldap:// one.
+func NewTLSServer(t testing.TB, ldifFile string, config *tls.Config, startTLS bool) *Server {
+	t.Helper()
+	return startServer(t, ldifFile, config, startTLS)
+}
+
+func startServer(t testing.TB, ldifFile string, config *tls.Config, startTLS bool) *Server {
+	t.Helper()
+
+	contents, err := os.ReadFile(ldifFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ldif.Parse(string(contents))
+	if err != nil {
+		t.Fatalf("could not parse %s: %s", ldifFile, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		URL:       "ldap://" + listener.Addr().String(),
+		passwords: map[string]string{strings.ToLower(BindUser): BindPassword},
+		conns:     make(map[net.Conn]bool),
+		listener:  listener,
+	}
+	if config != nil && startTLS {
+		s.tlsConfig = config
+	} else if config != nil {
+		s.URL = "ldaps://" + listener.Addr().String()
+		s.listener = tls.NewListener(listener, config)
+	}
+	// AllEntries would trip over the nil entry the version line parses as.
+	for _, e := range parsed.Entries {
+		if e != nil && e.Entry != nil {
+			s.entries = append(s.entries, e.Entry)
+		}
+	}
+	for _, entry := range s.entries {
+		if password := entry.GetAttributeValue("userPassword"); password != "" {
+			s.passwords[strings.ToLower(entry.DN)] = password
+			s.passwords[strings.ToLower(`AD\`+entry.GetAttributeValue("sAMAccountName"))] = password
+		}
+	}
+
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+// Counts returns how many successful binds and searches there have been, to
+// check connections are reused.
+func (s *Server) Counts() (binds int, searches int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.binds, s.searches
+}
+
+// DropConnections drops all the open connections, as AD does to idle ones.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) close() {
+	s.listener.Close()
+	s.DropConnections()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// What a connection's paged searches have got up to, by cookie.
+type pagingState struct {
+	next   int
+	offset map[string]int
+}
+
+func (s *Server) handle(conn net.Conn) {
+	accepted := conn
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, accepted)
+		s.mu.Unlock()
+	}()
+
+	paging := &pagingState{offset: make(map[string]int)}
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		var controls []ldap.Control
+		if len(packet.Children) > 2 {
+			for _, child := range packet.Children[2].Children {
+				control, err := ldap.DecodeControl(child)
+				if err == nil {
+					controls = append(controls, control)
+				}
+			}
+		}
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			err = s.handleBind(conn, messageID, op)
+		case ldap.ApplicationSearchRequest:
+			err = s.handleSearch(conn, messageID, op, controls, paging)
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationAbandonRequest:
+			// Nothing's ever left running to abandon.
+		case ldap.ApplicationExtendedRequest:
+			if s.tlsConfig == nil || stringValue(op.Children[0]) != startTLSOID {
+				err = writeResult(conn, messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform, "not supported by the test server", nil)
+				break
+			}
+			err = writeResult(conn, messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess, "", nil)
+			// The rest of the connection is over TLS, though DropConnections
+			//  still closes the underlying one.
+			conn = tls.Server(conn, s.tlsConfig)
+		default:
+			err = writeResult(conn, messageID, op.Tag+1, ldap.LDAPResultUnwillingToPerform, "not supported by the test server", nil)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handleBind(conn net.Conn, messageID int64, op *ber.Packet) error {
+	name := strings.ToLower(stringValue(op.Children[1]))
+	password := stringValue(op.Children[2])
+
+	s.mu.Lock()
+	want, known := s.passwords[name]
+	ok := known && password != "" && password == want
+	if ok {
+		s.binds++
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return writeResult(conn, messageID, ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials, "invalid credentials", nil)
+	}
+	return writeResult(conn, messageID, ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "", nil)
+}
+
+func (s *Server) handleSearch(conn net.Conn, messageID int64, op *ber.Packet, controls []ldap.Control, paging *pagingState) error {
+	s.mu.Lock()
+	s.searches++
+	s.mu.Unlock()
+
+	base := op.Children[0].Value.(string)
+	scope := int(op.Children[1].Value.(int64))
+	sizeLimit := int(op.Children[3].Value.(int64))
+	filter := op.Children[6]
+	var attributes []string
+	for _, child := range op.Children[7].Children {
+		attributes = append(attributes, child.Value.(string))
+	}
+
+	var matches []*ldap.Entry
+	for _, entry := range s.entries {
+		if inScope(entry.DN, base, scope) && s.matches(filter, entry) {
+			matches = append(matches, entry)
+		}
+	}
+
+	// Paging picks up from where the cookie says, and a page size of 0 ends
+	//  the search.
+	start, end := 0, len(matches)
+	var pagingControl *ldap.ControlPaging
+	if control, ok := ldap.FindControl(controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+		pagingControl = control
+		if len(control.Cookie) > 0 {
+			start = paging.offset[string(control.Cookie)]
+			delete(paging.offset, string(control.Cookie))
+		}
+		if control.PagingSize == 0 {
+			end = start
+		} else if start+int(control.PagingSize) < end {
+			end = start + int(control.PagingSize)
+		}
+	}
+
+	resultCode := uint16(ldap.LDAPResultSuccess)
+	if sizeLimit > 0 && end > sizeLimit {
+		end = sizeLimit
+		resultCode = ldap.LDAPResultSizeLimitExceeded
+	}
+	if start > end {
+		start = end
+	}
+
+	for _, entry := range matches[start:end] {
+		err := writeEntry(conn, messageID, entry, attributes)
+		if err != nil {
+			return err
+		}
+	}
+
+	var responseControls []ldap.Control
+	if pagingControl != nil {
+		response := &ldap.ControlPaging{}
+		if end < len(matches) && pagingControl.PagingSize > 0 && resultCode == ldap.LDAPResultSuccess {
+			paging.next++
+			cookie := strconv.Itoa(paging.next)
+			paging.offset[cookie] = end
+			response.SetCookie([]byte(cookie))
+		}
+		responseControls = append(responseControls, response)
+	}
+	return writeResult(conn, messageID, ldap.ApplicationSearchResultDone, resultCode, "", responseControls)
+}
+
+// Reports whether a DN is within a search's scope.
+func inScope(dn string, base string, scope int) bool {
+	dn = strings.ToLower(dn)
+	base = strings.ToLower(base)
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return dn == base
+	case ldap.ScopeSingleLevel:
+		parsed, err := ldap.ParseDN(dn)
+		if err != nil || len(parsed.RDNs) < 2 {
+			return false
+		}
+		parent, err := ldap.ParseDN(base)
+		if err != nil {
+			return false
+		}
+		return (&ldap.DN{RDNs: parsed.RDNs[1:]}).EqualFold(parent)
+	default:
+		return dn == base || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+func (s *Server) matches(filter *ber.Packet, entry *ldap.Entry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !s.matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if s.matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !s.matches(filter.Children[0], entry)
+	case ldap.FilterEqualityMatch:
+		attribute := stringValue(filter.Children[0])
+		want := stringValue(filter.Children[1])
+		for _, value := range attributeValues(entry, attribute) {
+			if valuesEqual(attribute, value, want) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(attributeValues(entry, stringValue(filter))) > 0
+	case ldap.FilterSubstrings:
+		for _, value := range attributeValues(entry, stringValue(filter.Children[0])) {
+			if substringsMatch(filter.Children[1], value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterExtensibleMatch:
+		var rule, attribute, value string
+		for _, child := range filter.Children {
+			switch child.Tag {
+			case ldap.MatchingRuleAssertionMatchingRule:
+				rule = stringValue(child)
+			case ldap.MatchingRuleAssertionType:
+				attribute = stringValue(child)
+			case ldap.MatchingRuleAssertionMatchValue:
+				value = stringValue(child)
+			}
+		}
+		if rule != matchingRuleInChain {
+			return false
+		}
+		return s.inChain(entry, attribute, value, make(map[string]bool))
+	}
+	return false
+}
+
+// Reports whether target can be reached from an entry by following an
+// attribute of DNs, like memberOf.
+func (s *Server) inChain(entry *ldap.Entry, attribute string, target string, seen map[string]bool) bool {
+	if seen[strings.ToLower(entry.DN)] {
+		return false
+	}
+	seen[strings.ToLower(entry.DN)] = true
+	for _, dn := range attributeValues(entry, attribute) {
+		if valuesEqual(attribute, dn, target) {
+			return true
+		}
+		if next := s.entry(dn); next != nil && s.inChain(next, attribute, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) entry(dn string) *ldap.Entry {
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) {
+			return entry
+		}
+	}
+	return nil
+}
+
+func substringsMatch(parts *ber.Packet, value string) bool {
+	value = strings.ToLower(value)
+	for i, part := range parts.Children {
+		piece := strings.ToLower(stringValue(part))
+		switch part.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, piece) {
+				return false
+			}
+			value = value[len(piece):]
+		case ldap.FilterSubstringsAny:
+			index := strings.Index(value, piece)
+			if index < 0 {
+				return false
+			}
+			value = value[index+len(piece):]
+		case ldap.FilterSubstringsFinal:
+			if i != len(parts.Children)-1 || !strings.HasSuffix(value, piece) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Attributes whose values are DNs, which match however they're written.
+var dnAttributes = map[string]bool{"distinguishedname": true, "member": true, "memberof": true}
+
+func valuesEqual(attribute string, a string, b string) bool {
+	if dnAttributes[strings.ToLower(attribute)] {
+		aDN, aErr := ldap.ParseDN(a)
+		bDN, bErr := ldap.ParseDN(b)
+		if aErr == nil && bErr == nil {
+			return aDN.EqualFold(bDN)
+		}
+	}
+	return strings.EqualFold(a, b)
+}
+
+// Gets an attribute's values, including the DN as distinguishedName, as AD has.
+func attributeValues(entry *ldap.Entry, name string) []string {
+	if strings.EqualFold(name, "distinguishedName") {
+		return []string{entry.DN}
+	}
+	if strings.EqualFold(name, "objectClass") && len(entry.GetEqualFoldAttributeValues(name)) == 0 {
+		return []string{"top"}
+	}
+	return entry.GetEqualFoldAttributeValues(name)
+}
+
+// Gets the string in a packet, whether the decoder made it a Value or not.
+func stringValue(packet *ber.Packet) string {
+	if s, ok := packet.Value.(string); ok {
+		return s
+	}
+	return packet.Data.String()
+}
+
+func writeEntry(w io.Writer, messageID int64, entry *ldap.Entry, attributes []string) error {
+	all := len(attributes) == 0
+	for _, a := range attributes {
+		if a == "*" {
+			all = true
+		}
+	}
+
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+	attributeList := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, attribute := range entry.Attributes {
+		// Passwords can't be read back, as in AD.
+		if strings.EqualFold(attribute.Name, "userPassword") {
+			continue
+		}
+		wanted := all
+		for _, a := range attributes {
+			if strings.EqualFold(a, attribute.Name) {
+				wanted = true
+			}
+		}
+		if !wanted {
+			continue
+		}
+		item := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		item.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute.Name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range attribute.Values {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		item.AppendChild(values)
+		attributeList.AppendChild(item)
+	}
+	response.AppendChild(attributeList)
+	return writeMessage(w, messageID, response, nil)
+}
+
+func writeResult(w io.Writer, messageID int64, tag ber.Tag, resultCode uint16, message string, controls []ldap.Control) error {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(resultCode), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	return writeMessage(w, messageID, response, controls)
+}
+
+func writeMessage(w io.Writer, messageID int64, response *ber.Packet, controls []ldap.Control) error {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	packet.AppendChild(response)
+	if len(controls) > 0 {
+		controlList := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, control := range controls {
+			controlList.AppendChild(control.Encode())
+		}
+		packet.AppendChild(controlList)
+	}
+	_, err := w.Write(packet.Bytes())
+	return err
+}
@@ -0,0 +1,35 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrEmptyPassword = errors.New("empty password")
+
+// Checks a user's password by trying to bind to the server as them.
+// Only the server details in ldapOpts are used, not its bind credentials.
+func Authenticate(ldapOpts *LdapOpts, username string, password string) error {
+	// An empty password would get us an unauthenticated bind, which "succeeds".
+	if password == "" {
+		return ErrEmptyPassword
+	}
+
+	conn, err := dial(ldapOpts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.Bind(username, password)
+	if err != nil {
+		return fmt.Errorf("could not authenticate %s: %w", username, err)
+	}
+	return nil
+}
+
+// Authenticate checks a user's password against the client's server. This
+// always uses a new connection, so the pooled ones stay bound as the client.
+func (c *Client) Authenticate(username string, password string) error {
+	return Authenticate(&c.opts, username, password)
+}
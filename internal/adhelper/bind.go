@@ -0,0 +1,130 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+)
+
+// Ways of binding to the server, for LdapOpts.BindMethod.
+const (
+	BindSimple   = "simple"   // With Username and Password.
+	BindGSSAPI   = "gssapi"   // With a Kerberos ticket, from a credentials cache or keytab.
+	BindExternal = "external" // With the TLS client certificate in ClientCertFile.
+)
+
+var ErrInvalidBindMethod = errors.New("invalid bind method, please use simple, gssapi or external")
+
+// KerberosOpts say where to get Kerberos credentials for GSSAPI binds. With
+// no keytab, the user's credentials cache is used, so they need to have run
+// kinit (or logged in with Kerberos) first.
+type KerberosOpts struct {
+	Keytab    string `yaml:"keytab"`    // Keytab to get a ticket with, for unattended use.
+	Principal string `yaml:"principal"` // Who to get a ticket as from the keytab, e.g. sa-ritsldap01. (Default: Username)
+	Realm     string `yaml:"realm"`     // Realm of the principal. (Default: from the principal's @realm, or the krb5.conf)
+	CCache    string `yaml:"ccache"`    // Credentials cache file. (Default: $KRB5CCNAME, then /tmp/krb5cc_<uid>)
+	Config    string `yaml:"krb5_conf"` // Kerberos config. (Default: $KRB5_CONFIG, then /etc/krb5.conf)
+	SPN       string `yaml:"ldap_spn"`  // Service principal of the LDAP server. (Default: ldap/<server host>)
+}
+
+// Binds a connection as set in opts.
+func bind(conn *ldap.Conn, opts *LdapOpts) error {
+	var err error
+	switch opts.BindMethod {
+	case "", BindSimple:
+		err = conn.Bind(opts.Username, opts.Password)
+	case BindGSSAPI:
+		err = gssapiBind(conn, opts)
+	case BindExternal:
+		if opts.ClientCertFile == "" {
+			return errors.New("an external bind needs a client certificate")
+		}
+		err = conn.ExternalBind()
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidBindMethod, opts.BindMethod)
+	}
+	if err != nil {
+		return fmt.Errorf("could not bind on LDAP server: %w", err)
+	}
+	return nil
+}
+
+func gssapiBind(conn *ldap.Conn, opts *LdapOpts) error {
+	krb := opts.Kerberos
+	krb5conf := firstNonEmpty(krb.Config, os.Getenv("KRB5_CONFIG"), "/etc/krb5.conf")
+
+	spn := krb.SPN
+	if spn == "" {
+		serverUrl, err := url.Parse(opts.ServerUrl)
+		if err != nil {
+			return fmt.Errorf("could not get host for service principal from %q: %w", opts.ServerUrl, err)
+		}
+		spn = "ldap/" + serverUrl.Hostname()
+	}
+
+	var client *gssapi.Client
+	var err error
+	if krb.Keytab != "" {
+		err = CheckCredentialFile(krb.Keytab)
+		if err != nil {
+			return err
+		}
+		principal, realm := splitPrincipal(firstNonEmpty(krb.Principal, opts.Username), krb.Realm)
+		client, err = gssapi.NewClientWithKeytab(principal, realm, krb.Keytab, krb5conf)
+		if err == nil {
+			err = client.Login()
+		}
+	} else {
+		var ccache string
+		ccache, err = ccachePath(krb.CCache)
+		if err == nil {
+			client, err = gssapi.NewClientFromCCache(ccache, krb5conf)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not get Kerberos credentials: %w", err)
+	}
+	defer client.Close()
+
+	return conn.GSSAPIBind(client, spn, "")
+}
+
+// Works out where the credentials cache is, the same way the Kerberos tools do.
+func ccachePath(configured string) (string, error) {
+	ccache := firstNonEmpty(configured, os.Getenv("KRB5CCNAME"), fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid()))
+	// Only file caches can be read, not the KEYRING: or KCM: kinds.
+	if kind, path, found := strings.Cut(ccache, ":"); found {
+		if kind != "FILE" {
+			return "", fmt.Errorf("can't use Kerberos credentials cache %s: only FILE caches are supported", ccache)
+		}
+		ccache = path
+	}
+	return ccache, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Splits a principal given as AD\name or name@REALM into the bare name the
+// keytab client wants and the realm, which is realm if that's set.
+func splitPrincipal(principal string, realm string) (string, string) {
+	if _, name, found := strings.Cut(principal, `\`); found {
+		principal = name
+	}
+	if name, principalRealm, found := strings.Cut(principal, "@"); found {
+		principal = name
+		realm = firstNonEmpty(realm, principalRealm)
+	}
+	return principal, realm
+}
@@ -0,0 +1,25 @@
+package adhelper
+
+import "testing"
+
+func TestSplitPrincipal(t *testing.T) {
+	tests := []struct {
+		principal string
+		realm     string
+		wantName  string
+		wantRealm string
+	}{
+		{"sa-ritsldap01", "", "sa-ritsldap01", ""},
+		{"sa-ritsldap01", "AD.UCL.AC.UK", "sa-ritsldap01", "AD.UCL.AC.UK"},
+		{`AD\sa-ritsldap01`, "", "sa-ritsldap01", ""},
+		{"sa-ritsldap01@AD.UCL.AC.UK", "", "sa-ritsldap01", "AD.UCL.AC.UK"},
+		// A realm that's set wins over the principal's.
+		{"sa-ritsldap01@AD.UCL.AC.UK", "OTHER.REALM", "sa-ritsldap01", "OTHER.REALM"},
+	}
+	for _, tc := range tests {
+		name, realm := splitPrincipal(tc.principal, tc.realm)
+		if name != tc.wantName || realm != tc.wantRealm {
+			t.Errorf("splitPrincipal(%q, %q): got %q, %q, want %q, %q", tc.principal, tc.realm, name, realm, tc.wantName, tc.wantRealm)
+		}
+	}
+}
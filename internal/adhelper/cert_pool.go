@@ -4,18 +4,85 @@ import (
 	"crypto/x509"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
-func certPoolFromFile(filename string) (*x509.CertPool, error) {
+func appendCertsFromFile(pool *x509.CertPool, filename string) error {
 	certPEM, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("could not read cert file: %w", err)
+		return fmt.Errorf("could not read cert file: %w", err)
 	}
-	pool := x509.NewCertPool()
 	ok := pool.AppendCertsFromPEM([]byte(certPEM))
 	if !ok {
-		return nil, fmt.Errorf("failed to parse certificate in %s", filename)
+		return fmt.Errorf("failed to parse certificate in %s", filename)
+	}
+	return nil
+}
+
+// Adds the certificates from a file, or from the .pem, .crt and .cer files in
+// a directory, like /etc/pki/ca-trust/source/anchors.
+func appendCertsFromPath(pool *x509.CertPool, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("could not read CA certificates: %w", err)
+	}
+	if !info.IsDir() {
+		return appendCertsFromFile(pool, path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("could not read CA certificates: %w", err)
 	}
+	found := false
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".pem", ".crt", ".cer":
+		default:
+			continue
+		}
+		if entry.IsDir() {
+			continue
+		}
+		err = appendCertsFromFile(pool, filepath.Join(path, entry.Name()))
+		if err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("no certificates found in %s", path)
+	}
+	return nil
+}
 
+// Makes the pool of CAs the server's certificate is checked against, or nil
+// for just the system's. The certificate files replace the system CAs, unless
+// they're to be added to them.
+func rootCertPool(opts *LdapOpts) (*x509.CertPool, error) {
+	var paths []string
+	if opts.CertFile != "" {
+		paths = append(paths, opts.CertFile)
+	}
+	paths = append(paths, opts.TLS.CAFiles...)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if isTrue(opts.TLS.AddToSystemCAs) {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("could not load system CA certificates: %w", err)
+		}
+		pool = systemPool
+	}
+	for _, path := range paths {
+		err := appendCertsFromPath(pool, path)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return pool, nil
 }
@@ -0,0 +1,140 @@
+package adhelper
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Default settings for a new Client.
+const (
+	DefaultMaxConns    = 1
+	DefaultIdleTimeout = 5 * time.Minute
+)
+
+var ErrClientClosed = errors.New("LDAP client is closed")
+
+// A Client searches an LDAP server, keeping bound connections open between
+// searches rather than dialling and binding for each one. It's safe to use
+// from more than one goroutine.
+type Client struct {
+	opts LdapOpts
+
+	// The most connections open at once. Searches wait for a free one.
+	MaxConns int
+	// Connections left unused for this long are closed rather than reused,
+	// because AD drops idle connections on its side anyway.
+	IdleTimeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*pooledConn
+	slots  chan struct{} // Made on first use, so MaxConns can be set after NewClient.
+	closed bool
+}
+
+type pooledConn struct {
+	conn     *ldap.Conn
+	lastUsed time.Time
+}
+
+// NewClient makes a Client for the server and bind credentials in opts.
+// Nothing is connected until the first search.
+func NewClient(opts *LdapOpts) *Client {
+	return &Client{
+		opts:        *opts,
+		MaxConns:    DefaultMaxConns,
+		IdleTimeout: DefaultIdleTimeout,
+	}
+}
+
+// Close closes the idle connections, and any in use as they're finished with.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, pc := range c.idle {
+		pc.conn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// Dials and binds a new connection.
+func (c *Client) connect() (*ldap.Conn, error) {
+	conn, err := dial(&c.opts)
+	if err != nil {
+		return nil, err
+	}
+	err = bind(conn, &c.opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Takes a connection from the pool, or makes one, waiting if MaxConns are
+// already in use. It has to be given back with release.
+func (c *Client) acquire() (*ldap.Conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	if c.slots == nil {
+		maxConns := c.MaxConns
+		if maxConns < 1 {
+			maxConns = 1
+		}
+		c.slots = make(chan struct{}, maxConns)
+	}
+	slots := c.slots
+	c.mu.Unlock()
+
+	slots <- struct{}{}
+
+	c.mu.Lock()
+	for len(c.idle) > 0 {
+		pc := c.idle[len(c.idle)-1]
+		c.idle = c.idle[:len(c.idle)-1]
+		if pc.conn.IsClosing() || time.Since(pc.lastUsed) > c.IdleTimeout {
+			pc.conn.Close()
+			continue
+		}
+		c.mu.Unlock()
+		return pc.conn, nil
+	}
+	c.mu.Unlock()
+
+	conn, err := c.connect()
+	if err != nil {
+		<-slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Gives a connection back to the pool, or closes it if it's broken.
+func (c *Client) release(conn *ldap.Conn, broken bool) {
+	c.mu.Lock()
+	if broken || c.closed || conn.IsClosing() {
+		conn.Close()
+	} else {
+		c.idle = append(c.idle, &pooledConn{conn: conn, lastUsed: time.Now()})
+	}
+	slots := c.slots
+	c.mu.Unlock()
+	<-slots
+}
+
+// Reports whether an error from a connection means it's no good, rather than
+// the request being wrong. A connection the server has dropped doesn't always
+// give a network error, so it's checked for being closed too.
+func isConnectionError(conn *ldap.Conn, err error) bool {
+	if err == nil {
+		return false
+	}
+	return conn.IsClosing() || ldap.IsErrorAnyOf(err, ldap.ErrorNetwork, ldap.LDAPResultUnavailable, ldap.LDAPResultBusy)
+}
@@ -0,0 +1,287 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFiles are where LoadConfig looks for settings, with later files
+// overriding earlier ones. A config file has named profiles, each with the
+// fields of LdapOpts, and optionally which one to use if none is asked for:
+//
+//	default_profile: ucl
+//	profiles:
+//	  ucl:
+//	    server_url: ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/
+//	    bind_username: AD\sa-ritsldap01
+//	    bind_password_file: /shared/ucl/etc/adpw
+//	    base_dn: DC=ad,DC=ucl,DC=ac,DC=uk
+//	    search:
+//	      page_size: 1000
+//	    tls:
+//	      ca_files: [/etc/pki/ca-trust/source/anchors]
+//	      add_to_system_cas: true
+//	      min_version: "1.2"
+//	  kerberos:
+//	    server_url: ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/
+//	    bind_method: gssapi
+//	    kerberos:
+//	      ldap_spn: ldap/ad.ucl.ac.uk
+var DefaultConfigFiles = []string{"/shared/ucl/etc/adhelper.yaml", filepath.Join(os.Getenv("HOME"), ".adhelper.yaml")}
+
+// DefaultPasswordFiles are tried for the bind password if no password or
+// password file is set anywhere else.
+var DefaultPasswordFiles = []string{filepath.Join(os.Getenv("HOME"), ".adpw"), "/shared/ucl/etc/adpw"}
+
+// DefaultLdapOpts are used for anything not set anywhere else.
+var DefaultLdapOpts = LdapOpts{
+	ServerUrl: "ldaps://ldap-auth-ad-slb.ucl.ac.uk:636/",
+	Username:  `AD\sa-ritsldap01`,
+	BaseDN:    "DC=ad,DC=ucl,DC=ac,DC=uk",
+}
+
+// The profile used if none is asked for and no config file names one.
+const DefaultProfile = "default"
+
+// Environment variables that set each of the options, overriding config files.
+const (
+	EnvProfile      = "AD_PROFILE"
+	EnvServerUrl    = "AD_SERVER_URL"
+	EnvUsername     = "AD_BIND_USERNAME"
+	EnvPasswordFile = "AD_BIND_PASSWORD_FILE"
+	EnvBaseDN       = "AD_BASE_DN"
+	EnvCertFile     = "AD_CERT_FILE"
+	EnvInsecure     = "AD_INSECURE"
+	EnvBindMethod   = "AD_BIND_METHOD"
+	EnvKeytab       = "AD_KEYTAB"
+	EnvClientCert   = "AD_CLIENT_CERT_FILE"
+	EnvClientKey    = "AD_CLIENT_KEY_FILE"
+)
+
+var ErrUnknownProfile = errors.New("unknown LDAP config profile")
+
+type configFile struct {
+	DefaultProfile string              `yaml:"default_profile"`
+	Profiles       map[string]LdapOpts `yaml:"profiles"`
+}
+
+// ConfigOptions are what LoadConfig merges with the config files.
+type ConfigOptions struct {
+	// The profile to use. (Default: from $AD_PROFILE, then the config files,
+	// then DefaultProfile)
+	Profile string
+	// Config files to read, with later ones overriding earlier ones. (Default: DefaultConfigFiles)
+	Files []string
+	// Options from the command line, which override everything else. Only
+	// fields that are set are used, so flags shouldn't have defaults of their own.
+	Flags LdapOpts
+	// Files to try for the bind password if none is set. (Default: DefaultPasswordFiles)
+	PasswordFiles []string
+	// Don't get the bind password, e.g. for only checking user passwords.
+	NoPassword bool
+}
+
+// LoadConfig works out the LDAP options to use, from (in order of precedence)
+// the flags, the environment, the config files and DefaultLdapOpts, using one
+// named profile from the files. The bind password is then read from the
+// password file, if it wasn't given directly.
+//
+// Files with credentials in, whether config files with a bind_password or
+// password files, have to be kept from other users (see CheckCredentialFile).
+func LoadConfig(co ConfigOptions) (*LdapOpts, error) {
+	files := co.Files
+	if files == nil {
+		files = DefaultConfigFiles
+	}
+
+	var configs []configFile
+	profile := DefaultProfile
+	for _, filename := range files {
+		config, err := readConfigFile(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if config.DefaultProfile != "" {
+			profile = config.DefaultProfile
+		}
+		configs = append(configs, config)
+	}
+	if env := os.Getenv(EnvProfile); env != "" {
+		profile = env
+	}
+	if co.Profile != "" {
+		profile = co.Profile
+	}
+
+	env, err := envLdapOpts()
+	if err != nil {
+		return nil, err
+	}
+	opts := co.Flags.or(env)
+	found := false
+	for i := len(configs) - 1; i >= 0; i-- {
+		if fileOpts, ok := configs[i].Profiles[profile]; ok {
+			opts = opts.or(fileOpts)
+			found = true
+		}
+	}
+	// It's fine for nothing to set up the default profile, but asking for
+	//  another one that isn't there is likely a typo.
+	if !found && profile != DefaultProfile {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, profile)
+	}
+	opts = opts.or(DefaultLdapOpts)
+
+	// Only simple binds use the password.
+	needPassword := opts.BindMethod == "" || opts.BindMethod == BindSimple
+	if opts.Password == "" && needPassword && !co.NoPassword {
+		passwordFiles := co.PasswordFiles
+		if opts.PasswordFile != "" {
+			passwordFiles = []string{opts.PasswordFile}
+		} else if passwordFiles == nil {
+			passwordFiles = DefaultPasswordFiles
+		}
+		opts.Password, err = ReadPasswordFile(passwordFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &opts, nil
+}
+
+func readConfigFile(filename string) (configFile, error) {
+	var config configFile
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, err
+		}
+		return config, fmt.Errorf("could not read LDAP config file: %w", err)
+	}
+	err = yaml.Unmarshal(contents, &config)
+	if err != nil {
+		return config, fmt.Errorf("could not parse LDAP config file %s: %w", filename, err)
+	}
+	for _, opts := range config.Profiles {
+		if opts.Password != "" {
+			err = CheckCredentialFile(filename)
+			if err != nil {
+				return config, err
+			}
+			break
+		}
+	}
+	return config, nil
+}
+
+// Gets the options set in the environment.
+func envLdapOpts() (LdapOpts, error) {
+	opts := LdapOpts{
+		ServerUrl:    os.Getenv(EnvServerUrl),
+		Username:     os.Getenv(EnvUsername),
+		PasswordFile: os.Getenv(EnvPasswordFile),
+		BaseDN:       os.Getenv(EnvBaseDN),
+		CertFile:     os.Getenv(EnvCertFile),
+		BindMethod:   os.Getenv(EnvBindMethod),
+		Kerberos: KerberosOpts{
+			Keytab: os.Getenv(EnvKeytab),
+		},
+		ClientCertFile: os.Getenv(EnvClientCert),
+		ClientKeyFile:  os.Getenv(EnvClientKey),
+	}
+	if env := os.Getenv(EnvInsecure); env != "" {
+		insecure, err := strconv.ParseBool(env)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s value %q", EnvInsecure, env)
+		}
+		opts.Insecure = &insecure
+	}
+	return opts, nil
+}
+
+// Bool returns a pointer to a bool, for the options that can be left unset so
+// that they're taken from elsewhere, like Insecure.
+func Bool(b bool) *bool {
+	return &b
+}
+
+// Reports whether one of those options is set to true.
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+// Fills in unset options from another set of options. Options that are set
+// to false still override the other's.
+func (o LdapOpts) or(other LdapOpts) LdapOpts {
+	if o.ServerUrl == "" {
+		o.ServerUrl = other.ServerUrl
+	}
+	if o.Username == "" {
+		o.Username = other.Username
+	}
+	// A password and a password file from different places are both unset by
+	//  whichever came first, so a flag giving a file beats a config file's password.
+	if o.Password == "" && o.PasswordFile == "" {
+		o.Password = other.Password
+		o.PasswordFile = other.PasswordFile
+	}
+	if o.BaseDN == "" {
+		o.BaseDN = other.BaseDN
+	}
+	if o.Insecure == nil {
+		o.Insecure = other.Insecure
+	}
+	if o.CertFile == "" {
+		o.CertFile = other.CertFile
+	}
+	o.TLS = o.TLS.or(other.TLS)
+	if o.BindMethod == "" {
+		o.BindMethod = other.BindMethod
+	}
+	o.Kerberos = o.Kerberos.or(other.Kerberos)
+	// Same as with the password, a certificate and key go together.
+	if o.ClientCertFile == "" && o.ClientKeyFile == "" {
+		o.ClientCertFile = other.ClientCertFile
+		o.ClientKeyFile = other.ClientKeyFile
+	}
+	o.Search = o.Search.or(other.Search)
+	return o
+}
+
+// Fills in unset options from another set of options. Lists aren't merged:
+// a set list replaces the other.
+func (o TLSOpts) or(other TLSOpts) TLSOpts {
+	if o.CAFiles == nil {
+		o.CAFiles = other.CAFiles
+	}
+	if o.AddToSystemCAs == nil {
+		o.AddToSystemCAs = other.AddToSystemCAs
+	}
+	if o.StartTLS == nil {
+		o.StartTLS = other.StartTLS
+	}
+	o.MinVersion = firstNonEmpty(o.MinVersion, other.MinVersion)
+	if o.PinnedKeys == nil {
+		o.PinnedKeys = other.PinnedKeys
+	}
+	return o
+}
+
+// Fills in unset options from another set of options.
+func (o KerberosOpts) or(other KerberosOpts) KerberosOpts {
+	o.Keytab = firstNonEmpty(o.Keytab, other.Keytab)
+	o.Principal = firstNonEmpty(o.Principal, other.Principal)
+	o.Realm = firstNonEmpty(o.Realm, other.Realm)
+	o.CCache = firstNonEmpty(o.CCache, other.CCache)
+	o.Config = firstNonEmpty(o.Config, other.Config)
+	o.SPN = firstNonEmpty(o.SPN, other.SPN)
+	return o
+}
@@ -0,0 +1,72 @@
+package adhelper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigBools(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "adhelper.yaml")
+	err := os.WriteFile(configFile, []byte(`
+profiles:
+  default:
+    allow_insecure: true
+    tls:
+      add_to_system_cas: true
+      start_tls: true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shows an option that can be left unset.
+	show := func(b *bool) string {
+		if b == nil {
+			return "unset"
+		}
+		if *b {
+			return "true"
+		}
+		return "false"
+	}
+
+	tests := []struct {
+		name         string
+		files        []string
+		env          string
+		flags        LdapOpts
+		wantInsecure string
+		wantSystem   string
+		wantStartTLS string
+	}{
+		{"nothing set", []string{}, "", LdapOpts{}, "unset", "unset", "unset"},
+		{"config file", []string{configFile}, "", LdapOpts{}, "true", "true", "true"},
+		{"turned off in the environment", []string{configFile}, "false", LdapOpts{}, "false", "true", "true"},
+		{"turned on in the environment", []string{}, "1", LdapOpts{}, "true", "unset", "unset"},
+		{"turned off by flags", []string{configFile}, "true",
+			LdapOpts{Insecure: Bool(false), TLS: TLSOpts{StartTLS: Bool(false)}},
+			"false", "true", "false"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(EnvProfile, "")
+			t.Setenv(EnvInsecure, tc.env)
+			opts, err := LoadConfig(ConfigOptions{Files: tc.files, Flags: tc.flags, NoPassword: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := [3]string{show(opts.Insecure), show(opts.TLS.AddToSystemCAs), show(opts.TLS.StartTLS)}
+			want := [3]string{tc.wantInsecure, tc.wantSystem, tc.wantStartTLS}
+			if got != want {
+				t.Errorf("got insecure, system CAs and StartTLS %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Setenv(EnvInsecure, "maybe")
+	if _, err := LoadConfig(ConfigOptions{Files: []string{}, NoPassword: true}); err == nil {
+		t.Errorf("got no error for %s=maybe", EnvInsecure)
+	}
+}
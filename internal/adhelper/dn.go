@@ -0,0 +1,94 @@
+package adhelper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// How many DNs to look up in one search. AD copes with much bigger filters,
+// but there's no need to find out where its limit is.
+const resolveBatchSize = 100
+
+// The attributes a username can come from, in order of preference.
+var usernameAttributes = []string{"sAMAccountName", "uid", "cn"}
+
+// Gets the best username for an entry, which should have been searched for
+// with usernameAttributes.
+func entryUsername(entry *ldap.Entry) string {
+	for _, attr := range usernameAttributes {
+		if value := entry.GetAttributeValue(attr); value != "" {
+			return value
+		}
+	}
+	name, err := NameFromDN(entry.DN)
+	if err != nil {
+		return entry.DN
+	}
+	return name
+}
+
+// NameFromDN returns the value of the first part of a DN, e.g. "Smith, Dave"
+// from CN=Smith\, Dave,OU=Users,DC=ad,DC=example,DC=com, with any escaping
+// undone.
+func NameFromDN(dn string) (string, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return "", fmt.Errorf("could not parse DN %q: %w", dn, err)
+	}
+	if len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return "", fmt.Errorf("could not get a name from empty DN %q", dn)
+	}
+	return parsed.RDNs[0].Attributes[0].Value, nil
+}
+
+// Gets a form of a DN that's the same however the server chose to write it,
+// for matching DNs up. DNs that can't be parsed are just lowercased.
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	return strings.ToLower(parsed.String())
+}
+
+// ResolveUsernames looks up the entries for a list of DNs, e.g. the members of
+// a group, and returns their usernames (sAMAccountName, or uid if there isn't
+// one) in the same order. DNs are looked up in batches rather than one at a
+// time. Any that can't be found, e.g. because they're outside the base DN, are
+// named from the DN itself instead.
+func (c *Client) ResolveUsernames(dns []string) ([]string, error) {
+	found := make(map[string]string, len(dns))
+	for start := 0; start < len(dns); start += resolveBatchSize {
+		end := start + resolveBatchSize
+		if end > len(dns) {
+			end = len(dns)
+		}
+		filters := make([]Filter, 0, end-start)
+		for _, dn := range dns[start:end] {
+			filters = append(filters, Eq("distinguishedName", dn))
+		}
+		results, err := c.Search(Or(filters...), usernameAttributes)
+		if err != nil {
+			return nil, fmt.Errorf("could not look up usernames: %w", err)
+		}
+		for _, entry := range results.Entries {
+			found[normalizeDN(entry.DN)] = entryUsername(entry)
+		}
+	}
+
+	usernames := make([]string, 0, len(dns))
+	for _, dn := range dns {
+		if username, ok := found[normalizeDN(dn)]; ok {
+			usernames = append(usernames, username)
+			continue
+		}
+		name, err := NameFromDN(dn)
+		if err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, name)
+	}
+	return usernames, nil
+}
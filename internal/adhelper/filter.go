@@ -0,0 +1,170 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// A Filter is an LDAP search filter. Filters are built with the functions here,
+// which escape every value given to them, so that e.g. a group name can't add
+// conditions of its own. The only wildcards are the ones asked for explicitly.
+//
+// Errors, e.g. from an invalid attribute name, are kept until Build, so that
+// filters can be put together without checking each part.
+type Filter struct {
+	expr string
+	err  error
+}
+
+var (
+	ErrInvalidAttribute = errors.New("invalid LDAP attribute name")
+	ErrEmptyFilter      = errors.New("empty LDAP filter")
+)
+
+// Attribute names are either names or OIDs, optionally with options, e.g. cn,
+// 2.5.4.3 or userCertificate;binary.
+var attributeRe = regexp.MustCompile(`^(?:[A-Za-z][A-Za-z0-9-]*|[0-9]+(?:\.[0-9]+)*)(?:;[A-Za-z0-9-]+)*$`)
+
+func checkAttribute(attr string) error {
+	if !attributeRe.MatchString(attr) {
+		return fmt.Errorf("%w: %q", ErrInvalidAttribute, attr)
+	}
+	return nil
+}
+
+// Eq matches entries where attr is exactly value.
+func Eq(attr string, value string) Filter {
+	if err := checkAttribute(attr); err != nil {
+		return Filter{err: err}
+	}
+	return Filter{expr: fmt.Sprintf("(%s=%s)", attr, ldap.EscapeFilter(value))}
+}
+
+// Present matches entries that have any value for attr.
+func Present(attr string) Filter {
+	if err := checkAttribute(attr); err != nil {
+		return Filter{err: err}
+	}
+	return Filter{expr: fmt.Sprintf("(%s=*)", attr)}
+}
+
+// Substring matches entries where attr starts with initial, then contains each
+// of any in order, then ends with final. Any of them can be empty.
+func Substring(attr string, initial string, any []string, final string) Filter {
+	if err := checkAttribute(attr); err != nil {
+		return Filter{err: err}
+	}
+	// Empty parts in the middle would make a ** that servers reject.
+	parts := []string{ldap.EscapeFilter(initial)}
+	for _, a := range any {
+		if a != "" {
+			parts = append(parts, ldap.EscapeFilter(a))
+		}
+	}
+	parts = append(parts, ldap.EscapeFilter(final))
+	if len(parts) == 2 && parts[0] == "" && parts[1] == "" {
+		return Present(attr)
+	}
+	return Filter{expr: fmt.Sprintf("(%s=%s)", attr, strings.Join(parts, "*"))}
+}
+
+// Wildcard matches attr against a pattern where * matches anything, e.g. from a
+// user giving a search term. Everything else in the pattern is escaped, and a
+// pattern without a * is the same as Eq.
+func Wildcard(attr string, pattern string) Filter {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return Eq(attr, pattern)
+	}
+	return Substring(attr, parts[0], parts[1:len(parts)-1], parts[len(parts)-1])
+}
+
+// Joins filters with a prefix operator, keeping the first error.
+func compose(op string, filters []Filter) Filter {
+	if len(filters) == 0 {
+		return Filter{err: fmt.Errorf("%w: nothing to combine", ErrEmptyFilter)}
+	}
+	var b strings.Builder
+	b.WriteString("(" + op)
+	for _, f := range filters {
+		if f.err != nil {
+			return f
+		}
+		if f.expr == "" {
+			return Filter{err: ErrEmptyFilter}
+		}
+		b.WriteString(f.expr)
+	}
+	b.WriteString(")")
+	return Filter{expr: b.String()}
+}
+
+// And matches entries that match all the filters.
+func And(filters ...Filter) Filter {
+	return compose("&", filters)
+}
+
+// Or matches entries that match any of the filters.
+func Or(filters ...Filter) Filter {
+	return compose("|", filters)
+}
+
+// Not matches entries that don't match a filter.
+func Not(f Filter) Filter {
+	if f.err != nil {
+		return f
+	}
+	if f.expr == "" {
+		return Filter{err: ErrEmptyFilter}
+	}
+	return Filter{expr: "(!" + f.expr + ")"}
+}
+
+// The AD matching rule that follows links like member and memberOf all the
+// way, rather than just one step. (LDAP_MATCHING_RULE_IN_CHAIN)
+const MatchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// InChain matches entries linked to dn through attr, directly or through any
+// number of entries in between, e.g. InChain("member", userDN) matches all the
+// groups a user is in, including through nested groups. Only AD supports it.
+func InChain(attr string, dn string) Filter {
+	if err := checkAttribute(attr); err != nil {
+		return Filter{err: err}
+	}
+	return Filter{expr: fmt.Sprintf("(%s:%s:=%s)", attr, MatchingRuleInChain, ldap.EscapeFilter(dn))}
+}
+
+// Raw uses a filter written by hand, e.g. given on the command line. It's
+// checked for being a valid filter, but anything in it is taken as meant, so
+// it's not for putting untrusted values into.
+func Raw(expr string) Filter {
+	_, err := ldap.CompileFilter(expr)
+	if err != nil {
+		return Filter{err: fmt.Errorf("invalid LDAP filter %q: %w", expr, err)}
+	}
+	return Filter{expr: expr}
+}
+
+// Build returns the filter as a string, or the first error from building it.
+func (f Filter) Build() (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	if f.expr == "" {
+		return "", ErrEmptyFilter
+	}
+	return f.expr, nil
+}
+
+// String returns the filter as a string, or a description of what's wrong with
+// it, for logging.
+func (f Filter) String() string {
+	if f.err != nil {
+		return "(invalid filter: " + f.err.Error() + ")"
+	}
+	return f.expr
+}
@@ -0,0 +1,119 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrGroupNotFound = errors.New("AD group not found")
+
+// A GroupMember is a user found by expanding a group and the groups nested in it.
+type GroupMember struct {
+	Username string // The user's sAMAccountName, or uid or CN if they don't have one.
+	DN       string
+	// The groups the user was found through, from the one asked for down to
+	// the one they're directly in. Users in more than one are given the
+	// shortest way in.
+	Via []string
+}
+
+// Returns the members of nested groups, following them down as far as they go.
+func GetADGroupMembersNested(ldapOpts *LdapOpts, groupname string) ([]GroupMember, error) {
+	client := NewClient(ldapOpts)
+	defer client.Close()
+	return client.GetADGroupMembersNested(groupname)
+}
+
+// GetADGroupMembersNested returns the users in an AD group, including those in
+// groups that are members of it, and so on down, each with the path of groups
+// they were found through. Groups that end up containing themselves are only
+// gone through once.
+//
+// This is done a group at a time rather than with InChain, which finds the same
+// users in one search but can't say how they got there.
+func (c *Client) GetADGroupMembersNested(groupname string) ([]GroupMember, error) {
+	results, err := c.Search(And(Eq("objectCategory", "Group"), Eq("cn", groupname)), []string{"cn"})
+	if err != nil {
+		return nil, err
+	}
+	if len(results.Entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, groupname)
+	}
+
+	type group struct {
+		dn  string
+		via []string
+	}
+	var queue []group
+	seenGroups := map[string]bool{}
+	for _, entry := range results.Entries {
+		queue = append(queue, group{dn: entry.DN, via: []string{entry.GetAttributeValue("cn")}})
+		seenGroups[strings.ToLower(entry.DN)] = true
+	}
+
+	// Going breadth-first means the first path found to a user is a shortest one.
+	members := []GroupMember{}
+	seenUsers := map[string]bool{}
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+
+		results, err := c.Search(Eq("memberOf", g.dn), append([]string{"objectClass"}, usernameAttributes...))
+		if err != nil {
+			return nil, fmt.Errorf("could not get members of %s: %w", strings.Join(g.via, " > "), err)
+		}
+		for _, entry := range results.Entries {
+			key := strings.ToLower(entry.DN)
+			if isGroupEntry(entry.GetAttributeValues("objectClass")) {
+				if !seenGroups[key] {
+					seenGroups[key] = true
+					queue = append(queue, group{dn: entry.DN, via: appendPath(g.via, entry.GetAttributeValue("cn"))})
+				}
+				continue
+			}
+			if seenUsers[key] {
+				continue
+			}
+			seenUsers[key] = true
+			members = append(members, GroupMember{Username: entryUsername(entry), DN: entry.DN, Via: g.via})
+		}
+	}
+
+	return members, nil
+}
+
+// Returns the usernames of everyone in nested groups.
+func GetADGroupUsers(ldapOpts *LdapOpts, groupname string) ([]string, error) {
+	client := NewClient(ldapOpts)
+	defer client.Close()
+	return client.GetADGroupUsers(groupname)
+}
+
+// GetADGroupUsers returns the usernames of everyone in an AD group, including
+// through nested groups, but not the nested groups themselves.
+func (c *Client) GetADGroupUsers(groupname string) ([]string, error) {
+	members, err := c.GetADGroupMembersNested(groupname)
+	if err != nil {
+		return nil, err
+	}
+	usernames := make([]string, len(members))
+	for i, m := range members {
+		usernames[i] = m.Username
+	}
+	return usernames, nil
+}
+
+func isGroupEntry(objectClasses []string) bool {
+	for _, class := range objectClasses {
+		if strings.EqualFold(class, "group") {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends to a copy, so paths that share a start don't share a backing array.
+func appendPath(path []string, name string) []string {
+	return append(path[:len(path):len(path)], name)
+}
@@ -0,0 +1,51 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrInsecureCredentials = errors.New("credentials file can be read or changed by other users")
+
+// ReadPasswordFile returns the contents of the first of a list of files that exists,
+// for getting bind passwords from. Blank filenames are skipped, so that unset
+// environment variables can be put in the list. Files other users can read are
+// refused (see CheckCredentialFile).
+func ReadPasswordFile(possibleFilenames []string) (string, error) {
+	for _, filename := range possibleFilenames {
+		if filename == "" {
+			// Skip blank entry, for if e.g. env var is unset
+			continue
+		}
+		contents, err := os.ReadFile(filename)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return "", fmt.Errorf("file %s exists but could not be read: %w", filename, err)
+		}
+		err = CheckCredentialFile(filename)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(contents)), nil
+	}
+	return "", errors.New("no valid password file could be found from following files: " + strings.Join(possibleFilenames, " "))
+}
+
+// CheckCredentialFile returns ErrInsecureCredentials if a file with credentials
+// in is readable by everyone, or writable by anyone but its owner. Being
+// readable by its group is allowed, as that's how shared bind credentials are
+// given to the people who should have them.
+func CheckCredentialFile(filename string) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("could not check permissions of %s: %w", filename, err)
+	}
+	if perm := info.Mode().Perm(); perm&0o026 != 0 {
+		return fmt.Errorf("%w: %s has mode %04o (should be at most 0640)", ErrInsecureCredentials, filename, perm)
+	}
+	return nil
+}
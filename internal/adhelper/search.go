@@ -0,0 +1,304 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// SearchOptions control how a search is made. Unset fields are taken from the
+// LdapOpts the Client was made with, and then from the defaults.
+type SearchOptions struct {
+	BaseDN    string `yaml:"-"`          // Where to search from, instead of the LdapOpts BaseDN.
+	PageSize  int    `yaml:"page_size"`  // Entries to fetch at a time. (Default: DefaultPageSize)
+	SizeLimit int    `yaml:"size_limit"` // Most entries to return. (Default: no limit, past the server's)
+	TimeLimit int    `yaml:"time_limit"` // Seconds the server can spend on each page. (Default: no limit, past the server's)
+	Scope     string `yaml:"scope"`      // How far below the base to search: base, one or sub. (Default: sub)
+	Deref     string `yaml:"deref"`      // When to follow aliases: never, search, find or always. (Default: never)
+}
+
+// DefaultPageSize is below AD's default MaxPageSize of 1000, which is the most
+// it will return in one go.
+const DefaultPageSize = 500
+
+var Scopes = map[string]int{
+	"base": ldap.ScopeBaseObject,
+	"one":  ldap.ScopeSingleLevel,
+	"sub":  ldap.ScopeWholeSubtree,
+}
+
+var DerefPolicies = map[string]int{
+	"never":  ldap.NeverDerefAliases,
+	"search": ldap.DerefInSearching,
+	"find":   ldap.DerefFindingBaseObj,
+	"always": ldap.DerefAlways,
+}
+
+var (
+	ErrInvalidScope = errors.New("invalid search scope, please use base, one or sub")
+	ErrInvalidDeref = errors.New("invalid alias dereferencing, please use never, search, find or always")
+)
+
+// Fills in unset options from another set of options.
+func (o SearchOptions) or(other SearchOptions) SearchOptions {
+	if o.BaseDN == "" {
+		o.BaseDN = other.BaseDN
+	}
+	if o.PageSize == 0 {
+		o.PageSize = other.PageSize
+	}
+	if o.SizeLimit == 0 {
+		o.SizeLimit = other.SizeLimit
+	}
+	if o.TimeLimit == 0 {
+		o.TimeLimit = other.TimeLimit
+	}
+	if o.Scope == "" {
+		o.Scope = other.Scope
+	}
+	if o.Deref == "" {
+		o.Deref = other.Deref
+	}
+	return o
+}
+
+// Makes the request for a search, with the options filled in from the client's.
+func (c *Client) searchRequest(filter Filter, returnFields []string, so SearchOptions) (*ldap.SearchRequest, SearchOptions, error) {
+	searchExpression, err := filter.Build()
+	if err != nil {
+		return nil, so, err
+	}
+
+	so = so.or(c.opts.Search).or(SearchOptions{
+		BaseDN:   c.opts.BaseDN,
+		PageSize: DefaultPageSize,
+		Scope:    "sub",
+		Deref:    "never",
+	})
+	scope, ok := Scopes[so.Scope]
+	if !ok {
+		return nil, so, ErrInvalidScope
+	}
+	deref, ok := DerefPolicies[so.Deref]
+	if !ok {
+		return nil, so, ErrInvalidDeref
+	}
+	if so.PageSize < 0 || so.SizeLimit < 0 || so.TimeLimit < 0 {
+		return nil, so, errors.New("LDAP page size and limits can't be negative")
+	}
+
+	// Prototype, just for reference
+	// func NewSearchRequest(
+	//    BaseDN string,
+	//    Scope, DerefAliases, SizeLimit, TimeLimit int,
+	//    TypesOnly bool,
+	//    Filter string,
+	//    Attributes []string,
+	//    Controls []Control,
+	// ) *SearchRequest
+
+	// Values are escaped as the filter is built (see Filter), rather than with
+	//  ldap.EscapeFilter here, which would escape the wildcards we want too.
+
+	searchReq := ldap.NewSearchRequest(
+		so.BaseDN,
+		scope, deref, so.SizeLimit, so.TimeLimit, false,
+		searchExpression,
+		returnFields, //empty attributes means all of them
+		[]ldap.Control{},
+	)
+	return searchReq, so, nil
+}
+
+// IsLimitExceeded reports whether a search error is from hitting the size or
+// time limit, in which case the entries found up to then are still good.
+func IsLimitExceeded(err error) bool {
+	return errors.Is(err, ldap.ErrSizeLimitExceeded) ||
+		ldap.IsErrorAnyOf(err, ldap.LDAPResultSizeLimitExceeded, ldap.LDAPResultTimeLimitExceeded)
+}
+
+// Search returns the entries matching a filter, with the given attributes (or
+// all of them, if none are given), using the client's search options.
+func (c *Client) Search(filter Filter, returnFields []string) (*ldap.SearchResult, error) {
+	return c.SearchWithOptions(filter, returnFields, SearchOptions{})
+}
+
+// SearchWithOptions is Search with options for just this search. If a limit is
+// hit, the entries found up to then are returned along with the error.
+func (c *Client) SearchWithOptions(filter Filter, returnFields []string, so SearchOptions) (*ldap.SearchResult, error) {
+	it := c.SearchIter(filter, returnFields, so)
+	defer it.Close()
+
+	result := &ldap.SearchResult{}
+	for it.Next() {
+		result.Entries = append(result.Entries, it.Entry())
+	}
+	result.Referrals = it.referrals
+	return result, it.Err()
+}
+
+// An EntryIterator goes through the results of a search a page at a time, so
+// entries can be dealt with as they arrive. It holds on to one of the client's
+// connections until it's finished or closed.
+//
+// Use it like sql.Rows:
+//
+//	it := client.SearchIter(filter, nil, adhelper.SearchOptions{})
+//	defer it.Close()
+//	for it.Next() {
+//		entry := it.Entry()
+//		...
+//	}
+//	if it.Err() != nil { ... }
+type EntryIterator struct {
+	client  *Client
+	request *ldap.SearchRequest
+	paging  *ldap.ControlPaging
+	limit   int
+
+	conn      *ldap.Conn
+	page      []*ldap.Entry
+	entry     *ldap.Entry
+	returned  int
+	started   bool
+	done      bool
+	err       error
+	referrals []string
+}
+
+// SearchIter starts a search, returning an iterator over the entries found.
+// Nothing is fetched until the first call to Next.
+func (c *Client) SearchIter(filter Filter, returnFields []string, so SearchOptions) *EntryIterator {
+	it := &EntryIterator{client: c}
+	var err error
+	it.request, so, err = c.searchRequest(filter, returnFields, so)
+	if err != nil {
+		it.err = err
+		it.done = true
+		return it
+	}
+	it.limit = so.SizeLimit
+	if so.PageSize > 0 {
+		it.paging = ldap.NewControlPaging(uint32(so.PageSize))
+		it.request.Controls = append(it.request.Controls, it.paging)
+	}
+	return it
+}
+
+// Fetches the next page of entries, getting a connection first if need be.
+func (it *EntryIterator) fetch() error {
+	if it.conn == nil {
+		conn, err := it.client.acquire()
+		if err != nil {
+			return err
+		}
+		it.conn = conn
+	}
+
+	result, err := it.conn.Search(it.request)
+	if !it.started && isConnectionError(it.conn, err) {
+		// The connection may have been dropped while idle, so if nothing's
+		//  been returned yet it's safe to try again with a new one.
+		it.client.release(it.conn, true)
+		it.conn = nil
+		conn, acquireErr := it.client.acquire()
+		if acquireErr != nil {
+			return acquireErr
+		}
+		it.conn = conn
+		result, err = it.conn.Search(it.request)
+	}
+	it.started = true
+	if result != nil {
+		it.page = result.Entries
+		it.referrals = append(it.referrals, result.Referrals...)
+	}
+	if err != nil {
+		it.done = true
+		return err
+	}
+
+	// Without a cookie to carry on from, this was the last page.
+	if it.paging == nil {
+		it.done = true
+		return nil
+	}
+	var cookie []byte
+	if control, ok := ldap.FindControl(result.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+		cookie = control.Cookie
+	}
+	it.paging.SetCookie(cookie)
+	if len(cookie) == 0 {
+		it.done = true
+	}
+	return nil
+}
+
+// Next moves on to the next entry, fetching another page if need be. It returns
+// false when there are no more, or if there was an error (see Err).
+func (it *EntryIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			it.finish()
+			return false
+		}
+		it.err = it.fetch()
+		if it.err != nil && len(it.page) == 0 {
+			it.finish()
+			return false
+		}
+	}
+
+	// Servers don't all apply the size limit across pages, so it's checked here too.
+	if it.limit > 0 && it.returned >= it.limit {
+		if it.err == nil {
+			it.err = ldap.ErrSizeLimitExceeded
+		}
+		it.finish()
+		return false
+	}
+
+	it.entry = it.page[0]
+	it.page = it.page[1:]
+	it.returned++
+	return true
+}
+
+// Entry returns the current entry.
+func (it *EntryIterator) Entry() *ldap.Entry {
+	return it.entry
+}
+
+// Err returns the error that stopped the search, if any. It can be checked
+// with IsLimitExceeded to see if the results were just cut short.
+func (it *EntryIterator) Err() error {
+	if it.err != nil {
+		return fmt.Errorf("failed to search LDAP: %w", it.err)
+	}
+	return nil
+}
+
+// Close stops the search, if it's not finished, and gives the connection back.
+func (it *EntryIterator) Close() error {
+	it.finish()
+	return nil
+}
+
+func (it *EntryIterator) finish() {
+	it.page = nil
+	if it.conn == nil {
+		return
+	}
+	broken := isConnectionError(it.conn, it.err)
+	// A paged search left part way through has to be abandoned, or the
+	//  server keeps its place until the connection closes.
+	if !broken && !it.done && it.paging != nil && len(it.paging.Cookie) > 0 {
+		it.paging.PagingSize = 0
+		_, err := it.conn.Search(it.request)
+		broken = isConnectionError(it.conn, err)
+	}
+	it.done = true
+	it.client.release(it.conn, broken)
+	it.conn = nil
+}
@@ -0,0 +1,146 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A GroupSnapshot is who was in a group at some point, for seeing later how
+// it's changed, e.g. for access reviews.
+type GroupSnapshot struct {
+	Taken   time.Time `yaml:"taken"`
+	Members []string  `yaml:"members"` // Sorted usernames, including through nested groups.
+}
+
+// Snapshots are the latest snapshots of some groups, as kept in a file.
+type Snapshots struct {
+	Groups map[string]GroupSnapshot `yaml:"groups"`
+}
+
+// A GroupChange is how a group's membership has changed between two snapshots.
+type GroupChange struct {
+	Group   string
+	Since   time.Time // When the earlier snapshot was taken, or zero if there wasn't one.
+	Added   []string
+	Removed []string
+}
+
+// Changed reports whether anyone has been added or removed.
+func (gc GroupChange) Changed() bool {
+	return len(gc.Added) > 0 || len(gc.Removed) > 0
+}
+
+// SnapshotGroup gets the current users of a group, including through nested groups.
+func (c *Client) SnapshotGroup(group string) (GroupSnapshot, error) {
+	usernames, err := c.GetADGroupUsers(group)
+	if err != nil {
+		return GroupSnapshot{}, err
+	}
+	return GroupSnapshot{Taken: time.Now(), Members: sortedUnique(usernames)}, nil
+}
+
+// LoadSnapshots reads snapshots from a file. A file that doesn't exist yet
+// just has no snapshots in it.
+func LoadSnapshots(filename string) (*Snapshots, error) {
+	s := &Snapshots{Groups: map[string]GroupSnapshot{}}
+	contents, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot file: %w", err)
+	}
+	err = yaml.Unmarshal(contents, s)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse snapshot file %s: %w", filename, err)
+	}
+	if s.Groups == nil {
+		s.Groups = map[string]GroupSnapshot{}
+	}
+	return s, nil
+}
+
+// Save writes the snapshots to a file, replacing it. Group membership isn't for
+// everyone to see, so the file is only readable by its owner.
+func (s *Snapshots) Save(filename string) error {
+	contents, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// Writing to a temporary file first means an interrupted save can't
+	//  lose the earlier snapshots.
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("could not save snapshots: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not save snapshots: %w", err)
+	}
+	err = os.Rename(tmp.Name(), filename)
+	if err != nil {
+		return fmt.Errorf("could not save snapshots: %w", err)
+	}
+	return nil
+}
+
+// Update replaces the snapshot of a group, returning how it's changed since the
+// one it replaces.
+func (s *Snapshots) Update(group string, snapshot GroupSnapshot) GroupChange {
+	previous, ok := s.Groups[group]
+	s.Groups[group] = snapshot
+	if !ok {
+		return GroupChange{Group: group, Added: snapshot.Members}
+	}
+	return DiffSnapshots(group, previous, snapshot)
+}
+
+// DiffSnapshots returns who's been added to and removed from a group between
+// two snapshots of it.
+func DiffSnapshots(group string, before GroupSnapshot, after GroupSnapshot) GroupChange {
+	change := GroupChange{Group: group, Since: before.Taken}
+	wasIn := make(map[string]bool, len(before.Members))
+	for _, m := range before.Members {
+		wasIn[strings.ToLower(m)] = true
+	}
+	isIn := make(map[string]bool, len(after.Members))
+	for _, m := range after.Members {
+		isIn[strings.ToLower(m)] = true
+		if !wasIn[strings.ToLower(m)] {
+			change.Added = append(change.Added, m)
+		}
+	}
+	for _, m := range before.Members {
+		if !isIn[strings.ToLower(m)] {
+			change.Removed = append(change.Removed, m)
+		}
+	}
+	sort.Strings(change.Added)
+	sort.Strings(change.Removed)
+	return change
+}
+
+func sortedUnique(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	unique := []string{}
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
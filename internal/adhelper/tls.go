@@ -0,0 +1,132 @@
+package adhelper
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TLSOpts are the finer details of how the server's certificate is checked.
+// The CertFile and Insecure options in LdapOpts still work as before.
+type TLSOpts struct {
+	// CA certificate files, or directories of them, to check the server's
+	// certificate against, as well as CertFile.
+	CAFiles []string `yaml:"ca_files"`
+	// Add the CA files to the system's CAs, rather than only trusting them.
+	AddToSystemCAs *bool `yaml:"add_to_system_cas"`
+	// Upgrade ldap:// connections to TLS with StartTLS.
+	StartTLS *bool `yaml:"start_tls"`
+	// Lowest TLS version to accept: 1.0, 1.1, 1.2 or 1.3. (Default: Go's, currently 1.2)
+	MinVersion string `yaml:"min_version"`
+	// The server's verified certificate chain has to include one of these
+	// public keys, given as base64 SHA-256 hashes of the SubjectPublicKeyInfo,
+	// the same as for HPKP or curl's --pinnedpubkey, optionally with curl's
+	// sha256// prefix. (See SPKIPin.) With Insecure, nothing but the server's
+	// own certificate can be relied on, so that has to have one.
+	PinnedKeys []string `yaml:"pinned_keys"`
+}
+
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var (
+	ErrInvalidTLSVersion = errors.New("invalid TLS version, please use 1.0, 1.1, 1.2 or 1.3")
+	ErrPinMismatch       = errors.New("server's certificate chain doesn't have a pinned public key")
+)
+
+// SPKIPin returns the pin for a certificate's public key, for PinnedKeys.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Reports whether any of a chain of certificates has a pinned key.
+func chainHasPin(chain []*x509.Certificate, pins []string) bool {
+	for _, cert := range chain {
+		pin := SPKIPin(cert)
+		for _, p := range pins {
+			if strings.TrimPrefix(p, "sha256//") == pin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Checks the certificates that can be relied on have a pinned key: those in
+// the chains verification built, or if there weren't any (with Insecure),
+// just the server's own. The rest of what the server sent could be anything,
+// e.g. the real server's certificate added to the end of someone else's.
+func checkPins(peer []*x509.Certificate, verified [][]*x509.Certificate, pins []string) error {
+	if len(verified) == 0 {
+		if len(peer) > 0 && chainHasPin(peer[:1], pins) {
+			return nil
+		}
+		return ErrPinMismatch
+	}
+	for _, chain := range verified {
+		if chainHasPin(chain, pins) {
+			return nil
+		}
+	}
+	return ErrPinMismatch
+}
+
+// Makes the TLS config for connecting to the server.
+func tlsConfig(opts *LdapOpts) (*tls.Config, error) {
+	rootCAs, err := rootCertPool(opts)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cert pool: %w", err)
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: isTrue(opts.Insecure),
+		RootCAs:            rootCAs,
+	}
+
+	// StartTLS doesn't get the hostname from the URL like DialURL does.
+	if serverUrl, err := url.Parse(opts.ServerUrl); err == nil {
+		config.ServerName = serverUrl.Hostname()
+	}
+
+	if opts.TLS.MinVersion != "" {
+		version, ok := TLSVersions[opts.TLS.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTLSVersion, opts.TLS.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	// Pins are checked as well as the usual verification. With Insecure, only
+	//  a pin of the server's own key stands in for it, since the handshake
+	//  proves the server has that key but nothing else in the chain.
+	if len(opts.TLS.PinnedKeys) > 0 {
+		pins := opts.TLS.PinnedKeys
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkPins(cs.PeerCertificates, cs.VerifiedChains, pins)
+		}
+	}
+
+	if opts.ClientCertFile != "" {
+		err = CheckCredentialFile(firstNonEmpty(opts.ClientKeyFile, opts.ClientCertFile))
+		if err != nil {
+			return nil, err
+		}
+		// The key can be in the same file as the certificate.
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, firstNonEmpty(opts.ClientKeyFile, opts.ClientCertFile))
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,116 @@
+package adhelper
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// A TLSCheck is what the server presented when connecting with TLS, and
+// whether it would be accepted.
+type TLSCheck struct {
+	Version     uint16
+	CipherSuite uint16
+	Chain       []*x509.Certificate // As the server sent it, its own first.
+
+	VerifyErr error // Why the chain wasn't trusted, or nil if it was.
+	PinErr    error // ErrPinMismatch if there are pins and none matched. (See TLSOpts.PinnedKeys.)
+	Insecure  bool  // Whether VerifyErr would have been ignored.
+}
+
+// OK reports whether a connection would be accepted.
+func (tc *TLSCheck) OK() bool {
+	return (tc.VerifyErr == nil || tc.Insecure) && tc.PinErr == nil
+}
+
+// CheckTLS connects to the server without checking its certificate, and then
+// checks it the way a real connection would, to say what it presented and
+// why it would or wouldn't be trusted. ldap:// URLs are always tried with
+// StartTLS. Nothing is sent but the StartTLS request, so no bind is needed.
+func CheckTLS(opts *LdapOpts) (*TLSCheck, error) {
+	config, err := tlsConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	probe := config.Clone()
+	probe.InsecureSkipVerify = true
+	probe.VerifyConnection = nil
+
+	conn, err := ldap.DialURL(opts.ServerUrl, ldap.DialWithTLSConfig(probe))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to LDAP server: %w", err)
+	}
+	defer conn.Close()
+	if !strings.HasPrefix(strings.ToLower(opts.ServerUrl), "ldaps:") {
+		err = conn.StartTLS(probe)
+		if err != nil {
+			return nil, fmt.Errorf("could not start TLS on LDAP connection: %w", err)
+		}
+	}
+	state, ok := conn.TLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return nil, errors.New("server didn't present a certificate")
+	}
+
+	check := &TLSCheck{
+		Version:     state.Version,
+		CipherSuite: state.CipherSuite,
+		Chain:       state.PeerCertificates,
+		Insecure:    isTrue(opts.Insecure),
+	}
+	verifyOpts := x509.VerifyOptions{
+		Roots:         config.RootCAs,
+		DNSName:       config.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		verifyOpts.Intermediates.AddCert(cert)
+	}
+	chains, err := state.PeerCertificates[0].Verify(verifyOpts)
+	check.VerifyErr = err
+	if len(opts.TLS.PinnedKeys) > 0 {
+		check.PinErr = checkPins(state.PeerCertificates, chains, opts.TLS.PinnedKeys)
+	}
+	return check, nil
+}
+
+// TLSVersionName returns a TLS version's number, e.g. "1.2".
+func TLSVersionName(version uint16) string {
+	for name, v := range TLSVersions {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("unknown (0x%04x)", version)
+}
+
+// ExplainVerifyError says in plainer words why a certificate wasn't trusted,
+// and what might fix it.
+func ExplainVerifyError(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &unknownAuthority):
+		issuer := "its issuer"
+		if unknownAuthority.Cert != nil {
+			issuer = unknownAuthority.Cert.Issuer.String()
+		}
+		return fmt.Sprintf("The chain doesn't lead to a trusted CA. Trust the certificate of %s with cert_file or ca_files (and add_to_system_cas to keep the system's CAs).", issuer)
+	case errors.As(err, &hostname):
+		names := hostname.Certificate.DNSNames
+		if len(names) == 0 {
+			names = []string{hostname.Certificate.Subject.CommonName}
+		}
+		return fmt.Sprintf("The certificate is for %s, not %s. Use one of those names in the server URL.", strings.Join(names, ", "), hostname.Host)
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return fmt.Sprintf("A certificate in the chain (%s) is only valid from %s to %s.",
+			invalid.Cert.Subject, invalid.Cert.NotBefore.Format("2006-01-02"), invalid.Cert.NotAfter.Format("2006-01-02"))
+	case errors.Is(err, ErrPinMismatch):
+		return "None of the trusted certificates' public keys match pinned_keys (with allow_insecure, only the server's own counts). If the server's key has changed, check the new one is right before pinning it."
+	}
+	return err.Error()
+}
@@ -0,0 +1,274 @@
+package adhelper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/UCL-RITS/go-clustertools/internal/adhelper/adtest"
+)
+
+// A CA and a server certificate it signed, for 127.0.0.1.
+type testPKI struct {
+	caCert     *x509.Certificate
+	caFile     string // The CA's certificate, as PEM.
+	serverCert *x509.Certificate
+	config     *tls.Config // For the server.
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ldap.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := x509.ParseCertificate(serverDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testPKI{
+		caCert:     caCert,
+		caFile:     caFile,
+		serverCert: serverCert,
+		config: &tls.Config{
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{serverDER, caDER},
+				PrivateKey:  serverKey,
+				Leaf:        serverCert,
+			}},
+			MaxVersion: tls.VersionTLS12,
+		},
+	}
+}
+
+func TestTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	caDir := filepath.Dir(pki.caFile)
+	emptyDir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		startTLS bool
+		setOpts  func(*LdapOpts)
+		wantErr  error // Checked with errors.Is, if wantFail.
+		wantFail bool
+	}{
+		{"untrusted CA", false, func(o *LdapOpts) {}, nil, true},
+		{"cert file", false, func(o *LdapOpts) { o.CertFile = pki.caFile }, nil, false},
+		{"CA directory", false, func(o *LdapOpts) { o.TLS.CAFiles = []string{caDir} }, nil, false},
+		{"CA directory without certificates", false, func(o *LdapOpts) { o.TLS.CAFiles = []string{emptyDir} }, nil, true},
+		{"added to system CAs", false, func(o *LdapOpts) {
+			o.TLS.CAFiles = []string{pki.caFile}
+			o.TLS.AddToSystemCAs = Bool(true)
+		}, nil, false},
+		{"insecure", false, func(o *LdapOpts) { o.Insecure = Bool(true) }, nil, false},
+		{"pinned server key", false, func(o *LdapOpts) {
+			o.CertFile = pki.caFile
+			o.TLS.PinnedKeys = []string{"sha256//" + SPKIPin(pki.serverCert)}
+		}, nil, false},
+		{"pinned CA key", false, func(o *LdapOpts) {
+			o.CertFile = pki.caFile
+			o.TLS.PinnedKeys = []string{SPKIPin(pki.caCert)}
+		}, nil, false},
+		{"pinned server key, insecurely", false, func(o *LdapOpts) {
+			o.Insecure = Bool(true)
+			o.TLS.PinnedKeys = []string{SPKIPin(pki.serverCert)}
+		}, nil, false},
+		// Without verification, nothing shows the CA signed anything.
+		{"pinned CA key, insecurely", false, func(o *LdapOpts) {
+			o.Insecure = Bool(true)
+			o.TLS.PinnedKeys = []string{SPKIPin(pki.caCert)}
+		}, ErrPinMismatch, true},
+		{"wrong pin", false, func(o *LdapOpts) {
+			o.CertFile = pki.caFile
+			o.TLS.PinnedKeys = []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}
+		}, ErrPinMismatch, true},
+		{"TLS version too old", false, func(o *LdapOpts) {
+			o.CertFile = pki.caFile
+			o.TLS.MinVersion = "1.3"
+		}, nil, true},
+		{"invalid TLS version", false, func(o *LdapOpts) { o.TLS.MinVersion = "1.4" }, ErrInvalidTLSVersion, true},
+		{"StartTLS", true, func(o *LdapOpts) {
+			o.CertFile = pki.caFile
+			o.TLS.StartTLS = Bool(true)
+		}, nil, false},
+		{"StartTLS with untrusted CA", true, func(o *LdapOpts) { o.TLS.StartTLS = Bool(true) }, nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := adtest.NewTLSServer(t, filepath.Join("testdata", "ad.ldif"), pki.config, tc.startTLS)
+			opts := testOpts(server)
+			tc.setOpts(opts)
+
+			_, err := RunADSearch(opts, Eq("cn", "ccaaali"), []string{"cn"})
+			if tc.wantFail && err == nil {
+				t.Fatal("search worked, but it shouldn't have")
+			}
+			if !tc.wantFail && err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := adtest.NewTLSServer(t, filepath.Join("testdata", "ad.ldif"), pki.config, true)
+
+	opts := testOpts(server)
+	check, err := CheckTLS(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(check.Chain) != 2 || !check.Chain[0].Equal(pki.serverCert) {
+		t.Errorf("got a chain of %d certificates, want the server's and the CA's", len(check.Chain))
+	}
+	if TLSVersionName(check.Version) != "1.2" {
+		t.Errorf("got TLS %s, want 1.2", TLSVersionName(check.Version))
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(check.VerifyErr, &unknownAuthority) {
+		t.Errorf("got verify error %v, want an unknown authority", check.VerifyErr)
+	}
+	if check.OK() {
+		t.Error("an untrusted chain was OK")
+	}
+
+	opts.CertFile = pki.caFile
+	opts.TLS.PinnedKeys = []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}
+	check, err = CheckTLS(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.VerifyErr != nil {
+		t.Errorf("got verify error %v with the CA trusted", check.VerifyErr)
+	}
+	if !errors.Is(check.PinErr, ErrPinMismatch) || check.OK() {
+		t.Errorf("got pin error %v, want a mismatch", check.PinErr)
+	}
+
+	opts.TLS.PinnedKeys = []string{SPKIPin(pki.serverCert)}
+	check, err = CheckTLS(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.OK() {
+		t.Errorf("check with the CA trusted and the key pinned wasn't OK: %v, %v", check.VerifyErr, check.PinErr)
+	}
+}
+
+// A server that doesn't have the pinned key can still send the certificate
+// with it, after its own.
+func TestPinnedCertAppended(t *testing.T) {
+	pki := newTestPKI(t)
+	other := newTestPKI(t)
+	config := other.config.Clone()
+	config.Certificates = []tls.Certificate{config.Certificates[0]}
+	config.Certificates[0].Certificate = append(config.Certificates[0].Certificate, pki.serverCert.Raw, pki.caCert.Raw)
+
+	tests := []struct {
+		name    string
+		setOpts func(*LdapOpts)
+	}{
+		{"insecurely, pinned server key", func(o *LdapOpts) {
+			o.Insecure = Bool(true)
+			o.TLS.PinnedKeys = []string{SPKIPin(pki.serverCert)}
+		}},
+		{"insecurely, pinned CA key", func(o *LdapOpts) {
+			o.Insecure = Bool(true)
+			o.TLS.PinnedKeys = []string{SPKIPin(pki.caCert)}
+		}},
+		// Trusting both CAs, the other server's chain is verified, but it
+		//  doesn't go through the pinned keys.
+		{"both CAs trusted, pinned server key", func(o *LdapOpts) {
+			o.TLS.CAFiles = []string{pki.caFile, other.caFile}
+			o.TLS.PinnedKeys = []string{SPKIPin(pki.serverCert)}
+		}},
+		{"both CAs trusted, pinned CA key", func(o *LdapOpts) {
+			o.TLS.CAFiles = []string{pki.caFile, other.caFile}
+			o.TLS.PinnedKeys = []string{SPKIPin(pki.caCert)}
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := adtest.NewTLSServer(t, filepath.Join("testdata", "ad.ldif"), config, false)
+			opts := testOpts(server)
+			tc.setOpts(opts)
+
+			_, err := RunADSearch(opts, Eq("cn", "ccaaali"), []string{"cn"})
+			if !errors.Is(err, ErrPinMismatch) {
+				t.Errorf("got error %v, want %q", err, ErrPinMismatch)
+			}
+
+			check, err := CheckTLS(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(check.Chain) != 4 {
+				t.Errorf("got a chain of %d certificates, want 4", len(check.Chain))
+			}
+			if !errors.Is(check.PinErr, ErrPinMismatch) || check.OK() {
+				t.Errorf("got pin error %v, want a mismatch", check.PinErr)
+			}
+		})
+	}
+}
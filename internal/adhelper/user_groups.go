@@ -0,0 +1,68 @@
+package adhelper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrUserNotFound = errors.New("AD user not found")
+
+// A UserGroup is a group a user is in.
+type UserGroup struct {
+	Name   string
+	DN     string
+	Direct bool // Whether the user is a member themselves, rather than through a nested group.
+}
+
+// Returns the groups a user is in.
+func GetUserGroups(ldapOpts *LdapOpts, username string, nested bool) ([]UserGroup, error) {
+	client := NewClient(ldapOpts)
+	defer client.Close()
+	return client.GetUserGroups(username, nested)
+}
+
+// GetUserGroups returns the groups a user (by sAMAccountName) is in, sorted by
+// name. With nested, it includes the groups those groups are in, and so on.
+func (c *Client) GetUserGroups(username string, nested bool) ([]UserGroup, error) {
+	results, err := c.Search(Eq("sAMAccountName", username), []string{"memberOf"})
+	if err != nil {
+		return nil, err
+	}
+	if len(results.Entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if len(results.Entries) > 1 {
+		return nil, fmt.Errorf("more than one AD entry has sAMAccountName %s", username)
+	}
+	user := results.Entries[0]
+
+	groups := []UserGroup{}
+	direct := map[string]bool{}
+	for _, dn := range user.GetAttributeValues("memberOf") {
+		name, err := NameFromDN(dn)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, UserGroup{Name: name, DN: dn, Direct: true})
+		direct[normalizeDN(dn)] = true
+	}
+
+	// memberOf only has the groups the user is in directly. AD can follow the
+	//  rest of the chain itself, which saves a search for every level.
+	if nested {
+		results, err := c.Search(And(Eq("objectCategory", "Group"), InChain("member", user.DN)), []string{"cn"})
+		if err != nil {
+			return nil, fmt.Errorf("could not get nested groups of %s: %w", username, err)
+		}
+		for _, entry := range results.Entries {
+			if direct[normalizeDN(entry.DN)] {
+				continue
+			}
+			groups = append(groups, UserGroup{Name: entry.GetAttributeValue("cn"), DN: entry.DN})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}